@@ -0,0 +1,64 @@
+package run
+
+import (
+	"context"
+	"fmt"
+)
+
+const contextKeyApprover contextKey = "approver"
+
+// ApproveFunc decides whether a command flagged with (*Command).Destructive may run, for
+// example by prompting the user or consulting a policy service. It should return false
+// to decline the command, or an error if approval could not be determined.
+type ApproveFunc func(ctx context.Context, cmd ExecutedCommand) (bool, error)
+
+// RequireApproval configures all commands flagged with (*Command).Destructive that are
+// executed by sourcegraph/run within this context to only run once approved by approve.
+// Commands that are declined fail with a *DeclinedError. Commands that are not flagged
+// are not affected.
+//
+// The command provided to approve has secrets registered with RedactSecrets redacted.
+// Commands are not approved in contexts configured with DryRun, since they are not run.
+func RequireApproval(ctx context.Context, approve ApproveFunc) context.Context {
+	return context.WithValue(ctx, contextKeyApprover, approve)
+}
+
+// getApprover returns the ApproveFunc configured in ctx, or nil.
+func getApprover(ctx context.Context) ApproveFunc {
+	v, _ := ctx.Value(contextKeyApprover).(ApproveFunc)
+	return v
+}
+
+// Destructive flags the command as destructive, such that it must be approved before it
+// runs if its context is configured with RequireApproval.
+func (c *Command) Destructive() *Command {
+	c.destructive = true
+	return c
+}
+
+// DeclinedError is returned by commands flagged with (*Command).Destructive that were
+// declined by the ApproveFunc configured with RequireApproval.
+type DeclinedError struct {
+	// Command is the command that was declined.
+	Command ExecutedCommand
+}
+
+func (e *DeclinedError) Error() string {
+	return fmt.Sprintf("command was declined: %s", e.Command.commandLine())
+}
+
+// approve requests approval for cmd with the ApproveFunc configured in ctx, if any.
+func approve(ctx context.Context, cmd ExecutedCommand) error {
+	approveFunc := getApprover(ctx)
+	if approveFunc == nil {
+		return nil
+	}
+	approved, err := approveFunc(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if !approved {
+		return &DeclinedError{Command: cmd}
+	}
+	return nil
+}
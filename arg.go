@@ -1,8 +1,86 @@
 package run
 
-import "bitbucket.org/creachadair/shell"
+import (
+	"path/filepath"
+	"strings"
+
+	"bitbucket.org/creachadair/shell"
+)
 
 // Arg quotes a value such that it gets treated as an argument by a command.
 //
 // It is currently an alias for shell.Quote
 func Arg(v string) string { return shell.Quote(v) }
+
+// ArgList quotes each of the given values with Arg and joins them, such that each value
+// gets treated as a separate argument by a command. This is useful for arguments that
+// are computed at runtime, such as lists of file names.
+func ArgList(vs ...string) string {
+	quoted := make([]string, len(vs))
+	for i, v := range vs {
+		quoted[i] = Arg(v)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Path cleans a filesystem path with filepath.Clean and quotes it such that it gets
+// treated as an argument by a command.
+func Path(p string) string { return Arg(filepath.Clean(p)) }
+
+// Glob expands pattern to the paths that match it with filepath.Glob, and quotes each
+// of them such that they get treated as separate arguments by a command. This is useful
+// because patterns are not expanded by Cmd, unlike in a shell.
+//
+// As in a shell, if no paths match pattern or pattern is malformed, pattern is treated
+// as a single argument as-is.
+func Glob(pattern string) string {
+	matches := globMatches(pattern, "")
+	if len(matches) == 0 {
+		return Arg(pattern)
+	}
+	return ArgList(matches...)
+}
+
+// ExpandGlobs configures the command to expand arguments that are patterns, e.g. '*.go',
+// to the paths that match them with filepath.Glob when it is run, as a shell would.
+// Relative patterns are matched against the directory the command is run in. As in a
+// shell, patterns that match no paths or are malformed are passed to the command as-is.
+//
+// All arguments other than the command itself are expanded, including those quoted with
+// Arg. To expand only some arguments, use Glob instead.
+func (c *Command) ExpandGlobs() *Command {
+	c.expandGlobs = true
+	return c
+}
+
+// expandGlobArgs expands arguments after the first that are patterns with paths that
+// match them, relative to dir.
+func expandGlobArgs(args []string, dir string) []string {
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if i > 0 && strings.ContainsAny(arg, "*?[") {
+			if matches := globMatches(arg, dir); len(matches) > 0 {
+				expanded = append(expanded, matches...)
+				continue
+			}
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded
+}
+
+// globMatches returns the paths that match pattern, where relative patterns are matched
+// against dir and matches are returned relative to dir.
+func globMatches(pattern, dir string) []string {
+	if dir == "" || filepath.IsAbs(pattern) {
+		matches, _ := filepath.Glob(pattern)
+		return matches
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+	for i, m := range matches {
+		if rel, err := filepath.Rel(dir, m); err == nil {
+			matches[i] = rel
+		}
+	}
+	return matches
+}
@@ -0,0 +1,96 @@
+package run
+
+import "io"
+
+// Attachment configures where a stream of a command is sent. The zero value is
+// AttachCapture.
+type Attachment struct {
+	discard bool
+	writer  io.Writer
+}
+
+var (
+	// AttachCapture collects the stream into the command's Output.
+	AttachCapture = Attachment{}
+	// AttachDiscard discards the stream.
+	AttachDiscard = Attachment{discard: true}
+)
+
+// AttachWriter sends the stream directly to w, for example os.Stderr, without
+// collecting it into the command's Output.
+func AttachWriter(w io.Writer) Attachment {
+	if w == nil {
+		return AttachDiscard
+	}
+	return Attachment{writer: w}
+}
+
+// String returns a description of where the stream is sent.
+func (a Attachment) String() string {
+	switch {
+	case a.discard:
+		return "discard"
+	case a.writer != nil:
+		return "writer"
+	default:
+		return "capture"
+	}
+}
+
+// captured indicates if the stream is collected into the command's Output.
+func (a Attachment) captured() bool { return !a.discard && a.writer == nil }
+
+// AttachSpec configures where the stdout and stderr of a command are sent. The zero value
+// collects both into the command's Output, which is the default.
+//
+// Regardless of where stderr is sent, it is still included in errors from the command.
+type AttachSpec struct {
+	Stdout Attachment
+	Stderr Attachment
+}
+
+// Attach configures where the stdout and stderr of the command are sent, for example to
+// collect stdout into Output while sending stderr directly to os.Stderr:
+//
+//	run.Cmd(ctx, "make").Attach(run.AttachSpec{
+//		Stdout: run.AttachCapture,
+//		Stderr: run.AttachWriter(os.Stderr),
+//	})
+//
+// It replaces any configuration made with StdOut, StdErr, or Discard.
+func (c *Command) Attach(spec AttachSpec) *Command {
+	c.attach = spec
+	c.stderrTail = 0
+	return c
+}
+
+// writers returns the writers to use as stdout and stderr of a command, where output is
+// the writer that collects Output and stderrCopy retains stderr for errors. ordered
+// indicates if both streams should share a single writer when both are captured.
+func (s AttachSpec) writers(output, stderrCopy io.Writer, ordered bool) (stdout, stderr io.Writer) {
+	if ordered && s.Stdout.captured() && s.Stderr.captured() {
+		// Using the same writer for both makes exec.Cmd attach a single pipe to both
+		// stdout and stderr, preserving the order of writes.
+		combined := io.MultiWriter(stderrCopy, output)
+		return combined, combined
+	}
+
+	switch {
+	case s.Stdout.discard:
+		stdout = nil // discard
+	case s.Stdout.writer != nil:
+		stdout = s.Stdout.writer
+	default:
+		stdout = output
+	}
+
+	switch {
+	case s.Stderr.discard:
+		stderr = stderrCopy
+	case s.Stderr.writer != nil:
+		stderr = io.MultiWriter(stderrCopy, s.Stderr.writer)
+	default:
+		stderr = io.MultiWriter(stderrCopy, output)
+	}
+	return stdout, stderr
+}
@@ -0,0 +1,259 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrAuditLogTampered is returned by VerifyAuditLog if an audit log has been modified.
+var ErrAuditLogTampered = errors.New("audit log has been tampered with")
+
+// AuditEntry is a record of an executed command in an audit log.
+type AuditEntry struct {
+	// Sequence is the position of the entry in the audit log, starting at 0.
+	Sequence uint64 `json:"seq"`
+	// Time is when the command was executed.
+	Time time.Time `json:"time"`
+	// Args are the arguments of the command. The environment is omitted because it
+	// commonly contains sensitive information.
+	Args []string `json:"args"`
+	// Dir is the directory the command was executed in.
+	Dir string `json:"dir,omitempty"`
+	// InputDigest is the digest of the command's input, if it was captured with
+	// CaptureInput.
+	InputDigest string `json:"inputDigest,omitempty"`
+
+	// Previous is the hash of the previous entry, or empty for the first entry.
+	Previous string `json:"prev"`
+	// Hash is the keyed hash of this entry, including Previous, which chains each entry
+	// to all entries before it.
+	Hash string `json:"hash"`
+}
+
+// errAuditLogKeyRequired is returned when an audit log is used without a key.
+var errAuditLogKeyRequired = errors.New("audit log key must not be empty")
+
+// computeHash returns the HMAC-SHA256 of the entry with key, excluding the Hash field.
+func (e AuditEntry) computeHash(key []byte) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// AuditLog is an append-only, tamper-evident log of executed commands. Each entry is
+// written as a line of JSON that includes the hash of the previous entry, keyed with a
+// secret key, so that modification, removal, or reordering of entries by anyone who does
+// not have the key can be detected with VerifyAuditLog using the key. The key should be
+// kept separately from the log, since anyone who has it can rewrite the log.
+//
+// Log implements LogFunc, so an AuditLog can record all commands executed within a
+// context using LogCommands:
+//
+//	ctx = run.LogCommands(ctx, audit.Log)
+type AuditLog struct {
+	key   []byte
+	clock Clock
+
+	mux  sync.Mutex
+	dst  io.Writer
+	next uint64
+	prev string
+	err  error
+}
+
+// NewAuditLog creates an AuditLog that writes a new log to dst, keyed with key. If key
+// is empty, no entries are written and Err returns an error.
+func NewAuditLog(dst io.Writer, key []byte) *AuditLog {
+	log := &AuditLog{dst: dst, key: key, clock: systemClock{}}
+	if len(key) == 0 {
+		log.err = errAuditLogKeyRequired
+	}
+	return log
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if it does not
+// exist. Existing entries are verified with key before new entries are chained to them.
+// If the last line of the log is unterminated, for example because the process crashed
+// while writing an entry, it is discarded. The returned file should be closed when the
+// audit log is no longer needed.
+func OpenAuditLog(path string, key []byte) (*AuditLog, *os.File, error) {
+	if len(key) == 0 {
+		return nil, nil, errAuditLogKeyRequired
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := truncateUnterminatedLine(f); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("failed to discard partially written entry: %w", err)
+	}
+	last, err := VerifyAuditLog(f, key)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	log := NewAuditLog(f, key)
+	if last != nil {
+		log.next = last.Sequence + 1
+		log.prev = last.Hash
+	}
+	return log, f, nil
+}
+
+// truncateUnterminatedLine truncates f after its last newline, discarding an unterminated
+// last line.
+func truncateUnterminatedLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Search for the last newline backwards from the end of the file.
+	buf := make([]byte, 4096)
+	offset := info.Size()
+	for offset > 0 {
+		start := offset - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:offset-start]
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			offset = start + int64(i) + 1
+			break
+		}
+		offset = start
+	}
+	if offset == info.Size() {
+		return nil
+	}
+	return f.Truncate(offset)
+}
+
+// WithClock configures the audit log to use clock for the time of entries, for example
+// to make tests deterministic. Set to nil to use the system clock (default).
+func (l *AuditLog) WithClock(clock Clock) *AuditLog {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	l.clock = clock
+	return l
+}
+
+var _ LogFunc = (&AuditLog{}).Log
+
+// Log appends an entry for the executed command to the audit log. Errors writing the
+// entry can be retrieved with Err.
+func (l *AuditLog) Log(e ExecutedCommand) {
+	entry := AuditEntry{
+		Time: l.clock.Now().UTC(),
+		Args: e.Args,
+		Dir:  e.Dir,
+	}
+	if e.Input != nil {
+		entry.InputDigest = e.Input.Digest
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.err != nil {
+		return // the chain is broken, so do not write further entries
+	}
+
+	entry.Sequence = l.next
+	entry.Previous = l.prev
+	hash, err := entry.computeHash(l.key)
+	if err != nil {
+		l.err = err
+		return
+	}
+	entry.Hash = hash
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		l.err = err
+		return
+	}
+	if _, err := l.dst.Write(append(b, '\n')); err != nil {
+		l.err = fmt.Errorf("failed to write audit log entry: %w", err)
+		return
+	}
+	l.next++
+	l.prev = hash
+}
+
+// Err returns the first error that occurred when writing entries, if any. Once an error
+// has occurred, no further entries are written.
+func (l *AuditLog) Err() error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.err
+}
+
+// VerifyAuditLog reads an audit log written by AuditLog from src, and checks with the
+// log's key that no entries have been modified, removed, or reordered. It returns the
+// last entry in the log, or nil if the log is empty. If the log has been tampered with,
+// the returned error matches ErrAuditLogTampered.
+//
+// Note that removal of entries from the end of the log can only be detected by comparing
+// the hash of the last entry against a copy kept elsewhere.
+func VerifyAuditLog(src io.Reader, key []byte) (*AuditEntry, error) {
+	if len(key) == 0 {
+		return nil, errAuditLogKeyRequired
+	}
+	var last *AuditEntry
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid entry: %s", ErrAuditLogTampered, line, err)
+		}
+		var wantSequence uint64
+		var wantPrevious string
+		if last != nil {
+			wantSequence = last.Sequence + 1
+			wantPrevious = last.Hash
+		}
+		if entry.Sequence != wantSequence || entry.Previous != wantPrevious {
+			return nil, fmt.Errorf("%w: line %d: entry is not chained to the previous entry",
+				ErrAuditLogTampered, line)
+		}
+		hash, err := entry.computeHash(key)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal([]byte(hash), []byte(entry.Hash)) {
+			return nil, fmt.Errorf("%w: line %d: entry has been modified", ErrAuditLogTampered, line)
+		}
+
+		last = &entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return last, nil
+}
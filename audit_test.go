@@ -0,0 +1,130 @@
+package run_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/sourcegraph/run"
+)
+
+func TestAuditLog(t *testing.T) {
+	c := qt.New(t)
+	key := []byte("audit-key")
+
+	writeLog := func(c *qt.C) []string {
+		var buf bytes.Buffer
+		audit := run.NewAuditLog(&buf, key)
+		ctx := run.LogCommands(context.Background(), audit.Log)
+		for _, cmd := range []string{"echo hello", "echo world", "true"} {
+			c.Assert(run.Cmd(ctx, cmd).Run().Wait(), qt.IsNil)
+		}
+		c.Assert(audit.Err(), qt.IsNil)
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+	verify := func(lines []string) (*run.AuditEntry, error) {
+		return run.VerifyAuditLog(strings.NewReader(strings.Join(lines, "\n")), key)
+	}
+
+	c.Run("valid", func(c *qt.C) {
+		last, err := verify(writeLog(c))
+		c.Assert(err, qt.IsNil)
+		c.Assert(last.Sequence, qt.Equals, uint64(2))
+		c.Assert(last.Args, qt.CmpEquals(), []string{"true"})
+	})
+
+	c.Run("modified", func(c *qt.C) {
+		lines := writeLog(c)
+		lines[1] = strings.Replace(lines[1], "world", "WORLD", 1)
+		_, err := verify(lines)
+		c.Assert(errors.Is(err, run.ErrAuditLogTampered), qt.IsTrue)
+	})
+
+	c.Run("modified with recomputed hashes", func(c *qt.C) {
+		// Without the key, recomputing the chain does not produce valid hashes.
+		lines := writeLog(c)
+		var buf bytes.Buffer
+		forged := run.NewAuditLog(&buf, []byte("other-key"))
+		ctx := run.LogCommands(context.Background(), forged.Log)
+		for _, cmd := range []string{"echo hello", "echo WORLD", "true"} {
+			c.Assert(run.Cmd(ctx, cmd).Run().Wait(), qt.IsNil)
+		}
+		lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+		_, err := verify(lines)
+		c.Assert(errors.Is(err, run.ErrAuditLogTampered), qt.IsTrue)
+	})
+
+	c.Run("removed", func(c *qt.C) {
+		lines := writeLog(c)
+		_, err := verify(append(lines[:1], lines[2:]...))
+		c.Assert(errors.Is(err, run.ErrAuditLogTampered), qt.IsTrue)
+	})
+
+	c.Run("reopened", func(c *qt.C) {
+		path := filepath.Join(c.TempDir(), "audit.log")
+		for i := 0; i < 2; i++ {
+			audit, f, err := run.OpenAuditLog(path, key)
+			c.Assert(err, qt.IsNil)
+			ctx := run.LogCommands(context.Background(), audit.Log)
+			c.Assert(run.Cmd(ctx, "true").Run().Wait(), qt.IsNil)
+			c.Assert(audit.Err(), qt.IsNil)
+			c.Assert(f.Close(), qt.IsNil)
+		}
+
+		f, err := os.Open(path)
+		c.Assert(err, qt.IsNil)
+		defer f.Close()
+		last, err := run.VerifyAuditLog(f, key)
+		c.Assert(err, qt.IsNil)
+		c.Assert(last.Sequence, qt.Equals, uint64(1))
+	})
+
+	c.Run("reopened after partial write", func(c *qt.C) {
+		path := filepath.Join(c.TempDir(), "audit.log")
+		audit, f, err := run.OpenAuditLog(path, key)
+		c.Assert(err, qt.IsNil)
+		audit.Log(run.ExecutedCommand{Args: []string{"true"}})
+		c.Assert(audit.Err(), qt.IsNil)
+		_, err = f.WriteString(`{"seq":1,"ti`)
+		c.Assert(err, qt.IsNil)
+		c.Assert(f.Close(), qt.IsNil)
+
+		audit, f, err = run.OpenAuditLog(path, key)
+		c.Assert(err, qt.IsNil)
+		audit.Log(run.ExecutedCommand{Args: []string{"false"}})
+		c.Assert(audit.Err(), qt.IsNil)
+		c.Assert(f.Close(), qt.IsNil)
+
+		f, err = os.Open(path)
+		c.Assert(err, qt.IsNil)
+		defer f.Close()
+		last, err := run.VerifyAuditLog(f, key)
+		c.Assert(err, qt.IsNil)
+		c.Assert(last.Sequence, qt.Equals, uint64(1))
+		c.Assert(last.Args, qt.DeepEquals, []string{"false"})
+	})
+
+	c.Run("clock", func(c *qt.C) {
+		now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		var buf bytes.Buffer
+		audit := run.NewAuditLog(&buf, key).WithClock(&fixedClock{now: now})
+		audit.Log(run.ExecutedCommand{Args: []string{"true"}})
+		c.Assert(audit.Err(), qt.IsNil)
+
+		last, err := run.VerifyAuditLog(&buf, key)
+		c.Assert(err, qt.IsNil)
+		c.Assert(last.Time, qt.Equals, now)
+	})
+
+	c.Run("key required", func(c *qt.C) {
+		audit := run.NewAuditLog(&bytes.Buffer{}, nil)
+		audit.Log(run.ExecutedCommand{Args: []string{"true"}})
+		c.Assert(audit.Err(), qt.IsNotNil)
+	})
+}
@@ -0,0 +1,135 @@
+package run
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore stores content-addressed blobs, for example on a filesystem or in an
+// S3-compatible object store. It is used by Output.StoreTo.
+type BlobStore interface {
+	// NewBlob starts writing a new blob. The content is only made available once it is
+	// committed with BlobWriter.Commit.
+	NewBlob() (BlobWriter, error)
+}
+
+// BlobWriter receives the content of a blob from BlobStore.NewBlob. Exactly one of Commit
+// or Abort is called once all content is written.
+type BlobWriter interface {
+	io.Writer
+	// Commit makes the content written available under digest, the hex-encoded SHA-256
+	// digest of the content. If a blob with the same digest already exists, Commit may
+	// discard the content written.
+	Commit(digest string) error
+	// Abort discards the content written.
+	Abort() error
+}
+
+// FileBlobStore is a BlobStore that stores blobs as files named by their digest in a
+// directory. It is safe for concurrent use, including by multiple processes.
+type FileBlobStore struct {
+	dir string
+}
+
+var _ BlobStore = &FileBlobStore{}
+
+// NewFileBlobStore creates a FileBlobStore in dir, which is created if it does not exist.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob store: %w", err)
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+// NewBlob starts writing a new blob to a temporary file in the store.
+func (s *FileBlobStore) NewBlob() (BlobWriter, error) {
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileBlobWriter{File: tmp, store: s}, nil
+}
+
+// Open opens the blob stored under digest.
+func (s *FileBlobStore) Open(digest string) (io.ReadCloser, error) {
+	path, err := s.path(digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// path returns the path of the blob for digest, and validates digest such that it cannot
+// be used to refer to files outside the store.
+func (s *FileBlobStore) path(digest string) (string, error) {
+	if !isDigest(digest) {
+		return "", fmt.Errorf("invalid blob digest %q", digest)
+	}
+	return filepath.Join(s.dir, digest), nil
+}
+
+type fileBlobWriter struct {
+	*os.File
+	store *FileBlobStore
+}
+
+func (w *fileBlobWriter) Commit(digest string) error {
+	defer os.Remove(w.Name())
+	if err := w.Close(); err != nil {
+		return err
+	}
+	path, err := w.store.path(digest)
+	if err != nil {
+		return err
+	}
+	// Rename so that readers never observe a partial blob.
+	return os.Rename(w.Name(), path)
+}
+
+func (w *fileBlobWriter) Abort() error {
+	w.Close()
+	return os.Remove(w.Name())
+}
+
+// blobDigestWriter writes to a BlobWriter while computing the digest of the content
+// written, and records errors from the BlobWriter.
+type blobDigestWriter struct {
+	blob   BlobWriter
+	digest hash.Hash
+	err    error
+}
+
+func (w *blobDigestWriter) Write(p []byte) (int, error) {
+	n, err := w.blob.Write(p)
+	w.digest.Write(p[:n])
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// storeBlob writes output to a new blob in blobs with write, and returns its digest. The
+// blob is committed even if write returns an error, as long as the blob itself was
+// written successfully, so that output collected before an error is stored.
+func storeBlob(blobs BlobStore, write func(dst io.Writer) error) (string, error) {
+	blob, err := blobs.NewBlob()
+	if err != nil {
+		return "", err
+	}
+	w := &blobDigestWriter{blob: blob, digest: sha256.New()}
+	writeErr := write(w)
+	if w.err != nil {
+		_ = blob.Abort()
+		return "", w.err
+	}
+	digest := hex.EncodeToString(w.digest.Sum(nil))
+	if err := blob.Commit(digest); err != nil {
+		return "", err
+	}
+	return digest, writeErr
+}
@@ -0,0 +1,82 @@
+package run_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/sourcegraph/run"
+)
+
+func TestStoreTo(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	readBlob := func(c *qt.C, blobs *run.FileBlobStore, digest string) string {
+		f, err := blobs.Open(digest)
+		c.Assert(err, qt.IsNil)
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		c.Assert(err, qt.IsNil)
+		return string(b)
+	}
+
+	c.Run("stores output by digest", func(c *qt.C) {
+		blobs, err := run.NewFileBlobStore(c.TempDir())
+		c.Assert(err, qt.IsNil)
+
+		digest, err := run.Cmd(ctx, "echo hello world").Run().StoreTo(blobs)
+		c.Assert(err, qt.IsNil)
+
+		sum := sha256.Sum256([]byte("hello world\n"))
+		c.Assert(digest, qt.Equals, hex.EncodeToString(sum[:]))
+		c.Assert(readBlob(c, blobs, digest), qt.Equals, "hello world\n")
+	})
+
+	c.Run("stores output collected before error", func(c *qt.C) {
+		blobs, err := run.NewFileBlobStore(c.TempDir())
+		c.Assert(err, qt.IsNil)
+
+		digest, err := run.Bash(ctx, "echo partial; exit 2").Run().StoreTo(blobs)
+		c.Assert(run.ExitCode(err), qt.Equals, 2)
+		c.Assert(readBlob(c, blobs, digest), qt.Equals, "partial\n")
+	})
+
+	c.Run("aborts on store error", func(c *qt.C) {
+		errStore := errors.New("store failed")
+		blobs := &failingBlobStore{err: errStore}
+
+		digest, err := run.Cmd(ctx, "echo hello").Run().StoreTo(blobs)
+		c.Assert(err, qt.ErrorIs, errStore)
+		c.Assert(digest, qt.Equals, "")
+		c.Assert(blobs.aborted, qt.IsTrue)
+	})
+
+	c.Run("rejects invalid digests", func(c *qt.C) {
+		blobs, err := run.NewFileBlobStore(c.TempDir())
+		c.Assert(err, qt.IsNil)
+
+		_, err = blobs.Open("../secret")
+		c.Assert(err, qt.ErrorMatches, `invalid blob digest.*`)
+	})
+}
+
+type failingBlobStore struct {
+	err     error
+	aborted bool
+}
+
+func (s *failingBlobStore) NewBlob() (run.BlobWriter, error) { return s, nil }
+
+func (s *failingBlobStore) Write([]byte) (int, error) { return 0, s.err }
+
+func (s *failingBlobStore) Commit(string) error { return errors.New("unexpected commit") }
+
+func (s *failingBlobStore) Abort() error {
+	s.aborted = true
+	return nil
+}
@@ -0,0 +1,120 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+const contextKeyMemoryBudget contextKey = "memoryBudget"
+
+// OverBudgetError is returned by aggregation functions on Output when a command emits
+// more output than the memory budget configured with MemoryBudget allows.
+type OverBudgetError struct {
+	// Budget is the configured budget, in bytes.
+	Budget int64
+}
+
+func (e *OverBudgetError) Error() string {
+	return fmt.Sprintf("output exceeded memory budget of %d bytes", e.Budget)
+}
+
+// MemoryBudget limits the amount of output, in bytes, that functions on Output that
+// aggregate output in memory (Lines, String, and JQ) will collect for each command run
+// within this context. If a command emits more output than the budget, the command is
+// terminated and the aggregation function returns an *OverBudgetError instead of
+// continuing to buffer output.
+//
+// Output is counted before any Map or Pipeline is applied. Streaming functions, such as
+// Stream, StreamLines, and Read, are not subject to the budget. Set to 0 to disable
+// (default).
+func MemoryBudget(ctx context.Context, maxBytes int64) context.Context {
+	return context.WithValue(ctx, contextKeyMemoryBudget, maxBytes)
+}
+
+// getMemoryBudget returns the memory budget configured in ctx, or 0 if there is none.
+func getMemoryBudget(ctx context.Context) int64 {
+	v, _ := ctx.Value(contextKeyMemoryBudget).(int64)
+	return v
+}
+
+// budgetedReader counts bytes read from reader, and returns an *OverBudgetError if more
+// than budget bytes are available while aggregating is set.
+type budgetedReader struct {
+	reader io.Reader
+	budget int64
+
+	// total, newlines, and partial track all output read for Output.BytesRead and
+	// Output.LinesEmitted, and should only be accessed atomically.
+	total    int64
+	newlines int64
+	// partial is set to 1 if the last byte read was not a newline.
+	partial int32
+
+	// aggregating indicates reads are being collected in memory, and should be counted
+	// against the budget.
+	aggregating bool
+	read        int64
+	exceeded    bool
+}
+
+func (b *budgetedReader) Read(p []byte) (n int, err error) {
+	defer func() { b.count(p[:n]) }()
+
+	if !b.aggregating || b.budget <= 0 {
+		return b.reader.Read(p)
+	}
+
+	remaining := b.budget - b.read
+	if remaining <= 0 {
+		// Check if there is any more output at all before erroring.
+		n, err := b.reader.Read(make([]byte, 1))
+		if n > 0 {
+			b.exceeded = true
+			return 0, &OverBudgetError{Budget: b.budget}
+		}
+		return 0, err
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err = b.reader.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// count records p as read for bytesRead and linesRead.
+func (b *budgetedReader) count(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	atomic.AddInt64(&b.total, int64(len(p)))
+	atomic.AddInt64(&b.newlines, int64(bytes.Count(p, []byte{'\n'})))
+	if p[len(p)-1] == '\n' {
+		atomic.StoreInt32(&b.partial, 0)
+	} else {
+		atomic.StoreInt32(&b.partial, 1)
+	}
+}
+
+// bytesRead returns the number of bytes read so far. It is safe to call concurrently
+// with Read.
+func (b *budgetedReader) bytesRead() int64 { return atomic.LoadInt64(&b.total) }
+
+// linesRead returns the number of lines read so far, including a trailing line that is
+// not terminated by a newline. It is safe to call concurrently with Read.
+func (b *budgetedReader) linesRead() int64 {
+	return atomic.LoadInt64(&b.newlines) + int64(atomic.LoadInt32(&b.partial))
+}
+
+// checkError returns an *OverBudgetError if the budget was exceeded, since consumers of
+// the reader may surface a different error from the truncated output, for example a JSON
+// syntax error. Otherwise err is returned as-is.
+func (b *budgetedReader) checkError(err error) error {
+	if err != nil && b.exceeded {
+		return &OverBudgetError{Budget: b.budget}
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package run_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/sourcegraph/run"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	c := qt.New(t)
+	ctx := run.MemoryBudget(context.Background(), 1024)
+
+	c.Run("within budget", func(c *qt.C) {
+		res, err := run.Cmd(ctx, "echo", "hello world").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(res, qt.Equals, "hello world")
+	})
+
+	c.Run("over budget", func(c *qt.C) {
+		for name, aggregate := range map[string]func(run.Output) error{
+			"Lines":  func(o run.Output) error { _, err := o.Lines(); return err },
+			"String": func(o run.Output) error { _, err := o.String(); return err },
+			"JQ":     func(o run.Output) error { _, err := o.JQ("."); return err },
+		} {
+			c.Run(name, func(c *qt.C) {
+				err := aggregate(run.Bash(ctx, `printf '{"a":"%02000d"}' 0`).Run())
+				var overBudget *run.OverBudgetError
+				c.Assert(errors.As(err, &overBudget), qt.IsTrue, qt.Commentf("got %v", err))
+				c.Assert(overBudget.Budget, qt.Equals, int64(1024))
+			})
+		}
+	})
+
+	c.Run("command is terminated", func(c *qt.C) {
+		p, err := run.Cmd(ctx, "yes").Start()
+		c.Assert(err, qt.IsNil)
+
+		_, err = p.Output().String()
+		var overBudget *run.OverBudgetError
+		c.Assert(errors.As(err, &overBudget), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(p.Kill(), qt.ErrorIs, os.ErrProcessDone)
+	})
+
+	c.Run("streaming is not limited", func(c *qt.C) {
+		err := run.Cmd(ctx, "cat", "./LICENSE").Run().StreamLines(func(string) {})
+		c.Assert(err, qt.IsNil)
+	})
+}
@@ -1,9 +1,18 @@
 package run
 
 import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+
 	"github.com/djherbis/buffer"
 )
 
+const contextKeySpillCodec contextKey = "spillCodec"
+
 // maxBufferSize denotes the maximum size of each buffer. Overflows are written to disk
 // at increments of this size.
 var maxBufferSize int64 = 128 * 1024
@@ -11,7 +20,229 @@ var maxBufferSize int64 = 128 * 1024
 // bufferPool will never return an error.
 //
 // Uses unbounded buffers that create files of size fileBuffersSize to store overflow.
-func makeUnboundedBuffer() buffer.Buffer {
+// If a SpillCodec is configured in ctx, overflow is encoded with the codec.
+func makeUnboundedBuffer(ctx context.Context) buffer.Buffer {
 	fileBuffersSize := maxBufferSize / int64(4)
+	if codec := getSpillCodec(ctx); codec != nil {
+		return buffer.NewMulti(
+			buffer.New(maxBufferSize),
+			&codecSpillBuffer{codec: codec, size: fileBuffersSize, pending: buffer.New(fileBuffersSize)})
+	}
 	return buffer.NewUnboundedBuffer(maxBufferSize, fileBuffersSize)
 }
+
+// SpillCodec encodes output that is buffered to disk when a command produces more output
+// than can be buffered in memory before it is consumed, for example to compress large
+// amounts of output.
+type SpillCodec interface {
+	// NewWriter returns a writer that encodes content written to it to dst.
+	NewWriter(dst io.Writer) (io.WriteCloser, error)
+	// NewReader returns a reader that decodes content encoded by a writer from src.
+	NewReader(src io.Reader) (io.ReadCloser, error)
+}
+
+// GzipSpillCodec is a SpillCodec that compresses output buffered to disk with gzip.
+var GzipSpillCodec SpillCodec = gzipSpillCodec{}
+
+type gzipSpillCodec struct{}
+
+func (gzipSpillCodec) NewWriter(dst io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(dst, gzip.BestSpeed)
+}
+
+func (gzipSpillCodec) NewReader(src io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(src)
+}
+
+// WithSpillCodec configures all commands executed by sourcegraph/run within this context
+// to encode output that is buffered to disk with the given codec, e.g. GzipSpillCodec.
+// Set to nil to store buffered output as-is (default).
+//
+// Output buffered to disk is decoded incrementally as it is consumed, and each file is
+// removed once it has been read, so operations that stream output such as StreamLines,
+// or JQ with queries that iterate over their input, do not load it back into memory.
+func WithSpillCodec(ctx context.Context, codec SpillCodec) context.Context {
+	return context.WithValue(ctx, contextKeySpillCodec, codec)
+}
+
+// getSpillCodec returns the codec configured in ctx, or nil.
+func getSpillCodec(ctx context.Context) SpillCodec {
+	v, _ := ctx.Value(contextKeySpillCodec).(SpillCodec)
+	return v
+}
+
+// codecSpillBuffer is an unbounded buffer.Buffer that encodes content to files of up to
+// size bytes with codec. Encoded content can only be decoded once it is complete, so
+// content is held in pending until size bytes have been written, and only then encoded
+// to a file - this way, reads of content that is still being written do not need to
+// complete a file early.
+type codecSpillBuffer struct {
+	codec SpillCodec
+	size  int64
+
+	// files are complete, in the order they were written.
+	files []*codecFileBuffer
+	// pending is content written after files, which has not been encoded yet.
+	pending buffer.Buffer
+}
+
+func (b *codecSpillBuffer) Len() int64 {
+	n := b.pending.Len()
+	for _, f := range b.files {
+		n += f.Len()
+	}
+	return n
+}
+
+func (b *codecSpillBuffer) Cap() int64 { return math.MaxInt64 }
+
+func (b *codecSpillBuffer) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		m, err := b.pending.Write(p)
+		n += m
+		p = p[m:]
+		if err != nil && err != io.ErrShortWrite {
+			return n, err
+		}
+		if buffer.Full(b.pending) {
+			if err := b.spill(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// spill encodes pending to a new file.
+func (b *codecSpillBuffer) spill() error {
+	file, err := newCodecFileBuffer(b.codec)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, b.pending); err != nil {
+		file.remove()
+		return err
+	}
+	if err := file.seal(); err != nil {
+		file.remove()
+		return err
+	}
+	b.files = append(b.files, file)
+	return nil
+}
+
+func (b *codecSpillBuffer) Read(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 && len(b.files) > 0 {
+		m, err := b.files[0].Read(p)
+		n += m
+		p = p[m:]
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if b.files[0].Len() == 0 {
+			b.files[0].remove()
+			b.files = b.files[1:]
+		}
+	}
+	if len(p) > 0 {
+		m, err := b.pending.Read(p)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (b *codecSpillBuffer) Reset() {
+	for _, f := range b.files {
+		f.remove()
+	}
+	b.files = nil
+	b.pending.Reset()
+}
+
+// codecFileBuffer is content encoded to a file with codec. Content is written to the
+// file until it is sealed, after which it can be read.
+type codecFileBuffer struct {
+	codec SpillCodec
+	file  *os.File
+
+	// writer is set until the buffer is sealed, after which reader is set.
+	writer io.WriteCloser
+	reader io.ReadCloser
+
+	written int64
+	read    int64
+}
+
+func newCodecFileBuffer(codec SpillCodec) (*codecFileBuffer, error) {
+	file, err := os.CreateTemp("", "buffer")
+	if err != nil {
+		return nil, err
+	}
+	writer, err := codec.NewWriter(file)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &codecFileBuffer{codec: codec, file: file, writer: writer}, nil
+}
+
+func (b *codecFileBuffer) Len() int64 { return b.written - b.read }
+
+func (b *codecFileBuffer) Write(p []byte) (int, error) {
+	n, err := b.writer.Write(p)
+	b.written += int64(n)
+	return n, err
+}
+
+func (b *codecFileBuffer) Read(p []byte) (int, error) {
+	if b.Len() == 0 {
+		return 0, io.EOF
+	}
+	if remaining := b.Len(); int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.reader.Read(p)
+	b.read += int64(n)
+	if err == io.EOF {
+		if b.Len() > 0 {
+			return n, errors.New("spilled output is truncated")
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// seal completes the encoded content and prepares it for reading.
+func (b *codecFileBuffer) seal() error {
+	err := b.writer.Close()
+	b.writer = nil
+	if err != nil {
+		return err
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	b.reader, err = b.codec.NewReader(b.file)
+	return err
+}
+
+// remove closes and removes the file.
+func (b *codecFileBuffer) remove() {
+	if b.writer != nil {
+		b.writer.Close()
+	}
+	if b.reader != nil {
+		b.reader.Close()
+	}
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
@@ -0,0 +1,111 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSpillCodec(t *testing.T) {
+	c := qt.New(t)
+	ctx := WithSpillCodec(context.Background(), GzipSpillCodec)
+
+	// Generate enough content to spill to disk several times over.
+	content := make([]byte, 4*maxBufferSize)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	c.Run("write then read", func(c *qt.C) {
+		buf := makeUnboundedBuffer(ctx)
+		defer buf.Reset()
+
+		n, err := buf.Write(content)
+		c.Assert(err, qt.IsNil)
+		c.Assert(n, qt.Equals, len(content))
+		c.Assert(buf.Len(), qt.Equals, int64(len(content)))
+
+		got, err := io.ReadAll(buf)
+		c.Assert(err, qt.IsNil)
+		c.Assert(bytes.Equal(got, content), qt.IsTrue)
+	})
+
+	c.Run("interleaved", func(c *qt.C) {
+		buf := makeUnboundedBuffer(ctx)
+		defer buf.Reset()
+
+		var got bytes.Buffer
+		p := make([]byte, 7000)
+		for remaining := content; len(remaining) > 0; {
+			chunk := 10000
+			if chunk > len(remaining) {
+				chunk = len(remaining)
+			}
+			_, err := buf.Write(remaining[:chunk])
+			c.Assert(err, qt.IsNil)
+			remaining = remaining[chunk:]
+
+			n, err := buf.Read(p)
+			c.Assert(err, qt.IsNil)
+			got.Write(p[:n])
+		}
+		rest, err := io.ReadAll(buf)
+		c.Assert(err, qt.IsNil)
+		got.Write(rest)
+		c.Assert(bytes.Equal(got.Bytes(), content), qt.IsTrue)
+	})
+
+	c.Run("reads keep up with writes", func(c *qt.C) {
+		dir := c.TempDir()
+		c.Setenv("TMPDIR", dir)
+		files := map[string]struct{}{}
+		recordFiles := func() {
+			entries, err := os.ReadDir(dir)
+			c.Assert(err, qt.IsNil)
+			for _, e := range entries {
+				files[e.Name()] = struct{}{}
+			}
+		}
+
+		buf := makeUnboundedBuffer(ctx)
+		defer buf.Reset()
+
+		// Get ahead of reads by more than can be buffered in memory, then read as much
+		// as is written.
+		var got bytes.Buffer
+		_, err := buf.Write(content[:maxBufferSize+5000])
+		c.Assert(err, qt.IsNil)
+		recordFiles()
+		p := make([]byte, 1000)
+		for remaining := content[maxBufferSize+5000:]; len(remaining) > 0; {
+			chunk := len(p)
+			if chunk > len(remaining) {
+				chunk = len(remaining)
+			}
+			_, err := buf.Write(remaining[:chunk])
+			c.Assert(err, qt.IsNil)
+			remaining = remaining[chunk:]
+			recordFiles()
+
+			n, err := buf.Read(p)
+			c.Assert(err, qt.IsNil)
+			got.Write(p[:n])
+			recordFiles()
+		}
+		rest, err := io.ReadAll(buf)
+		c.Assert(err, qt.IsNil)
+		got.Write(rest)
+		c.Assert(bytes.Equal(got.Bytes(), content), qt.IsTrue)
+
+		// Only full files are written.
+		fileBuffersSize := maxBufferSize / 4
+		c.Assert(len(files) <= int(int64(len(content))/fileBuffersSize), qt.IsTrue,
+			qt.Commentf("%d files", len(files)))
+		entries, err := os.ReadDir(dir)
+		c.Assert(err, qt.IsNil)
+		c.Assert(entries, qt.HasLen, 0)
+	})
+}
@@ -0,0 +1,158 @@
+package run
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// redactedValue replaces the values of sensitive environment variables in support
+// bundles.
+const redactedValue = "REDACTED"
+
+// sensitiveEnvKeys are substrings of environment variable names whose values are
+// redacted from support bundles.
+var sensitiveEnvKeys = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "KEY", "CREDENTIAL", "AUTH"}
+
+// supportBundleVersionTimeout is how long to wait for each binary to report its version.
+var supportBundleVersionTimeout = 5 * time.Second
+
+// SupportBundle writes a zip archive to w with information that is useful for attaching
+// to bug reports from tools built on sourcegraph/run:
+//
+//   - system.txt: the operating system, architecture, and Go version
+//   - environment.txt: the environment of the current process
+//   - history.sh: commands executed within ctx, if configured with History
+//   - errors.txt: the errors of commands in the history that failed, which include the
+//     retained tail of their stderr
+//   - commands.txt: the report of a CommandRegistry configured with RecordCommands
+//   - versions.txt: the first line of the output of '--version' for each binary in
+//     versionOf
+//
+// Querying versions executes the given binaries, so versionOf should only include
+// binaries that are known to support '--version', such as the tools a program wraps.
+// Binaries from the history are never executed.
+//
+// Values of environment variables with names that suggest they are sensitive, such as
+// those containing TOKEN or SECRET, are redacted. Arguments are not redacted.
+func SupportBundle(ctx context.Context, w io.Writer, versionOf ...string) error {
+	archive := zip.NewWriter(w)
+	add := func(name string, write func(io.Writer) error) error {
+		f, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		return write(f)
+	}
+
+	if err := add("system.txt", func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "os: %s\narch: %s\ngo: %s\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := add("environment.txt", func(w io.Writer) error {
+		environ := redactEnviron(os.Environ())
+		sort.Strings(environ)
+		for _, kv := range environ {
+			if _, err := fmt.Fprintln(w, kv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if history := getCommandHistory(ctx); history != nil {
+		redacted := &CommandHistory{}
+		for _, e := range history.Commands() {
+			e.Environ = redactEnviron(e.Environ)
+			redacted.record(e)
+		}
+		if err := add("history.sh", redacted.Script); err != nil {
+			return err
+		}
+		if err := add("errors.txt", func(w io.Writer) error {
+			for _, f := range history.failures() {
+				f.command.Environ = redactEnviron(f.command.Environ)
+				if _, err := fmt.Fprintf(w, "$ %s\n%s\n\n", f.command.String(), f.err); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if len(versionOf) > 0 {
+		if err := add("versions.txt", func(w io.Writer) error {
+			return writeVersions(ctx, w, versionOf)
+		}); err != nil {
+			return err
+		}
+	}
+
+	if registry := getCommandRegistry(ctx); registry != nil {
+		if err := add("commands.txt", registry.Report); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// redactEnviron returns a copy of environ with the values of sensitive variables
+// redacted.
+func redactEnviron(environ []string) []string {
+	redacted := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		k, _, _ := strings.Cut(kv, "=")
+		upper := strings.ToUpper(k)
+		for _, sensitive := range sensitiveEnvKeys {
+			if strings.Contains(upper, sensitive) {
+				kv = k + "=" + redactedValue
+				break
+			}
+		}
+		redacted = append(redacted, kv)
+	}
+	return redacted
+}
+
+// writeVersions writes the first line of the output of '--version' for each distinct
+// binary in names to w. Versions are not collected with sourcegraph/run, so that
+// collecting them is not recorded in the history or logs.
+func writeVersions(ctx context.Context, w io.Writer, names []string) error {
+	seen := make(map[string]struct{})
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		version := "unknown"
+		if path, err := exec.LookPath(name); err == nil {
+			ctx, cancel := context.WithTimeout(ctx, supportBundleVersionTimeout)
+			out, err := exec.CommandContext(ctx, path, "--version").Output()
+			cancel()
+			if err == nil {
+				if line, _, _ := strings.Cut(string(out), "\n"); strings.TrimSpace(line) != "" {
+					version = strings.TrimSpace(line)
+				}
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", name, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
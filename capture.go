@@ -0,0 +1,117 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+const contextKeyInputCapture contextKey = "inputCapture"
+
+// CapturedInput is input provided to a command with (*Command).Input, captured when
+// enabled with CaptureInput.
+type CapturedInput struct {
+	// Content is the captured input, up to the configured maximum size and redacted if
+	// configured.
+	Content []byte
+	// Digest is the hex-encoded SHA-256 digest of the captured input before redaction,
+	// which can be used to identify identical input.
+	Digest string
+	// Truncated indicates that the input was larger than the configured maximum size,
+	// in which case Content and Digest only cover the first part of the input.
+	Truncated bool
+}
+
+// RedactFunc can be used to redact sensitive information from captured input.
+type RedactFunc func(input []byte) []byte
+
+type inputCapture struct {
+	maxBytes int
+	redact   RedactFunc
+}
+
+// CaptureInput enables capturing input provided with (*Command).Input for all commands
+// executed by sourcegraph/run within this context, which is then available in
+// ExecutedCommand, for example in LogCommands. This is useful when input is the
+// interesting part of a command, e.g. 'kubectl apply -f -'.
+//
+// Up to maxBytes of input are captured, and input is read before the command is started.
+// Only input provided with InputString, InputBytes, InputFile, or as a *bytes.Reader,
+// *strings.Reader, or regular *os.File is captured. Any other input, such as a pipe, a
+// terminal, the output of another command, or input of commands with StdinPipe, is not
+// captured, since reading it before the command is started may block indefinitely. If
+// redact is not nil, it is applied to captured input. Set maxBytes to 0 to disable
+// (default).
+func CaptureInput(ctx context.Context, maxBytes int, redact RedactFunc) context.Context {
+	if maxBytes <= 0 {
+		return context.WithValue(ctx, contextKeyInputCapture, (*inputCapture)(nil))
+	}
+	return context.WithValue(ctx, contextKeyInputCapture, &inputCapture{
+		maxBytes: maxBytes,
+		redact:   redact,
+	})
+}
+
+// getInputCapture returns the input capture configured in ctx, or nil.
+func getInputCapture(ctx context.Context) *inputCapture {
+	v, _ := ctx.Value(contextKeyInputCapture).(*inputCapture)
+	return v
+}
+
+// capture reads up to maxBytes from input, and returns the captured input and a reader
+// that provides the entirety of input.
+func (c *inputCapture) capture(input io.Reader) (*CapturedInput, io.Reader) {
+	// Read an additional byte to determine if input has been truncated.
+	head := make([]byte, c.maxBytes+1)
+	n, err := io.ReadFull(input, head)
+	head = head[:n]
+	switch err {
+	case nil:
+		input = io.MultiReader(bytes.NewReader(head), input)
+	case io.EOF, io.ErrUnexpectedEOF:
+		input = bytes.NewReader(head)
+	default:
+		input = io.MultiReader(bytes.NewReader(head), errorReader{err: err})
+	}
+
+	captured := &CapturedInput{Truncated: len(head) > c.maxBytes}
+	content := head
+	if captured.Truncated {
+		content = head[:c.maxBytes]
+	}
+	digest := sha256.Sum256(content)
+	captured.Digest = hex.EncodeToString(digest[:])
+	captured.Content = append([]byte(nil), content...)
+	if c.redact != nil {
+		captured.Content = c.redact(captured.Content)
+	}
+	return captured, input
+}
+
+// canCapture indicates if input made up of inputs can be captured before the command is
+// started without blocking until input is written to or closed, which is only the case
+// for input that is already in memory or read from regular files.
+func canCapture(inputs []io.Reader) bool {
+	for _, input := range inputs {
+		var info os.FileInfo
+		var err error
+		switch input := input.(type) {
+		case *bytes.Reader, *strings.Reader:
+			continue
+		case *os.File:
+			info, err = input.Stat()
+		case *lazyFile:
+			info, err = os.Stat(input.path)
+		default:
+			return false
+		}
+		if err != nil || !info.Mode().IsRegular() {
+			return false
+		}
+	}
+	return true
+}
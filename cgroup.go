@@ -0,0 +1,164 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CgroupLimits configures the resource limits of a command run in a transient cgroup
+// with Cgroup.
+type CgroupLimits struct {
+	// CPUs is the maximum number of CPUs the command can use, for example 0.5 to limit
+	// the command to half of a single CPU. If zero, CPU usage is not limited. Limits
+	// below 0.01, the smallest limit supported by the kernel, are raised to 0.01.
+	CPUs float64
+	// Memory is the maximum amount of memory in bytes the command can use, after which
+	// it is killed by the out-of-memory killer. If zero, memory usage is not limited.
+	Memory int64
+	// PIDs is the maximum number of processes the command can run at once. If zero, the
+	// number of processes is not limited.
+	PIDs int64
+
+	// Parent is the cgroup to create the transient cgroup in, relative to the root of
+	// the cgroup hierarchy, e.g. "/user.slice/user-1000.slice/user@1000.service/app.slice".
+	// It is required.
+	//
+	// The cgroup must be writable by the current user, and unless it is the root cgroup,
+	// it must not contain any processes itself, since controllers can only be enabled
+	// for cgroups without processes. This usually rules out the cgroup of the current
+	// process, so it is not used by default.
+	Parent string
+}
+
+// Cgroup runs the command in a transient cgroup with the given resource limits, which is
+// removed once the command exits, terminating any processes that remain in it. This is
+// useful for constraining sandboxed build steps.
+//
+// The command is started by 'sh', which moves itself to the cgroup before executing the
+// command, such that all processes spawned by the command are also constrained.
+//
+// Cgroup is only supported on Linux with cgroup v2 mounted at /sys/fs/cgroup.
+func (c *Command) Cgroup(limits CgroupLimits) *Command {
+	c.cgroup = &limits
+	return c
+}
+
+// transientCgroupID is used to generate unique names for transient cgroups.
+var transientCgroupID int64
+
+// cgroupScript moves itself to the cgroup given as the first argument, and then executes
+// the remaining arguments.
+const cgroupScript = `set -e
+echo $$ > "$1/cgroup.procs"
+shift
+exec "$@"`
+
+// transientCgroup is a cgroup that is created for a single execution of a command.
+type transientCgroup struct {
+	limits CgroupLimits
+	parent string
+	path   string
+
+	created bool
+	// oomKills is the number of processes in the cgroup killed by the out-of-memory
+	// killer, recorded when the cgroup is removed.
+	oomKills int64
+}
+
+// newTransientCgroup prepares a transient cgroup in the cgroup hierarchy mounted at root.
+func newTransientCgroup(limits CgroupLimits, root string) (*transientCgroup, error) {
+	parent := limits.Parent
+	if parent == "" {
+		return nil, errors.New("Cgroup requires CgroupLimits.Parent to be set")
+	}
+
+	name := fmt.Sprintf("run-%d-%d", os.Getpid(), atomic.AddInt64(&transientCgroupID, 1))
+	return &transientCgroup{
+		limits: limits,
+		parent: filepath.Join(root, parent),
+		path:   filepath.Join(root, parent, name),
+	}, nil
+}
+
+// wrap wraps args with a script that moves the command to the cgroup.
+func (t *transientCgroup) wrap(args []string) []string {
+	return append([]string{"sh", "-c", cgroupScript, "sh", t.path}, args...)
+}
+
+// create creates the cgroup and configures its limits. It implements the signature of
+// Command prepare functions.
+func (t *transientCgroup) create(*exec.Cmd) error {
+	files := map[string]string{}
+	var controllers []string
+	if t.limits.CPUs > 0 {
+		// The quota and period are in microseconds, and the kernel rejects quotas
+		// below 1ms.
+		const period, minQuota = 100000, 1000
+		quota := int64(t.limits.CPUs * period)
+		if quota < minQuota {
+			quota = minQuota
+		}
+		files["cpu.max"] = fmt.Sprintf("%d %d", quota, period)
+		controllers = append(controllers, "+cpu")
+	}
+	if t.limits.Memory > 0 {
+		files["memory.max"] = strconv.FormatInt(t.limits.Memory, 10)
+		controllers = append(controllers, "+memory")
+	}
+	if t.limits.PIDs > 0 {
+		files["pids.max"] = strconv.FormatInt(t.limits.PIDs, 10)
+		controllers = append(controllers, "+pids")
+	}
+
+	if len(controllers) > 0 {
+		if err := os.WriteFile(filepath.Join(t.parent, "cgroup.subtree_control"),
+			[]byte(strings.Join(controllers, " ")), 0o644); err != nil {
+			return fmt.Errorf("failed to enable cgroup controllers: %w", err)
+		}
+	}
+	if err := os.Mkdir(t.path, 0o755); err != nil {
+		return fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	t.created = true
+	for file, value := range files {
+		if err := os.WriteFile(filepath.Join(t.path, file), []byte(value), 0o644); err != nil {
+			_ = t.Close()
+			return fmt.Errorf("failed to configure cgroup: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close removes the cgroup, if it was created, terminating any processes that remain in
+// it.
+func (t *transientCgroup) Close() error {
+	if !t.created {
+		return nil
+	}
+	if count, ok := readOOMKillField(filepath.Join(t.path, "memory.events")); ok {
+		t.oomKills = count
+	}
+	err := os.Remove(t.path)
+	for attempt := 0; err != nil && attempt < 10; attempt++ {
+		// Processes remain in the cgroup, so terminate them and wait for them to exit.
+		_ = os.WriteFile(filepath.Join(t.path, "cgroup.kill"), []byte("1"), 0o644)
+		time.Sleep(10 * time.Millisecond)
+		err = os.Remove(t.path)
+	}
+	if err == nil {
+		t.created = false
+	}
+	return err
+}
+
+// oomKilled indicates if any process in the cgroup was killed by the out-of-memory
+// killer. The cgroup is only used by a single command, so no baseline is needed. It
+// should be called after the cgroup is closed.
+func (t *transientCgroup) oomKilled() bool { return t.oomKills > 0 }
@@ -0,0 +1,18 @@
+package run
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is expected to be mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// newCgroup prepares a transient cgroup for a command with the given limits.
+func newCgroup(limits CgroupLimits) (*transientCgroup, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, errors.New("Cgroup requires cgroup v2 to be mounted at " + cgroupRoot)
+	}
+	return newTransientCgroup(limits, cgroupRoot)
+}
@@ -0,0 +1,50 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestTransientCgroup(t *testing.T) {
+	c := qt.New(t)
+
+	read := func(c *qt.C, path string) string {
+		b, err := os.ReadFile(path)
+		c.Assert(err, qt.IsNil)
+		return string(b)
+	}
+
+	root := c.TempDir()
+	parent := filepath.Join(root, "user.slice", "app.slice")
+	c.Assert(os.MkdirAll(parent, 0o755), qt.IsNil)
+
+	cgroup, err := newTransientCgroup(CgroupLimits{CPUs: 1.5, Memory: 1 << 20, Parent: "/user.slice/app.slice"}, root)
+	c.Assert(err, qt.IsNil)
+	c.Assert(filepath.Dir(cgroup.path), qt.Equals, parent)
+	c.Assert(strings.HasPrefix(filepath.Base(cgroup.path), "run-"), qt.IsTrue)
+	c.Assert(cgroup.wrap([]string{"echo", "hello"}), qt.DeepEquals,
+		[]string{"sh", "-c", cgroupScript, "sh", cgroup.path, "echo", "hello"})
+
+	c.Assert(cgroup.create(nil), qt.IsNil)
+	c.Assert(read(c, filepath.Join(parent, "cgroup.subtree_control")), qt.Equals, "+cpu +memory")
+	c.Assert(read(c, filepath.Join(cgroup.path, "cpu.max")), qt.Equals, "150000 100000")
+	c.Assert(read(c, filepath.Join(cgroup.path, "memory.max")), qt.Equals, "1048576")
+	_, err = os.Stat(filepath.Join(cgroup.path, "pids.max"))
+	c.Assert(os.IsNotExist(err), qt.IsTrue)
+
+	c.Run("minimum CPU limit", func(c *qt.C) {
+		cgroup, err := newTransientCgroup(CgroupLimits{CPUs: 0.000001, Parent: "/user.slice/app.slice"}, root)
+		c.Assert(err, qt.IsNil)
+		c.Assert(cgroup.create(nil), qt.IsNil)
+		c.Assert(read(c, filepath.Join(cgroup.path, "cpu.max")), qt.Equals, "1000 100000")
+	})
+
+	c.Run("parent required", func(c *qt.C) {
+		_, err := newTransientCgroup(CgroupLimits{Memory: 1 << 20}, root)
+		c.Assert(err, qt.ErrorMatches, "Cgroup requires CgroupLimits.Parent.*")
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package run
+
+import "errors"
+
+func newCgroup(CgroupLimits) (*transientCgroup, error) {
+	return nil, errors.New("Cgroup is only supported on Linux")
+}
@@ -0,0 +1,104 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+)
+
+const contextKeyErrorRules contextKey = "errorRules"
+
+// ErrorRule classifies errors from commands with stderr that matches Pattern.
+type ErrorRule struct {
+	// Pattern is matched against the command's stderr.
+	Pattern *regexp.Regexp
+	// Category is the category of errors matching Pattern, e.g. "auth" or "network".
+	Category string
+	// Hint is an actionable message for users encountering the error, e.g. "run 'git
+	// fetch' and try again".
+	Hint string
+}
+
+// ClassifyErrors configures all commands executed by sourcegraph/run within this context
+// to classify errors with the first of the given rules with a pattern that matches the
+// command's stderr, for example to translate cryptic errors from git or docker into
+// actionable messages in a single place. Use ErrorClassification to retrieve the rule an
+// error was classified with.
+//
+// Rules configured by calls in parent contexts are applied after the given rules.
+func ClassifyErrors(ctx context.Context, rules []ErrorRule) context.Context {
+	rules = append(append([]ErrorRule(nil), rules...), getErrorRules(ctx)...)
+	return context.WithValue(ctx, contextKeyErrorRules, rules)
+}
+
+// getErrorRules returns the rules configured in ctx, or nil.
+func getErrorRules(ctx context.Context) []ErrorRule {
+	v, _ := ctx.Value(contextKeyErrorRules).([]ErrorRule)
+	return v
+}
+
+// ErrorClassification returns the rule that err was classified with, if the command
+// that produced err was run in a context configured with ClassifyErrors and its stderr
+// matched a rule.
+func ErrorClassification(err error) (ErrorRule, bool) {
+	var classified *classifiedError
+	if !errors.As(err, &classified) {
+		return ErrorRule{}, false
+	}
+	return classified.rule, true
+}
+
+// classifyError annotates err with the first rule that matches the command's stderr.
+func classifyError(err error, rules []ErrorRule) error {
+	if len(rules) == 0 {
+		return err
+	}
+	exitCoder, ok := err.(ExitCoder)
+	if !ok {
+		return err
+	}
+	var stderr []byte
+	var runErr *runError
+	var statusErr *exitStatusError
+	switch {
+	case errors.As(err, &runErr):
+		stderr = runErr.execErr.Stderr
+	case errors.As(err, &statusErr):
+		stderr = statusErr.stderr
+	default:
+		return err
+	}
+	for _, rule := range rules {
+		if rule.Pattern != nil && rule.Pattern.Match(stderr) {
+			return &classifiedError{rule: rule, err: exitCoder}
+		}
+	}
+	return err
+}
+
+// classifiedError is a command error that has been classified with an ErrorRule.
+type classifiedError struct {
+	rule ErrorRule
+	err  ExitCoder
+}
+
+var _ ExitCoder = &classifiedError{}
+var _ SignalCauser = &classifiedError{}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() error { return e.err }
+
+func (e *classifiedError) ExitCode() int { return e.err.ExitCode() }
+
+func (e *classifiedError) CauseSignal() os.Signal {
+	if signalCauser, ok := e.err.(SignalCauser); ok {
+		return signalCauser.CauseSignal()
+	}
+	return nil
+}
+
+func (e *classifiedError) CoreDumped() bool { return CoreDumped(e.err) }
+
+func (e *classifiedError) OOMKilled() bool { return OOMKilled(e.err) }
@@ -0,0 +1,125 @@
+package run
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+const contextKeyCleanupRegistry contextKey = "cleanupRegistry"
+
+// osExit is used to exit the process after cleanup, and can be replaced in tests.
+var osExit = os.Exit
+
+// CleanupRegistry tracks running commands and cleanup callbacks, such as removal of
+// temporary directories or lock files, to tear down when the program is interrupted.
+// Create one with CleanupOnInterrupt.
+type CleanupRegistry struct {
+	mux       sync.Mutex
+	commands  map[*cleanupCommand]struct{}
+	callbacks []func()
+
+	// interrupted is closed when teardown starts.
+	interrupted  chan struct{}
+	teardownOnce sync.Once
+}
+
+// cleanupCommand is a running command tracked by CleanupRegistry.
+type cleanupCommand struct {
+	// done is closed once the command has exited or been terminated.
+	done chan struct{}
+}
+
+// CleanupOnInterrupt tracks all commands run within this context with the returned
+// CleanupRegistry. When the program receives SIGINT or SIGTERM, running commands are
+// terminated and callbacks registered with Register are run, before the program exits
+// with the exit code a shell would report for the signal, e.g. 130 for SIGINT.
+//
+// Commands are terminated as configured with GracefulShutdown. Signals are only handled
+// until ctx is done.
+func CleanupOnInterrupt(ctx context.Context) (context.Context, *CleanupRegistry) {
+	registry := &CleanupRegistry{
+		commands:    make(map[*cleanupCommand]struct{}),
+		interrupted: make(chan struct{}),
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			registry.Cleanup()
+			osExit(signalExitCode(sig))
+		case <-ctx.Done():
+		}
+	}()
+
+	return context.WithValue(ctx, contextKeyCleanupRegistry, registry), registry
+}
+
+// getCleanupRegistry returns the cleanup registry configured in ctx, or nil.
+func getCleanupRegistry(ctx context.Context) *CleanupRegistry {
+	v, _ := ctx.Value(contextKeyCleanupRegistry).(*CleanupRegistry)
+	return v
+}
+
+// Register adds a callback to run on cleanup. Callbacks are run in reverse order of
+// registration, like deferred functions, after all running commands are terminated.
+func (r *CleanupRegistry) Register(callback func()) {
+	r.mux.Lock()
+	r.callbacks = append(r.callbacks, callback)
+	r.mux.Unlock()
+}
+
+// Cleanup terminates all running commands and runs registered callbacks. It is called
+// automatically when the program is interrupted, but can also be called directly, for
+// example before exiting normally. Only the first call has any effect, and commands
+// started afterwards are terminated immediately.
+func (r *CleanupRegistry) Cleanup() {
+	r.teardownOnce.Do(func() {
+		close(r.interrupted)
+
+		r.mux.Lock()
+		commands := make([]*cleanupCommand, 0, len(r.commands))
+		for c := range r.commands {
+			commands = append(commands, c)
+		}
+		callbacks := r.callbacks
+		r.mux.Unlock()
+
+		for _, c := range commands {
+			<-c.done
+		}
+		for i := len(callbacks) - 1; i >= 0; i-- {
+			callbacks[i]()
+		}
+	})
+}
+
+// track registers a running command. The returned channel is closed when the command
+// should be terminated, and done should be called once the command has exited or has
+// been terminated.
+func (r *CleanupRegistry) track() (interrupted <-chan struct{}, done func()) {
+	c := &cleanupCommand{done: make(chan struct{})}
+	r.mux.Lock()
+	r.commands[c] = struct{}{}
+	r.mux.Unlock()
+
+	return r.interrupted, func() {
+		r.mux.Lock()
+		delete(r.commands, c)
+		r.mux.Unlock()
+		close(c.done)
+	}
+}
+
+// signalExitCode returns the exit code a shell reports for a process terminated by sig.
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
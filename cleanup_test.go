@@ -0,0 +1,47 @@
+package run
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCleanupOnInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cannot send interrupts to the current process on Windows")
+	}
+	c := qt.New(t)
+
+	exitCode := make(chan int, 1)
+	osExit = func(code int) { exitCode <- code }
+	c.Cleanup(func() { osExit = os.Exit })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, cleanup := CleanupOnInterrupt(ctx)
+
+	var cleanedUp []string
+	cleanup.Register(func() { cleanedUp = append(cleanedUp, "first") })
+	cleanup.Register(func() { cleanedUp = append(cleanedUp, "second") })
+
+	out := Cmd(ctx, "sleep 10").Run()
+
+	self, err := os.FindProcess(os.Getpid())
+	c.Assert(err, qt.IsNil)
+	c.Assert(self.Signal(os.Interrupt), qt.IsNil)
+
+	select {
+	case code := <-exitCode:
+		c.Assert(code, qt.Equals, 130)
+	case <-time.After(5 * time.Second):
+		c.Fatal("cleanup did not complete")
+	}
+	c.Assert(cleanedUp, qt.CmpEquals(), []string{"second", "first"})
+
+	// The command should have been terminated
+	c.Assert(out.Wait(), qt.IsNotNil)
+}
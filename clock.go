@@ -0,0 +1,38 @@
+package run
+
+import (
+	"context"
+	"time"
+)
+
+const contextKeyClock contextKey = "clock"
+
+// Clock provides the current time and timers to sourcegraph/run. It can be replaced
+// within a context using WithClock, for example to make tests of time-dependent
+// behaviour deterministic.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel that receives the current time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// WithClock configures all usages of sourcegraph/run within this context to use the given
+// clock for measuring time. Set to nil to use the system clock (default).
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, contextKeyClock, clock)
+}
+
+// getClock returns the clock configured in ctx, or the system clock.
+func getClock(ctx context.Context) Clock {
+	if v, _ := ctx.Value(contextKeyClock).(Clock); v != nil {
+		return v
+	}
+	return systemClock{}
+}
+
+// systemClock is the default Clock, backed by package time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
@@ -9,6 +9,7 @@ import (
 	"os"
 
 	"github.com/sourcegraph/run"
+	"github.com/sourcegraph/run/docs"
 )
 
 func main() {
@@ -44,7 +45,7 @@ func main() {
 
 	// Generate data from a file, replacing tabs with spaces for Markdown purposes
 	var exampleData bytes.Buffer
-	exampleData.Write([]byte(exampleStart + "\n\n```go\n"))
+	exampleData.Write([]byte("\n\n```go\n"))
 	if err = run.Cmd(ctx, "cat", "cmd/example/main.go").Run().
 		Map(func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
 			return dst.Write(bytes.ReplaceAll(line, []byte("\t"), []byte("  ")))
@@ -52,14 +53,17 @@ func main() {
 		Stream(&exampleData); err != nil {
 		log.Fatal(err)
 	}
-	exampleData.Write([]byte("```\n\n" + exampleEnd))
+	exampleData.Write([]byte("```\n\n"))
 
 	// Render new README file
 	var readmeData bytes.Buffer
 	if err = run.Cmd(ctx, "cat", "README.md").Run().Stream(&readmeData); err != nil {
 		log.Fatal(err)
 	}
-	replaced := exampleBlockRegexp.ReplaceAll(readmeData.Bytes(), exampleData.Bytes())
+	replaced, err := docs.ReplaceBetween(readmeData.Bytes(), exampleStart, exampleEnd, exampleData.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Pipe data to command
 	err = run.Cmd(ctx, "cp /dev/stdin README.md").Input(bytes.NewReader(replaced)).Run().Wait()
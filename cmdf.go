@@ -0,0 +1,56 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/creachadair/shell"
+)
+
+// Cmdf formats a command according to a format specifier, like fmt.Sprintf, and builds
+// a command from it. Each interpolated value is formatted with its verb and then quoted
+// with Arg, so that it is always treated as a single argument, even if it contains spaces
+// or quotes. The format itself is not quoted, so it can contain multiple arguments:
+//
+//	run.Cmdf(ctx, "git commit -m %s --author %s", message, author)
+//
+// Values should not be quoted already, since they would be quoted twice. Likewise, verbs
+// that quote values, such as %q, add quotes that are passed to the command as-is.
+func Cmdf(ctx context.Context, format string, args ...interface{}) *Command {
+	quoted := make([]interface{}, len(args))
+	for i, arg := range args {
+		quoted[i] = quotedArg{value: arg}
+	}
+	return Cmd(ctx, fmt.Sprintf(format, quoted...))
+}
+
+// quotedArg is a fmt.Formatter that formats value and quotes the result with Arg.
+type quotedArg struct{ value interface{} }
+
+func (q quotedArg) Format(f fmt.State, verb rune) {
+	_, _ = io.WriteString(f, shell.Quote(fmt.Sprintf(formatDirective(f, verb), q.value)))
+}
+
+// formatDirective reconstructs the formatting directive, e.g. '%-5.2f', represented by f
+// and verb.
+func formatDirective(f fmt.State, verb rune) string {
+	var b strings.Builder
+	b.WriteByte('%')
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			b.WriteRune(flag)
+		}
+	}
+	if width, ok := f.Width(); ok {
+		b.WriteString(strconv.Itoa(width))
+	}
+	if precision, ok := f.Precision(); ok {
+		b.WriteByte('.')
+		b.WriteString(strconv.Itoa(precision))
+	}
+	b.WriteRune(verb)
+	return b.String()
+}
@@ -5,7 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"bitbucket.org/creachadair/shell"
 )
@@ -31,8 +36,46 @@ type Command struct {
 	environ []string
 	dir     string
 
-	stdin  io.Reader
-	attach attachedOutput
+	stdin io.Reader
+	// inputs are the readers that make up stdin, in order.
+	inputs []io.Reader
+	attach AttachSpec
+	// stderrTail, if set, is the number of bytes at the end of stderr retained for
+	// errors.
+	stderrTail int
+	// inputClosers are closed once the command exits or fails to start.
+	inputClosers []io.Closer
+	// stdinPipe, if set, is the read end of a pipe created by StdinPipe.
+	stdinPipe *os.File
+
+	shutdown       gracefulShutdown
+	timeout        time.Duration
+	deadline       time.Time
+	forwardSignals []os.Signal
+	// prepare are applied to the underlying exec.Cmd before it is started.
+	prepare      []func(cmd *exec.Cmd) error
+	sysProcAttr  *syscall.SysProcAttr
+	seccomp      SeccompHook
+	readOnlyRoot *readOnlyRoot
+	priority     processPriority
+	sudo         *SudoOpts
+	cgroup       *CgroupLimits
+	umask        *os.FileMode
+	lineLatency  bool
+	destructive  bool
+	beforeStart  []func(cmd *ExecutedCommand) error
+	afterExit    []func(result ExecutedCommandResult)
+	exitCodes    map[int]error
+	// inheritEnv, if set, overrides whether the environment is inherited.
+	inheritEnv *bool
+	// unsetenv are variables removed from the inherited environment.
+	unsetenv    []string
+	expandEnv   bool
+	expandGlobs bool
+
+	// fingerprintEnv, if set, are the environment variables that participate in
+	// Fingerprint.
+	fingerprintEnv []string
 
 	// buildError represents an error that occured when building this command.
 	buildError error
@@ -53,6 +96,24 @@ func Cmd(ctx context.Context, parts ...string) *Command {
 	}
 }
 
+// Args builds a command from the given arguments verbatim, where the first argument is
+// the command to execute. Unlike Cmd, arguments are not split, so arguments containing
+// quotes or spaces are passed to the command as-is. This is useful for running argument
+// lists that have already been computed, for example by another system.
+func Args(ctx context.Context, argv []string) *Command {
+	return &Command{
+		ctx:  ctx,
+		args: append([]string(nil), argv...),
+	}
+}
+
+// CmdStrict builds a command that executes name with the given arguments verbatim. Unlike
+// Cmd, no splitting or joining is performed, so arguments containing spaces or quotes
+// are passed to the command as-is, and Arg is not needed to quote them.
+func CmdStrict(ctx context.Context, name string, args ...string) *Command {
+	return Args(ctx, append([]string{name}, args...))
+}
+
 // BashWith appends all the given bash options to the bash command with '-o'. The given parts
 // is then joined together to be executed with 'bash -c'
 //
@@ -77,20 +138,328 @@ func Bash(ctx context.Context, parts ...string) *Command {
 	return Cmd(ctx, "bash -c", Arg(strings.Join(parts, " ")))
 }
 
+// Shell joins all the parts and builds a command from it to be run by the given shell
+// with '-c', where shell is the name of or path to the shell binary.
+//
+// Arguments are not implicitly quoted - to quote arguments, you can use Arg.
+func Shell(ctx context.Context, shell string, parts ...string) *Command {
+	return Args(ctx, []string{shell, "-c", strings.Join(parts, " ")})
+}
+
+// Sh joins all the parts and builds a command from it to be run by 'sh -c', which is
+// available in environments where bash might not be, such as Alpine containers.
+//
+// Arguments are not implicitly quoted - to quote arguments, you can use Arg.
+func Sh(ctx context.Context, parts ...string) *Command {
+	return Shell(ctx, "sh", parts...)
+}
+
+// Zsh joins all the parts and builds a command from it to be run by 'zsh -c'.
+//
+// Arguments are not implicitly quoted - to quote arguments, you can use Arg.
+func Zsh(ctx context.Context, parts ...string) *Command {
+	return Shell(ctx, "zsh", parts...)
+}
+
+// Fish joins all the parts and builds a command from it to be run by 'fish -c'.
+//
+// Arguments are not implicitly quoted - to quote arguments, you can use Arg.
+func Fish(ctx context.Context, parts ...string) *Command {
+	return Shell(ctx, "fish", parts...)
+}
+
+// Exec joins all the parts, builds a command from it, and runs it to completion. It is
+// equivalent to Cmd(ctx, parts...).Run().Wait().
+//
+// Arguments are not implicitly quoted - to quote arguments, you can use Arg.
+func Exec(ctx context.Context, parts ...string) error {
+	return Cmd(ctx, parts...).Run().Wait()
+}
+
+// Capture joins all the parts, builds a command from it, runs it to completion, and
+// returns its combined output as a string. It is equivalent to
+// Cmd(ctx, parts...).Run().String().
+//
+// Arguments are not implicitly quoted - to quote arguments, you can use Arg.
+func Capture(ctx context.Context, parts ...string) (string, error) {
+	return Cmd(ctx, parts...).Run().String()
+}
+
 // Run starts command execution and returns Output, which defaults to combined output.
+// Errors starting the command are returned when Output is consumed. To control the
+// started process directly, use Start instead.
 func (c *Command) Run() Output {
+	p, err := c.Start()
+	if err != nil {
+		return NewErrorOutput(err)
+	}
+	return p.Output()
+}
+
+// Start starts command execution and returns a Process, which allows the started
+// command to be signalled or killed, and provides its Output. The command's Output must
+// be consumed or closed to release resources, as with Run.
+func (c *Command) Start() (*Process, error) {
 	if c.buildError != nil {
-		return NewErrorOutput(c.buildError)
+		return nil, c.buildError
 	}
 	if len(c.args) == 0 {
-		return NewErrorOutput(errors.New("Command not instantiated"))
+		return nil, errors.New("Command not instantiated")
 	}
 
-	return attachAndRun(c.ctx, c.attach, c.stdin, ExecutedCommand{
-		Args:    c.args,
-		Environ: c.environ,
-		Dir:     c.dir,
-	})
+	args, err := c.wrappedArgs()
+	if err != nil {
+		return nil, err
+	}
+	var password string
+	if c.sudo != nil {
+		password = c.sudo.Password
+	}
+	prepare, inputClosers := c.prepare, c.inputClosers
+	var oomKilled func() bool
+	if c.cgroup != nil {
+		cgroup, err := newCgroup(*c.cgroup)
+		if err != nil {
+			return nil, err
+		}
+		args = cgroup.wrap(args)
+		prepare = append(append([]func(*exec.Cmd) error(nil), prepare...), cgroup.create)
+		inputClosers = append(append([]io.Closer(nil), inputClosers...), cgroup)
+		oomKilled = cgroup.oomKilled
+	}
+
+	return attachAndRun(c.ctx, execOptions{
+		attachOutput:   c.attach,
+		stderrTail:     c.stderrTail,
+		attachInput:    c.stdin,
+		inputs:         c.inputs,
+		password:       password,
+		inputClosers:   inputClosers,
+		stdinPipe:      c.stdinPipe,
+		beforeStart:    c.beforeStart,
+		afterExit:      c.afterExit,
+		shutdown:       c.shutdown,
+		timeout:        c.timeout,
+		deadline:       c.deadline,
+		forwardSignals: c.forwardSignals,
+		prepare:        prepare,
+		sysProcAttr:    c.sysProcAttr,
+		exitCodes:      c.exitCodes,
+		oomKilled:      oomKilled,
+		destructive:    c.destructive,
+		inheritEnv:     c.inheritsEnv(),
+		unsetEnv:       c.unsetenv,
+	}, c.executedCommand(args))
+}
+
+// executedCommand returns the ExecutedCommand for running this command with args.
+func (c *Command) executedCommand(args []string) ExecutedCommand {
+	return ExecutedCommand{
+		Args:     args,
+		Environ:  c.environ,
+		Dir:      c.dir,
+		ClearEnv: !c.inheritsEnv(),
+		Unsetenv: c.unsetenv,
+	}
+}
+
+// wrappedArgs returns the arguments the command is executed with, once they are expanded
+// and wrapped by options such as Sudo and Nice. Cgroup is applied separately, since it
+// creates a cgroup.
+func (c *Command) wrappedArgs() ([]string, error) {
+	args := c.args
+	if c.expandEnv {
+		var err error
+		if args, err = expandArgs(args, commandEnv(c.environ, c.unsetenv, c.inheritsEnv())); err != nil {
+			return nil, err
+		}
+	}
+	if c.expandGlobs {
+		args = expandGlobArgs(args, c.dir)
+	}
+	if c.umask != nil {
+		var err error
+		if args, err = umaskWrap(*c.umask, args); err != nil {
+			return nil, err
+		}
+	}
+	if c.lineLatency {
+		args = lineLatencyWrap(args)
+	}
+	if c.seccomp != nil {
+		args = c.seccomp(append([]string(nil), args...))
+		if len(args) == 0 {
+			return nil, errors.New("Seccomp hook returned no arguments")
+		}
+	}
+	if c.readOnlyRoot != nil {
+		args = c.readOnlyRoot.wrap(args)
+	}
+	args = c.priority.wrap(args)
+	if c.sudo != nil {
+		args = c.sudo.wrap(args)
+	}
+	return args, nil
+}
+
+// String returns the command as a shell-quoted command line for display, for example in
+// logs or to preview a command before running it. Environment variables that are not
+// inherited from the current process are included as a prefix, and if a directory is
+// set, the command is prefixed with 'cd dir &&'. Input is not included, and secrets
+// registered with RedactSecrets are redacted.
+func (c *Command) String() string {
+	if c.buildError != nil {
+		return fmt.Sprintf("<invalid command: %s>", c.buildError)
+	}
+	e := c.executedCommand(c.args)
+	if c.sudo != nil {
+		e.Args = c.sudo.wrap(e.Args)
+	}
+	if c.ctx != nil {
+		if secrets := getSecretRegistry(c.ctx); secrets != nil {
+			e = secrets.redactCommand(e)
+		}
+	}
+	return e.commandLine()
+}
+
+// CommandConfig is a snapshot of the configuration of a Command, for example for
+// wrappers, policies, and tests to inspect a command without executing it.
+type CommandConfig struct {
+	// Args are the arguments of the command, before they are wrapped by options that are
+	// applied when the command is run, such as Sudo or Nice.
+	Args []string
+	// Dir is the directory the command is executed in, or empty for the working
+	// directory of the current process.
+	Dir string
+	// Environ are the environment variables set on the command, in the form "key=value".
+	Environ []string
+	// InheritEnv indicates if the command inherits the environment of the current
+	// process, in addition to Environ.
+	InheritEnv bool
+	// Unsetenv are the variables removed from the inherited environment.
+	Unsetenv []string
+	// Attach configures where the stdout and stderr of the command are sent.
+	Attach AttachSpec
+}
+
+// Config returns a snapshot of the configuration of the command. Modifying the returned
+// configuration does not modify the command. Errors building the command are reported
+// by Validate.
+func (c *Command) Config() CommandConfig {
+	return CommandConfig{
+		Args:       cloneStrings(c.args),
+		Dir:        c.dir,
+		Environ:    cloneStrings(c.environ),
+		InheritEnv: c.inheritsEnv(),
+		Unsetenv:   cloneStrings(c.unsetenv),
+		Attach:     c.attach,
+	}
+}
+
+// EnvMap returns the environment variables set on the command as a map.
+func (c CommandConfig) EnvMap() map[string]string {
+	env := make(map[string]string, len(c.Environ))
+	for _, kv := range c.Environ {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+	return env
+}
+
+// Validate checks that the command can be run without executing it, for example for
+// preflight checks: it reports errors building the command, whether the command can be
+// found, and whether the directory it should be executed in exists.
+func (c *Command) Validate() error {
+	if c.buildError != nil {
+		return c.buildError
+	}
+	if len(c.args) == 0 {
+		return errors.New("Command not instantiated")
+	}
+	if c.dir != "" {
+		info, err := os.Stat(c.dir)
+		if err != nil {
+			return fmt.Errorf("invalid directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid directory: %s is not a directory", c.dir)
+		}
+	}
+	name := c.args[0]
+	if c.dir != "" && strings.ContainsRune(name, filepath.Separator) && !filepath.IsAbs(name) {
+		// Relative paths are resolved relative to the command's directory.
+		name = filepath.Join(c.dir, name)
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return fmt.Errorf("invalid command: %w", err)
+	}
+	return nil
+}
+
+// Clone returns a copy of the command, so that a base command can be built once and then
+// modified and run without affecting the base command or other copies. Copies can be
+// safely modified and run concurrently.
+//
+// Input set with Input is shared between copies, and is consumed when a copy is run, so
+// it should typically be set on each copy instead.
+func (c *Command) Clone() *Command {
+	clone := *c
+	clone.args = cloneStrings(c.args)
+	clone.environ = cloneStrings(c.environ)
+	clone.unsetenv = cloneStrings(c.unsetenv)
+	clone.fingerprintEnv = cloneStrings(c.fingerprintEnv)
+	clone.forwardSignals = append([]os.Signal(nil), c.forwardSignals...)
+	clone.prepare = append([]func(*exec.Cmd) error(nil), c.prepare...)
+	clone.inputs = append([]io.Reader(nil), c.inputs...)
+	clone.inputClosers = append([]io.Closer(nil), c.inputClosers...)
+	clone.beforeStart = append([]func(*ExecutedCommand) error(nil), c.beforeStart...)
+	clone.afterExit = append(([]func(ExecutedCommandResult))(nil), c.afterExit...)
+	if c.readOnlyRoot != nil {
+		clone.readOnlyRoot = &readOnlyRoot{allowWrites: cloneStrings(c.readOnlyRoot.allowWrites)}
+	}
+	if c.umask != nil {
+		umask := *c.umask
+		clone.umask = &umask
+	}
+	if c.sudo != nil {
+		sudo := *c.sudo
+		clone.sudo = &sudo
+	}
+	if c.cgroup != nil {
+		cgroup := *c.cgroup
+		clone.cgroup = &cgroup
+	}
+	if c.exitCodes != nil {
+		clone.exitCodes = make(map[int]error, len(c.exitCodes))
+		for code, err := range c.exitCodes {
+			clone.exitCodes[code] = err
+		}
+	}
+	return &clone
+}
+
+// cloneStrings copies s, preserving whether s is nil.
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// Arg appends the given argument to the command as-is, for example to conditionally add
+// flags. Unlike arguments provided to Cmd, the argument is not split, so it does not need
+// to be quoted.
+func (c *Command) Arg(v string) *Command {
+	c.args = append(c.args, v)
+	return c
+}
+
+// Args appends the given arguments to the command as-is. Unlike arguments provided to
+// Cmd, arguments are not split, so they do not need to be quoted.
+func (c *Command) Args(vs ...string) *Command {
+	c.args = append(c.args, vs...)
+	return c
 }
 
 // Dir sets the directory this command should be executed in.
@@ -102,45 +471,129 @@ func (c *Command) Dir(dir string) *Command {
 // Input pipes the given io.Reader to the command. If an input is already set, the given
 // input is appended.
 func (c *Command) Input(input io.Reader) *Command {
+	if r, ok := input.(*gzipCompressReader); ok {
+		// Stop compressing input the command does not read once it exits.
+		c.inputClosers = append(c.inputClosers, r)
+	}
 	if c.stdin != nil {
 		c.stdin = io.MultiReader(c.stdin, input)
 	} else {
 		c.stdin = input
 	}
+	c.inputs = append(c.inputs, input)
 	return c
 }
 
 // ResetInput sets the command's input to nil.
 func (c *Command) ResetInput() *Command {
 	c.stdin = nil
+	c.inputs = nil
+	c.inputClosers = nil
+	c.stdinPipe = nil
 	return c
 }
 
-// Env adds the given environment variables to the command.
+// Env adds the given environment variables to the command. By default, the command
+// also inherits the environment of the current process - see InheritEnv.
+//
+// Variables set with Env, Environ, and Unsetenv take precedence over inherited variables,
+// and later calls take precedence over earlier calls for the same variable.
 func (c *Command) Env(env map[string]string) *Command {
 	for k, v := range env {
-		c.environ = append(c.environ, fmt.Sprintf("%s=%s", k, v))
+		c.setenv(k, fmt.Sprintf("%s=%s", k, v))
 	}
 	return c
 }
 
 // Environ adds the given strings representing the environment (key=value) to the
-// command, for example os.Environ().
+// command. By default, the command also inherits the environment of the current
+// process - see InheritEnv.
+//
+// Variables set with Env, Environ, and Unsetenv take precedence over inherited variables,
+// and later calls take precedence over earlier calls for the same variable.
 func (c *Command) Environ(environ []string) *Command {
-	c.environ = append(c.environ, environ...)
+	for _, kv := range environ {
+		k, _, _ := strings.Cut(kv, "=")
+		c.setenv(k, kv)
+	}
 	return c
 }
 
+// Unsetenv removes the given environment variables from the command, including
+// variables inherited from the current process and variables set by earlier calls to
+// Env or Environ.
+func (c *Command) Unsetenv(keys ...string) *Command {
+	c.environ = withoutEnvKeys(c.environ, keys)
+	for _, k := range keys {
+		c.unsetenv = append(withoutStrings(c.unsetenv, k), k)
+	}
+	return c
+}
+
+// setenv sets the environment entry kv for variable k, replacing existing entries.
+func (c *Command) setenv(k, kv string) {
+	c.environ = append(withoutEnvKeys(c.environ, []string{k}), kv)
+	c.unsetenv = withoutStrings(c.unsetenv, k)
+}
+
 // StdOut configures the command Output to only provide StdOut. By default, Output
 // includes combined output.
 func (c *Command) StdOut() *Command {
-	c.attach = attachOnlyStdOut
+	c.attach = AttachSpec{Stdout: AttachCapture, Stderr: AttachDiscard}
+	c.stderrTail = 0
 	return c
 }
 
 // StdErr configures the command Output to only provide StdErr. By default, Output
 // includes combined output.
 func (c *Command) StdErr() *Command {
-	c.attach = attachOnlyStdErr
+	c.attach = AttachSpec{Stdout: AttachDiscard, Stderr: AttachCapture}
+	c.stderrTail = 0
+	return c
+}
+
+// GracefulShutdown configures the command to first receive the given signal when its
+// context is done, and to only be forcibly terminated if it has not exited after the
+// grace period. By default, commands are forcibly terminated immediately.
+//
+// Commands started in a new process group, for example with NewProcessGroup or
+// KillProcessGroups, receive the signal along with all processes in their process group.
+// On Windows, commands are run in a job object so that all processes spawned by the
+// command are terminated together, and only os.Interrupt is supported, which is
+// delivered as a CTRL_BREAK event. This requires the command to be started in a new
+// process group, so commands configured with GracefulShutdown no longer receive Ctrl-C
+// from the console on Windows.
+func (c *Command) GracefulShutdown(signal os.Signal, grace time.Duration) *Command {
+	c.shutdown = gracefulShutdown{signal: signal, grace: grace}
+	return c
+}
+
+// Timeout configures the command to be terminated if it is still running after the given
+// duration, as configured with GracefulShutdown. The command then fails with an error
+// that matches ErrTimeout, which can be told apart from the command exiting with an error
+// of its own.
+//
+// Unlike a context configured with context.WithTimeout, the timeout only applies to the
+// command itself, and not to consuming its output afterwards.
+func (c *Command) Timeout(d time.Duration) *Command {
+	c.timeout = d
+	return c
+}
+
+// Deadline configures the command to be terminated if it is still running at the given
+// time, as with Timeout. If both are configured, the earliest applies.
+func (c *Command) Deadline(t time.Time) *Command {
+	c.deadline = t
+	return c
+}
+
+// Umask sets the file mode creation mask the command is started with, so that files
+// created by the command have predictable permissions regardless of the umask of the
+// current process. For example, a mask of 0o022 denies write permissions to groups and
+// others.
+//
+// Umask requires the 'sh' binary, and is not supported on Windows.
+func (c *Command) Umask(mask os.FileMode) *Command {
+	c.umask = &mask
 	return c
 }
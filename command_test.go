@@ -3,10 +3,20 @@ package run_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 
@@ -186,6 +196,71 @@ func TestJQ(t *testing.T) {
 		c.Assert(string(res), qt.Equals, `"world"`)
 	})
 
+	c.Run("newline-delimited JSON", func(c *qt.C) {
+		const testJSON = `{"hello": "world"}
+		{"hello": "jh"}`
+
+		res, err := run.Cmd(ctx, "cat").
+			Input(strings.NewReader(testJSON)).
+			Run().
+			JQ(".hello")
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(res), qt.Equals, `"world""jh"`)
+	})
+
+	c.Run("iterate over elements", func(c *qt.C) {
+		for _, tc := range []struct {
+			input  string
+			query  string
+			expect string
+		}{
+			{input: `[{"a": 1}, {"a": 2}]`, query: ".[].a", expect: `12`},
+			{input: `[{"a": 1}, {"a": 2}]`, query: ".[] | .a", expect: `12`},
+			{input: `[{"a": 1}, {"a": 2}]`, query: ".[]", expect: `{"a":1}{"a":2}`},
+			{input: `[{"a": 1}, {"a": 2}]`, query: "length", expect: `2`},
+			{input: `{"a": 1, "b": 2}`, query: "[.[]] | add", expect: `3`},
+			{input: `{"a": [1, 2]}`, query: ".[] | length", expect: `2`},
+		} {
+			res, err := run.Cmd(ctx, "cat").
+				Input(strings.NewReader(tc.input)).
+				Run().
+				JQ(tc.query)
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(res), qt.Equals, tc.expect, qt.Commentf("%s | jq %s", tc.input, tc.query))
+		}
+	})
+}
+
+func TestPrettyJSON(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	const testJSON = `{"hello":"world","list":[1,true,null]}`
+
+	c.Run("indent", func(c *qt.C) {
+		var b bytes.Buffer
+		err := run.Cmd(ctx, "cat").Input(strings.NewReader(testJSON)).Run().
+			PrettyJSON(&b, false)
+		c.Assert(err, qt.IsNil)
+		c.Assert(b.String(), qt.Equals, `{
+  "hello": "world",
+  "list": [
+    1,
+    true,
+    null
+  ]
+}
+`)
+	})
+
+	c.Run("colorize", func(c *qt.C) {
+		var b bytes.Buffer
+		err := run.Cmd(ctx, "cat").Input(strings.NewReader(testJSON)).Run().
+			PrettyJSON(&b, true)
+		c.Assert(err, qt.IsNil)
+		c.Assert(b.String(), qt.Contains, "\x1b[34;1m\"hello\"\x1b[0m: \x1b[0;32m\"world\"\x1b[0m")
+		c.Assert(b.String(), qt.Contains, "\x1b[1;30mnull\x1b[0m")
+	})
 }
 
 func TestEdgeCases(t *testing.T) {
@@ -247,7 +322,239 @@ func TestEdgeCases(t *testing.T) {
 			c.Assert(err, qt.IsNil)
 			c.Assert(res, qt.CmpEquals(), []string{"stdout", "stderr"})
 		})
+
+		c.Run("combined ordered", func(c *qt.C) {
+			ctx := run.OrderedOutput(ctx)
+			res, err := run.Bash(ctx, `for i in 1 2 3 4 5; do echo "out $i"; echo "err $i" 1>&2; done`).
+				Run().
+				Lines()
+			c.Assert(err, qt.IsNil)
+			c.Assert(res, qt.CmpEquals(), []string{
+				"out 1", "err 1", "out 2", "err 2", "out 3", "err 3",
+				"out 4", "err 4", "out 5", "err 5",
+			})
+		})
+
+		c.Run("combined ordered error", func(c *qt.C) {
+			ctx := run.OrderedOutput(ctx)
+			err := run.Bash(ctx, `echo "out"; echo "err" 1>&2; exit 1`).
+				Run().
+				Wait()
+			c.Assert(err, qt.ErrorMatches, "exit status 1: out\nerr\n?")
+		})
+
+		c.Run("attach stderr to writer", func(c *qt.C) {
+			var stderr bytes.Buffer
+			res, err := run.Bash(ctx, mixedOutputCmd).
+				Attach(run.AttachSpec{Stderr: run.AttachWriter(&stderr)}).
+				Run().
+				Lines()
+			c.Assert(err, qt.IsNil)
+			c.Assert(res, qt.CmpEquals(), []string{"stdout"})
+			c.Assert(stderr.String(), qt.Equals, "stderr\n")
+		})
+
+		c.Run("attach discard stdout", func(c *qt.C) {
+			err := run.Bash(ctx, `echo "stdout"; echo "stderr" 1>&2; exit 1`).
+				Attach(run.AttachSpec{Stdout: run.AttachDiscard, Stderr: run.AttachDiscard}).
+				Run().
+				Stream(&bytes.Buffer{})
+			// stderr is still included in errors
+			c.Assert(err, qt.ErrorMatches, "exit status 1: stderr\n?")
+		})
+	})
+}
+
+func TestClose(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("running command", func(c *qt.C) {
+		out := run.Cmd(ctx, "sleep 10").Run()
+
+		start := time.Now()
+		c.Assert(out.Close(), qt.IsNil)
+		c.Assert(time.Since(start) < 5*time.Second, qt.IsTrue)
+	})
+
+	c.Run("partially consumed output", func(c *qt.C) {
+		out := run.Cmd(ctx, "yes").Run()
+
+		b := make([]byte, 10)
+		_, err := out.Read(b)
+		c.Assert(err, qt.IsNil)
+		c.Assert(out.Close(), qt.IsNil)
+	})
+
+	c.Run("consumed output", func(c *qt.C) {
+		out := run.Cmd(ctx, "echo hello").Run()
+		res, err := out.String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(res, qt.Equals, "hello")
+
+		c.Assert(out.Close(), qt.IsNil)
+		c.Assert(out.Close(), qt.IsNil)
+	})
+}
+
+func TestConcurrentConsumption(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// Block the first consumer while it is processing output, so that the second
+	// consumer is guaranteed to run concurrently with it.
+	consuming := make(chan struct{})
+	release := make(chan struct{})
+	out := run.Cmd(ctx, "echo hello").Run().
+		Map(func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+			close(consuming)
+			<-release
+			return dst.Write(line)
+		})
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := out.String()
+		result <- err
+	}()
+	<-consuming
+
+	_, err := io.ReadAll(out)
+	c.Assert(errors.Is(err, run.ErrConcurrentConsumption), qt.IsTrue)
+
+	close(release)
+	c.Assert(<-result, qt.IsNil)
+}
+
+func TestTerminationCause(t *testing.T) {
+	c := qt.New(t)
+
+	signalOf := func(err error) os.Signal {
+		var causer run.SignalCauser
+		if errors.As(err, &causer) {
+			return causer.CauseSignal()
+		}
+		return nil
+	}
+
+	c.Run("timeout", func(c *qt.C) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := run.Cmd(ctx, "sleep 10").Run().Wait()
+		c.Assert(errors.Is(err, run.ErrTimeout), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(errors.Is(err, context.DeadlineExceeded), qt.IsTrue)
+		c.Assert(errors.Is(err, run.ErrCanceled), qt.IsFalse)
+		c.Assert(signalOf(err), qt.Equals, syscall.SIGKILL)
+	})
+
+	c.Run("canceled", func(c *qt.C) {
+		ctx, cancel := context.WithCancel(context.Background())
+		out := run.Cmd(ctx, "sleep 10").Run()
+		cancel()
+
+		err := out.Wait()
+		c.Assert(errors.Is(err, run.ErrCanceled), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(errors.Is(err, context.Canceled), qt.IsTrue)
+	})
+
+	c.Run("signal", func(c *qt.C) {
+		err := run.Bash(context.Background(), "kill -TERM $$").Run().Wait()
+		c.Assert(err, qt.IsNotNil)
+		c.Assert(errors.Is(err, run.ErrCanceled), qt.IsFalse)
+		c.Assert(signalOf(err), qt.Equals, syscall.SIGTERM)
+	})
+
+	c.Run("exit", func(c *qt.C) {
+		err := run.Bash(context.Background(), "exit 1").Run().Wait()
+		c.Assert(err, qt.IsNotNil)
+		c.Assert(signalOf(err), qt.IsNil)
+	})
+
+	c.Run("canceled after exit", func(c *qt.C) {
+		ctx, cancel := context.WithCancel(context.Background())
+		p, err := run.Bash(ctx, "exit 1").Start()
+		c.Assert(err, qt.IsNil)
+		for p.Signal(syscall.Signal(0)) != os.ErrProcessDone {
+			time.Sleep(time.Millisecond)
+		}
+		// Give the command a moment to be reported as exited after it is reaped.
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+
+		err = p.Output().Wait()
+		c.Assert(err, qt.IsNotNil)
+		c.Assert(errors.Is(err, run.ErrCanceled), qt.IsFalse, qt.Commentf("got %v", err))
+		c.Assert(errors.Is(err, context.Canceled), qt.IsFalse)
+	})
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	c := qt.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := run.Bash(ctx, `trap "echo graceful; exit 0" TERM; echo started; while true; do sleep 0.01; done`).
+		GracefulShutdown(syscall.SIGTERM, 5*time.Second).
+		Run()
+
+	var lines []string
+	err := out.StreamLines(func(line string) {
+		lines = append(lines, line)
+		if line == "started" {
+			cancel()
+		}
 	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(lines, qt.CmpEquals(), []string{"started", "graceful"})
+
+	c.Run("grace period elapses", func(c *qt.C) {
+		// Use a clock where the grace period elapses immediately
+		clock := &elapsedClock{}
+		ctx, cancel := context.WithCancel(run.WithClock(context.Background(), clock))
+		out := run.Bash(ctx, `trap "" TERM; echo started; while true; do sleep 0.01; done`).
+			GracefulShutdown(syscall.SIGTERM, time.Hour).
+			Run()
+
+		err := out.StreamLines(func(line string) { cancel() })
+		c.Assert(errors.Is(err, run.ErrCanceled), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(time.Duration(atomic.LoadInt64(&clock.waited)), qt.Equals, time.Hour)
+	})
+}
+
+// elapsedClock is a run.Clock where all durations elapse immediately.
+type elapsedClock struct{ waited int64 }
+
+func (c *elapsedClock) Now() time.Time {
+	return time.Now().Add(time.Duration(atomic.LoadInt64(&c.waited)))
+}
+
+func (c *elapsedClock) After(d time.Duration) <-chan time.Time {
+	atomic.AddInt64(&c.waited, int64(d))
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func TestUmask(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	for _, tc := range []struct {
+		umask os.FileMode
+		want  string
+	}{
+		{umask: 0o077, want: "-rw-------"},
+		{umask: 0o022, want: "-rw-r--r--"},
+	} {
+		file := filepath.Join(dir, tc.umask.String())
+		err := run.Cmd(ctx, "touch", file).Umask(tc.umask).Run().Wait()
+		c.Assert(err, qt.IsNil)
+
+		info, err := os.Stat(file)
+		c.Assert(err, qt.IsNil)
+		c.Assert(info.Mode().String(), qt.Equals, tc.want)
+	}
 }
 
 func TestBashOpts(t *testing.T) {
@@ -270,6 +577,100 @@ func TestBashOpts(t *testing.T) {
 	})
 }
 
+func TestShell(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("sh", func(c *qt.C) {
+		out, err := run.Sh(ctx, "echo hello", run.Arg("it's"), "| tr a-z A-Z").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "HELLO IT'S")
+	})
+
+	c.Run("shell path", func(c *qt.C) {
+		sh, err := exec.LookPath("sh")
+		c.Assert(err, qt.IsNil)
+		cmd := run.Shell(ctx, sh, "echo $0")
+		c.Assert(cmd.String(), qt.Equals, sh+` -c 'echo $0'`)
+		out, err := cmd.Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, sh)
+	})
+}
+
+func TestWindowsShells(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("PowerShellArg", func(c *qt.C) {
+		c.Assert(run.PowerShellArg("it's $HOME"), qt.Equals, `'it''s $HOME'`)
+	})
+
+	c.Run("CmdExeArg", func(c *qt.C) {
+		c.Assert(run.CmdExeArg(`a "b" & %PATH% c\`), qt.Equals, `^"a \^"b\^" ^& ^%PATH^% c\\^"`)
+		c.Assert(run.CmdExeArg(`C:\Program Files\`), qt.Equals, `^"C:\Program Files\\^"`)
+	})
+
+	c.Run("PowerShell", func(c *qt.C) {
+		cmd := run.PowerShell(ctx, "Write-Output", run.PowerShellArg("it's"))
+		if runtime.GOOS != "windows" {
+			if _, err := exec.LookPath("pwsh"); err != nil {
+				c.Skip("pwsh not available")
+			}
+		}
+		out, err := cmd.Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "it's")
+	})
+
+	c.Run("CmdExe", func(c *qt.C) {
+		if runtime.GOOS != "windows" {
+			c.Skip("cmd.exe is only available on Windows")
+		}
+		out, err := run.CmdExe(ctx, "echo", "a^&b").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "a&b")
+	})
+}
+
+func TestScriptFile(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	dir := c.TempDir()
+
+	writeScript := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		c.Assert(os.WriteFile(path, []byte(content), 0o644), qt.IsNil)
+		return path
+	}
+
+	c.Run("shebang", func(c *qt.C) {
+		path := writeScript("bash.sh", "#!/usr/bin/env bash\necho \"${BASH_VERSION:+bash} $1\"\n")
+		out, err := run.ScriptFile(ctx, path, "hello world").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "bash hello world")
+	})
+
+	c.Run("no shebang", func(c *qt.C) {
+		path := writeScript("plain.sh", "echo \"$@\"")
+		out, err := run.ScriptFile(ctx, path, "a", "b").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "a b")
+	})
+
+	c.Run("explicit interpreter", func(c *qt.C) {
+		path := writeScript("other.sh", "#!/bin/false\necho \"$1\"\n")
+		out, err := run.ScriptFileWith(ctx, "sh", path, "hello").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "hello")
+	})
+
+	c.Run("missing", func(c *qt.C) {
+		err := run.ScriptFile(ctx, filepath.Join(dir, "missing.sh")).Run().Wait()
+		c.Assert(err, qt.ErrorIs, os.ErrNotExist)
+	})
+}
+
 func TestInput(t *testing.T) {
 	c := qt.New(t)
 	ctx := context.Background()
@@ -285,6 +686,75 @@ func TestInput(t *testing.T) {
 		c.Assert(lines, qt.CmpEquals(), []string{"hello world"})
 	})
 
+	c.Run("input helpers", func(c *qt.C) {
+		path := filepath.Join(c.TempDir(), "input")
+		c.Assert(os.WriteFile(path, []byte("file\n"), 0o644), qt.IsNil)
+
+		cmd := run.Cmd(ctx, "cat").
+			InputString("string ").
+			InputBytes([]byte("bytes ")).
+			InputFile(path)
+
+		// The file is opened lazily, so changes before running are reflected.
+		c.Assert(os.WriteFile(path, []byte("updated file\n"), 0o644), qt.IsNil)
+
+		lines, err := cmd.Run().Lines()
+		c.Assert(err, qt.IsNil)
+		c.Assert(lines, qt.CmpEquals(), []string{"string bytes updated file"})
+	})
+
+	c.Run("missing input file", func(c *qt.C) {
+		err := run.Cmd(ctx, "cat").
+			InputFile(filepath.Join(c.TempDir(), "missing")).
+			Run().Wait()
+		c.Assert(err, qt.ErrorIs, os.ErrNotExist)
+	})
+
+	c.Run("input file not read", func(c *qt.C) {
+		path := filepath.Join(c.TempDir(), "input")
+		c.Assert(os.WriteFile(path, []byte("hello\n"), 0o644), qt.IsNil)
+		out, err := run.Cmd(ctx, "echo done").InputFile(path).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "done")
+	})
+
+	c.Run("stdin pipe", func(c *qt.C) {
+		cmd := run.Bash(ctx, `read name; echo "hello $name"; read answer; echo "got $answer"`)
+		stdin, err := cmd.StdinPipe()
+		c.Assert(err, qt.IsNil)
+
+		output := cmd.Run()
+		_, err = io.WriteString(stdin, "world\n")
+		c.Assert(err, qt.IsNil)
+
+		var lines []string
+		err = output.StreamLines(func(line string) {
+			lines = append(lines, line)
+			if line == "hello world" {
+				// Respond to output
+				_, _ = io.WriteString(stdin, "yes\n")
+				_ = stdin.Close()
+			}
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(lines, qt.CmpEquals(), []string{"hello world", "got yes"})
+
+		// Writes fail once the command has exited
+		_, err = io.WriteString(stdin, "more\n")
+		c.Assert(err, qt.IsNotNil)
+	})
+
+	c.Run("stdin pipe after exit", func(c *qt.C) {
+		cmd := run.Cmd(ctx, "true")
+		stdin, err := cmd.StdinPipe()
+		c.Assert(err, qt.IsNil)
+		defer stdin.Close()
+
+		c.Assert(cmd.Run().Wait(), qt.IsNil)
+		_, err = stdin.Write(bytes.Repeat([]byte("x"), 1<<20))
+		c.Assert(err, qt.IsNotNil)
+	})
+
 	c.Run("reset input", func(c *qt.C) {
 		cmd := run.Cmd(ctx, "cat").
 			Input(strings.NewReader("hello")).
@@ -296,3 +766,1018 @@ func TestInput(t *testing.T) {
 		c.Assert(lines, qt.CmpEquals(), []string{"world"})
 	})
 }
+
+func TestFingerprint(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	fingerprint := func(c *qt.C, cmd *run.Command) string {
+		f, err := cmd.Fingerprint()
+		c.Assert(err, qt.IsNil)
+		return f
+	}
+
+	c.Run("stable", func(c *qt.C) {
+		env := map[string]string{"A": "1", "B": "2", "C": "3"}
+		c.Assert(fingerprint(c, run.Cmd(ctx, "echo hello").Env(env)), qt.Equals,
+			fingerprint(c, run.Cmd(ctx, "echo", "hello").Env(env)))
+	})
+
+	c.Run("distinct", func(c *qt.C) {
+		base := fingerprint(c, run.Cmd(ctx, "echo hello"))
+		for _, cmd := range []*run.Command{
+			run.Cmd(ctx, "echo", run.Arg("hello world")),
+			run.Cmd(ctx, "echo hello").Dir("/"),
+			run.Cmd(ctx, "echo hello").Env(map[string]string{"A": "1"}),
+			run.Cmd(ctx, "echo hello").StdOut(),
+			run.Cmd(ctx, "echo hello").Input(strings.NewReader("input")),
+			run.Cmd(ctx, "echo hello").Nice(5),
+			run.Cmd(ctx, "echo hello").Umask(0o077),
+			run.Cmd(ctx, "echo hello").Sudo(""),
+			run.Cmd(ctx, "echo hello").Cgroup(run.CgroupLimits{Memory: 1 << 20}),
+		} {
+			c.Assert(fingerprint(c, cmd), qt.Not(qt.Equals), base)
+		}
+	})
+
+	c.Run("env overrides", func(c *qt.C) {
+		cmd := func(v string) *run.Command {
+			return run.Cmd(ctx, "echo hello").
+				Env(map[string]string{"A": "1", "IGNORED": v}).
+				FingerprintEnv("A")
+		}
+		c.Assert(fingerprint(c, cmd("1")), qt.Equals, fingerprint(c, cmd("2")))
+		c.Assert(fingerprint(c, cmd("1")), qt.Not(qt.Equals),
+			fingerprint(c, run.Cmd(ctx, "echo hello").FingerprintEnv("A")))
+	})
+
+	c.Run("expanded arguments", func(c *qt.C) {
+		cmd := func(v string) *run.Command {
+			return run.Cmd(ctx, "echo", "${GREETING}").
+				Env(map[string]string{"GREETING": v}).
+				FingerprintEnv().
+				ExpandEnv()
+		}
+		c.Assert(fingerprint(c, cmd("hello")), qt.Not(qt.Equals), fingerprint(c, cmd("goodbye")))
+	})
+
+	c.Run("StdinPipe", func(c *qt.C) {
+		cmd := run.Cmd(ctx, "cat")
+		stdin, err := cmd.StdinPipe()
+		c.Assert(err, qt.IsNil)
+		defer stdin.Close()
+		_, err = cmd.Fingerprint()
+		c.Assert(err, qt.ErrorMatches, "cannot fingerprint a command with input from StdinPipe")
+	})
+
+	c.Run("input is preserved", func(c *qt.C) {
+		cmd := run.Cmd(ctx, "cat").Input(strings.NewReader("hello"))
+		first := fingerprint(c, cmd)
+		c.Assert(fingerprint(c, cmd), qt.Equals, first)
+
+		out, err := cmd.Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "hello")
+	})
+}
+
+func TestLineLatency(t *testing.T) {
+	c := qt.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// sed buffers output when it is not written to a terminal, so output would only
+	// arrive once input is closed without LineLatency.
+	input, w := io.Pipe()
+	go w.Write([]byte("hello\n"))
+	go func() {
+		<-ctx.Done()
+		w.Close()
+	}()
+
+	var lines []string
+	err := run.Cmd(ctx, "sed s/hello/world/").
+		Input(input).
+		LineLatency().
+		Run().
+		StreamLines(func(line string) {
+			// Output should arrive before input is closed.
+			c.Check(ctx.Err(), qt.IsNil)
+			lines = append(lines, strings.TrimSuffix(line, "\r"))
+			w.Close()
+		})
+	c.Assert(err, qt.IsNil)
+	c.Assert(lines, qt.CmpEquals(), []string{"world"})
+}
+
+func TestSpillCodec(t *testing.T) {
+	c := qt.New(t)
+	ctx := run.WithSpillCodec(context.Background(), run.GzipSpillCodec)
+
+	// Produce output well beyond what is buffered in memory before consuming it.
+	c.Run("Lines", func(c *qt.C) {
+		out := run.Bash(ctx, "for i in $(seq 1 100000); do echo \"line $i\"; done").Run()
+		c.Assert(out.Wait(), qt.IsNil)
+		lines, err := out.Lines()
+		c.Assert(err, qt.IsNil)
+		c.Assert(lines, qt.HasLen, 100000)
+		c.Assert(lines[99999], qt.Equals, "line 100000")
+	})
+
+	c.Run("JQ", func(c *qt.C) {
+		out := run.Bash(ctx, "for i in $(seq 1 100000); do echo \"{\\\"i\\\": $i}\"; done").Run()
+		c.Assert(out.Wait(), qt.IsNil)
+		res, err := out.JQ("select(.i == 100000) | .i")
+		c.Assert(err, qt.IsNil)
+		c.Assert(string(res), qt.Equals, "100000")
+	})
+}
+
+func TestCmdf(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		format string
+		args   []interface{}
+		want   []string
+	}{{
+		name:   "spaces",
+		format: "echo %s",
+		args:   []interface{}{"hello   world"},
+		want:   []string{"hello   world"},
+	}, {
+		name:   "quotes",
+		format: "echo %s %s",
+		args:   []interface{}{`it's`, `"quoted"`},
+		want:   []string{`it's "quoted"`},
+	}, {
+		name:   "verbs",
+		format: "echo %05d %.2f %q",
+		args:   []interface{}{42, 3.14159, "hi"},
+		want:   []string{`00042 3.14 "hi"`},
+	}, {
+		name:   "injection",
+		format: "echo %s",
+		args:   []interface{}{"$(whoami); rm -rf /"},
+		want:   []string{"$(whoami); rm -rf /"},
+	}} {
+		c.Run(tc.name, func(c *qt.C) {
+			lines, err := run.Cmdf(ctx, tc.format, tc.args...).Run().Lines()
+			c.Assert(err, qt.IsNil)
+			c.Assert(lines, qt.CmpEquals(), tc.want)
+		})
+	}
+
+	c.Run("documented example", func(c *qt.C) {
+		cmd := run.Cmdf(ctx, "git commit -m %s --author %s", "fix: it's done", "Jane Doe <jane@example.com>")
+		c.Assert(cmd.Config().Args, qt.DeepEquals,
+			[]string{"git", "commit", "-m", "fix: it's done", "--author", "Jane Doe <jane@example.com>"})
+	})
+}
+
+func TestArgs(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	argv := []string{"echo", "it's", `"quoted"`, "hello   world"}
+	out, err := run.Args(ctx, argv).Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, `it's "quoted" hello   world`)
+
+	c.Run("empty", func(c *qt.C) {
+		err := run.Args(ctx, nil).Run().Wait()
+		c.Assert(err, qt.IsNotNil)
+	})
+
+	c.Run("append", func(c *qt.C) {
+		verbose := true
+		cmd := run.Cmd(ctx, "echo hello").Arg("it's")
+		if verbose {
+			cmd.Args("--verbose", "hello   world")
+		}
+		out, err := cmd.Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "hello it's --verbose hello   world")
+	})
+
+	c.Run("CmdStrict", func(c *qt.C) {
+		out, err := run.CmdStrict(ctx, "echo", "it's", `"quoted"`, "hello   world").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, `it's "quoted" hello   world`)
+	})
+}
+
+func TestClone(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	base := run.Bash(ctx, `echo "$GREETING from $(pwd)"`).
+		Env(map[string]string{"GREETING": "hello"})
+
+	dirs := []string{"/", os.TempDir()}
+	results := make([]string, len(dirs))
+	errs := make(chan error, len(dirs))
+	for i, dir := range dirs {
+		go func(i int, dir string) {
+			var err error
+			results[i], err = base.Clone().
+				Dir(dir).
+				Env(map[string]string{"GREETING": "hi"}).
+				Run().
+				String()
+			errs <- err
+		}(i, dir)
+	}
+	for range dirs {
+		c.Assert(<-errs, qt.IsNil)
+	}
+	for i, dir := range dirs {
+		c.Assert(results[i], qt.Equals, "hi from "+dir)
+	}
+
+	// The base command should be unaffected
+	out, err := base.Dir("/").Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "hello from /")
+}
+
+func TestCommandString(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("args", func(c *qt.C) {
+		cmd := run.Cmd(ctx, "echo", run.Arg("hello world")).Arg("it's")
+		c.Assert(cmd.String(), qt.Equals, `echo 'hello world' it\'s`)
+	})
+
+	c.Run("env and dir", func(c *qt.C) {
+		cmd := run.Cmd(ctx, "ls -la").
+			Environ(os.Environ()).
+			Env(map[string]string{"FOO": "bar baz"}).
+			Dir("/tmp/some dir")
+		c.Assert(cmd.String(), qt.Equals, `cd '/tmp/some dir' && FOO='bar baz' ls -la`)
+	})
+
+	c.Run("invalid", func(c *qt.C) {
+		cmd := run.Cmd(ctx, "echo 'hello")
+		c.Assert(cmd.String(), qt.Equals, "<invalid command: provided parts has unclosed quotes>")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("valid", func(c *qt.C) {
+		c.Assert(run.Cmd(ctx, "echo hello").Dir(c.TempDir()).Validate(), qt.IsNil)
+	})
+
+	c.Run("build error", func(c *qt.C) {
+		err := run.Cmd(ctx, "echo 'hello").Validate()
+		c.Assert(err, qt.ErrorMatches, "provided parts has unclosed quotes")
+	})
+
+	c.Run("command not found", func(c *qt.C) {
+		err := run.Cmd(ctx, "definitely-not-a-real-command").Validate()
+		c.Assert(err, qt.ErrorIs, exec.ErrNotFound)
+	})
+
+	c.Run("directory not found", func(c *qt.C) {
+		dir := filepath.Join(c.TempDir(), "missing")
+		err := run.Cmd(ctx, "echo hello").Dir(dir).Validate()
+		c.Assert(err, qt.ErrorIs, os.ErrNotExist)
+	})
+
+	c.Run("relative to directory", func(c *qt.C) {
+		dir := c.TempDir()
+		c.Assert(os.WriteFile(filepath.Join(dir, "script.sh"), []byte("#!/bin/sh\n"), 0o755), qt.IsNil)
+		c.Assert(run.Cmd(ctx, "./script.sh").Dir(dir).Validate(), qt.IsNil)
+		c.Assert(run.Cmd(ctx, "./script.sh").Validate(), qt.IsNotNil)
+	})
+}
+
+func TestAllocatePort(t *testing.T) {
+	c := qt.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	port, err := run.AllocatePort(ctx)
+	c.Assert(err, qt.IsNil)
+	c.Assert(port.Number, qt.Not(qt.Equals), 0)
+
+	// The port is reserved until the command starts
+	_, err = net.Listen("tcp", "127.0.0.1:"+port.String())
+	c.Assert(err, qt.IsNotNil)
+
+	err = run.Cmd(ctx, "echo", port.String()).ReservePorts(port).Run().Wait()
+	c.Assert(err, qt.IsNil)
+
+	// The port is released once the command has started
+	listener, err := net.Listen("tcp", "127.0.0.1:"+port.String())
+	c.Assert(err, qt.IsNil)
+	listener.Close()
+	c.Assert(port.Release(), qt.IsNil)
+}
+
+func TestDryRun(t *testing.T) {
+	c := qt.New(t)
+	var logged []run.ExecutedCommand
+	ctx := run.LogCommands(context.Background(), func(e run.ExecutedCommand) {
+		logged = append(logged, e)
+	})
+	ctx = run.DryRun(ctx)
+
+	dir := c.TempDir()
+	file := filepath.Join(dir, "file")
+	out, err := run.Cmd(ctx, "touch", file).
+		Env(map[string]string{"FOO": "bar"}).
+		Dir(dir).
+		Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "cd "+dir+" && FOO=bar touch "+file)
+
+	// Command is logged, but not executed
+	c.Assert(logged, qt.HasLen, 1)
+	_, err = os.Stat(file)
+	c.Assert(err, qt.ErrorIs, os.ErrNotExist)
+
+	c.Run("InputFile", func(c *qt.C) {
+		if _, err := os.ReadDir("/proc/self/fd"); err != nil {
+			c.Skip("/proc/self/fd not available")
+		}
+		input := filepath.Join(dir, "input")
+		c.Assert(os.WriteFile(input, []byte("hello world"), 0o600), qt.IsNil)
+
+		// Capturing part of the input opens the file.
+		ctx := run.CaptureInput(ctx, 5, nil)
+		err := run.Cmd(ctx, "cat").InputFile(input).Run().Wait()
+		c.Assert(err, qt.IsNil)
+		c.Assert(logged[len(logged)-1].Input.Truncated, qt.IsTrue)
+
+		// The file is closed even though the command is not run.
+		fds, err := os.ReadDir("/proc/self/fd")
+		c.Assert(err, qt.IsNil)
+		for _, fd := range fds {
+			target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+			c.Assert(target, qt.Not(qt.Equals), input)
+		}
+	})
+}
+
+func TestInheritEnv(t *testing.T) {
+	c := qt.New(t)
+	t.Setenv("RUN_TEST_INHERITED", "inherited")
+	ctx := context.Background()
+	script := `echo "inherited=$RUN_TEST_INHERITED set=$RUN_TEST_SET"`
+
+	c.Run("inherited by default", func(c *qt.C) {
+		out, err := run.Bash(ctx, script).
+			Env(map[string]string{"RUN_TEST_SET": "set"}).
+			Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "inherited=inherited set=set")
+	})
+
+	c.Run("set values take precedence", func(c *qt.C) {
+		out, err := run.Bash(ctx, script).
+			Env(map[string]string{"RUN_TEST_INHERITED": "overridden"}).
+			Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "inherited=overridden set=")
+	})
+
+	c.Run("opt out", func(c *qt.C) {
+		bash, err := exec.LookPath("bash")
+		c.Assert(err, qt.IsNil)
+		out, err := run.Shell(ctx, bash, script).
+			Env(map[string]string{"RUN_TEST_SET": "set"}).
+			InheritEnv(false).
+			Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "inherited= set=set")
+	})
+
+	c.Run("opt out in context", func(c *qt.C) {
+		ctx := run.WithoutInheritedEnv(ctx)
+		bash, err := exec.LookPath("bash")
+		c.Assert(err, qt.IsNil)
+
+		out, err := run.Shell(ctx, bash, script).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "inherited= set=")
+
+		out, err = run.Shell(ctx, bash, script).InheritEnv(true).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "inherited=inherited set=")
+	})
+}
+
+func TestHermetic(t *testing.T) {
+	c := qt.New(t)
+	t.Setenv("RUN_TEST_INHERITED", "inherited")
+	t.Setenv("RUN_TEST_ALLOWED", "allowed")
+	t.Setenv("LANG", "fr_FR.UTF-8")
+	t.Setenv("SOURCE_DATE_EPOCH", "1")
+	ctx := context.Background()
+
+	out, err := run.Cmd(ctx, "env").
+		Env(map[string]string{"HOME": "/home/hermetic"}).
+		Hermetic("RUN_TEST_ALLOWED").
+		Run().Lines()
+	c.Assert(err, qt.IsNil)
+	sort.Strings(out)
+	c.Assert(out, qt.DeepEquals, []string{
+		"HOME=/home/hermetic",
+		"LANG=C.UTF-8",
+		"PATH=" + os.Getenv("PATH"),
+		"RUN_TEST_ALLOWED=allowed",
+		"SOURCE_DATE_EPOCH=1",
+	})
+}
+
+func TestUnsetenv(t *testing.T) {
+	c := qt.New(t)
+	t.Setenv("RUN_TEST_INHERITED", "inherited")
+	ctx := context.Background()
+	script := `echo "inherited=${RUN_TEST_INHERITED-unset} set=${RUN_TEST_SET-unset}"`
+
+	c.Run("later values take precedence", func(c *qt.C) {
+		cmd := run.Bash(ctx, script).
+			Env(map[string]string{"RUN_TEST_SET": "a"}).
+			Environ([]string{"RUN_TEST_SET=b"})
+		out, err := cmd.Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "inherited=inherited set=b")
+		c.Assert(cmd.String(), qt.Contains, "RUN_TEST_SET=b bash")
+		c.Assert(cmd.String(), qt.Not(qt.Contains), "RUN_TEST_SET=a")
+	})
+
+	c.Run("unset inherited", func(c *qt.C) {
+		out, err := run.Bash(ctx, script).Unsetenv("RUN_TEST_INHERITED").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "inherited=unset set=unset")
+	})
+
+	c.Run("unset then set", func(c *qt.C) {
+		out, err := run.Bash(ctx, script).
+			Env(map[string]string{"RUN_TEST_SET": "a"}).
+			Unsetenv("RUN_TEST_INHERITED", "RUN_TEST_SET").
+			Env(map[string]string{"RUN_TEST_INHERITED": "b"}).
+			Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "inherited=b set=unset")
+	})
+}
+
+func TestEnvFile(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("ParseEnvFile", func(c *qt.C) {
+		environ, err := run.ParseEnvFile(strings.NewReader(`
+# comment
+PLAIN=hello world # comment
+export EXPORTED=1
+EMPTY=
+SINGLE='$HOME "# not a comment"'
+DOUBLE="line\nbreak \"quoted\" \\ # not a comment" # comment
+URL=http://example.com/#anchor
+`))
+		c.Assert(err, qt.IsNil)
+		c.Assert(environ, qt.DeepEquals, []string{
+			"PLAIN=hello world",
+			"EXPORTED=1",
+			"EMPTY=",
+			`SINGLE=$HOME "# not a comment"`,
+			"DOUBLE=line\nbreak \"quoted\" \\ # not a comment",
+			"URL=http://example.com/#anchor",
+		})
+	})
+
+	c.Run("ParseEnvFile errors", func(c *qt.C) {
+		_, err := run.ParseEnvFile(strings.NewReader("A=1\nB\n"))
+		c.Assert(err, qt.ErrorMatches, "line 2: expected KEY=VALUE")
+		_, err = run.ParseEnvFile(strings.NewReader(`A="unterminated \"`))
+		c.Assert(err, qt.ErrorMatches, "line 1: unterminated quoted value")
+	})
+
+	c.Run("EnvFile", func(c *qt.C) {
+		dir := c.TempDir()
+		base := filepath.Join(dir, ".env")
+		local := filepath.Join(dir, ".env.local")
+		c.Assert(os.WriteFile(base, []byte("A=base\nB=base\n"), 0o644), qt.IsNil)
+		c.Assert(os.WriteFile(local, []byte("B=local\n"), 0o644), qt.IsNil)
+
+		out, err := run.Bash(ctx, `echo "$A $B $C"`).
+			Env(map[string]string{"A": "env", "C": "env"}).
+			EnvFile(base).
+			EnvFile(local).
+			Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "base local env")
+	})
+
+	c.Run("EnvFile missing", func(c *qt.C) {
+		err := run.Cmd(ctx, "echo").EnvFile(filepath.Join(c.TempDir(), ".env")).Run().Wait()
+		c.Assert(err, qt.ErrorIs, os.ErrNotExist)
+	})
+}
+
+func TestExpandEnv(t *testing.T) {
+	c := qt.New(t)
+	t.Setenv("RUN_TEST_INHERITED", "inherited")
+	ctx := context.Background()
+
+	c.Run("expanded", func(c *qt.C) {
+		out, err := run.Cmd(ctx, "echo", run.Arg("--env=${ENVIRONMENT}"), "${RUN_TEST_INHERITED} ${EMPTY}end").
+			ExpandEnv().
+			Env(map[string]string{"ENVIRONMENT": "staging", "EMPTY": ""}).
+			Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "--env=staging inherited end")
+	})
+
+	c.Run("only braced references", func(c *qt.C) {
+		out, err := run.Cmd(ctx, "echo", run.Arg("$RUN_TEST_INHERITED $1 $$ ${1} ${} ${RUN_TEST_INHERITED")).
+			ExpandEnv().
+			Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "$RUN_TEST_INHERITED $1 $$ ${1} ${} ${RUN_TEST_INHERITED")
+
+		out, err = run.Cmd(ctx, "awk", run.Arg("{print $1}")).
+			ExpandEnv().
+			Input(strings.NewReader("hello world")).
+			Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "hello")
+	})
+
+	c.Run("not set", func(c *qt.C) {
+		err := run.Cmd(ctx, "echo", run.Arg("${RUN_TEST_MISSING}")).
+			ExpandEnv().
+			Run().Wait()
+		c.Assert(err, qt.ErrorMatches, `.*environment variable "RUN_TEST_MISSING" is not set`)
+	})
+
+	c.Run("not inherited", func(c *qt.C) {
+		err := run.Cmd(ctx, "/bin/echo", "x${RUN_TEST_INHERITED}").
+			ExpandEnv().
+			InheritEnv(false).
+			Run().Wait()
+		c.Assert(err, qt.ErrorMatches, `.*environment variable "RUN_TEST_INHERITED" is not set`)
+	})
+
+	c.Run("disabled by default", func(c *qt.C) {
+		out, err := run.Cmd(ctx, "echo", run.Arg("${RUN_TEST_INHERITED}")).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "${RUN_TEST_INHERITED}")
+	})
+}
+
+func TestLocale(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	out, err := run.Bash(ctx, `echo "$LANG $LC_ALL"`).Locale("C.UTF-8").Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "C.UTF-8 C.UTF-8")
+}
+
+func TestHooks(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("modify command", func(c *qt.C) {
+		var results []run.ExecutedCommandResult
+		cmd := run.Cmd(ctx, "echo hello").
+			BeforeStart(func(cmd *run.ExecutedCommand) error {
+				cmd.Args = append(cmd.Args, "world")
+				return nil
+			}).
+			AfterExit(func(result run.ExecutedCommandResult) {
+				results = append(results, result)
+			})
+
+		out, err := cmd.Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "hello world")
+		c.Assert(results, qt.HasLen, 1)
+		c.Assert(results[0].Command.Args, qt.DeepEquals, []string{"echo", "hello", "world"})
+		c.Assert(results[0].Err, qt.IsNil)
+		c.Assert(results[0].StartedAt.IsZero(), qt.IsFalse)
+
+		// The command itself is not modified
+		c.Assert(cmd.String(), qt.Equals, "echo hello")
+	})
+
+	c.Run("declined", func(c *qt.C) {
+		errDeclined := errors.New("declined")
+		var exited bool
+		err := run.Cmd(ctx, "touch", filepath.Join(c.TempDir(), "file")).
+			BeforeStart(func(*run.ExecutedCommand) error { return errDeclined }).
+			AfterExit(func(run.ExecutedCommandResult) { exited = true }).
+			Run().Wait()
+		c.Assert(err, qt.ErrorIs, errDeclined)
+		c.Assert(exited, qt.IsFalse)
+	})
+
+	c.Run("failed", func(c *qt.C) {
+		var result run.ExecutedCommandResult
+		err := run.Bash(ctx, "exit 3").
+			AfterExit(func(r run.ExecutedCommandResult) { result = r }).
+			Run().Wait()
+		c.Assert(run.ExitCode(err), qt.Equals, 3)
+		c.Assert(run.ExitCode(result.Err), qt.Equals, 3)
+	})
+
+	c.Run("failed to start", func(c *qt.C) {
+		var result run.ExecutedCommandResult
+		err := run.Cmd(ctx, "non-existing-binary").
+			AfterExit(func(r run.ExecutedCommandResult) { result = r }).
+			Run().Wait()
+		c.Assert(err, qt.IsNotNil)
+		c.Assert(result.Err, qt.Equals, err)
+	})
+}
+
+func TestSudo(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("String", func(c *qt.C) {
+		ctx := context.Background()
+		c.Assert(run.Cmd(ctx, "whoami").Sudo("").String(), qt.Equals, "sudo -- whoami")
+		c.Assert(run.Sudo(run.Cmd(ctx, "whoami")).String(), qt.Equals, "sudo -- whoami")
+		c.Assert(run.Cmd(ctx, "whoami").SudoWith(run.SudoOpts{
+			User:           "nobody",
+			NonInteractive: true,
+		}).String(), qt.Equals, "sudo -n -u nobody -- whoami")
+	})
+
+	c.Run("password", func(c *qt.C) {
+		var logged run.ExecutedCommand
+		ctx := run.DryRun(run.CaptureInput(run.LogCommands(context.Background(), func(e run.ExecutedCommand) {
+			logged = e
+		}), 1024, nil))
+		ctx, _ = run.RedactSecrets(ctx)
+
+		err := run.Cmd(ctx, "cat").
+			SudoWith(run.SudoOpts{Password: "hunter2"}).
+			Input(strings.NewReader("input")).
+			Run().Wait()
+		c.Assert(err, qt.IsNil)
+		c.Assert(logged.Args, qt.DeepEquals, []string{"sudo", "-k", "-S", "-p", "", "--", "cat"})
+		// The password is not captured
+		c.Assert(string(logged.Input.Content), qt.Equals, "input")
+	})
+}
+
+func TestPriority(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Nice", func(c *qt.C) {
+		if _, err := exec.LookPath("nice"); err != nil {
+			c.Skip("nice not available")
+		}
+		out, err := run.Cmd(ctx, "nice").Nice(5).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "5")
+	})
+
+	c.Run("IONice", func(c *qt.C) {
+		if _, err := exec.LookPath("ionice"); err != nil {
+			c.Skip("ionice not available")
+		}
+		out, err := run.Cmd(ctx, "ionice").IONice(run.IOClassIdle, 0).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "idle")
+	})
+}
+
+func TestDiscard(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("success", func(c *qt.C) {
+		err := run.Bash(ctx, `echo "stdout"; echo "stderr" 1>&2`).RunDiscard()
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("error includes stderr tail", func(c *qt.C) {
+		err := run.Bash(ctx, `head -c 10000 /dev/zero | tr '\0' a 1>&2; echo " tail" 1>&2; exit 1`).RunDiscard()
+		c.Assert(err, qt.IsNotNil)
+		c.Assert(run.ExitCode(err), qt.Equals, 1)
+		c.Assert(strings.HasSuffix(err.Error(), "a tail"), qt.IsTrue)
+		c.Assert(len(err.Error()) < 5000, qt.IsTrue)
+	})
+}
+
+func TestExecAndCapture(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Assert(run.Exec(ctx, "echo hello"), qt.IsNil)
+	c.Assert(run.ExitCode(run.Exec(ctx, "bash -c", run.Arg("exit 2"))), qt.Equals, 2)
+
+	out, err := run.Capture(ctx, "echo", run.Arg("hello world"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "hello world")
+
+	var logged []string
+	ctx = run.LogCommands(ctx, func(e run.ExecutedCommand) { logged = append(logged, e.Args[0]) })
+	_, err = run.Capture(ctx, "echo hello")
+	c.Assert(err, qt.IsNil)
+	c.Assert(logged, qt.DeepEquals, []string{"echo"})
+}
+
+func TestArgHelpers(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("ArgList", func(c *qt.C) {
+		out, err := run.Cmd(ctx, "echo", run.ArgList("it's", "hello   world")).Run().Lines()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.DeepEquals, []string{"it's hello   world"})
+		c.Assert(run.ArgList(), qt.Equals, "")
+	})
+
+	c.Run("Path", func(c *qt.C) {
+		c.Assert(run.Path("foo/../my dir/"), qt.Equals, run.Arg("my dir"))
+	})
+
+	c.Run("Glob", func(c *qt.C) {
+		dir := c.TempDir()
+		for _, name := range []string{"a.txt", "b c.txt", "d.go"} {
+			c.Assert(os.WriteFile(filepath.Join(dir, name), nil, 0o644), qt.IsNil)
+		}
+		out, err := run.Cmd(ctx, "ls -1", run.Glob(filepath.Join(dir, "*.txt"))).Run().Lines()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.DeepEquals, []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b c.txt")})
+
+		// No matches
+		c.Assert(run.Glob(filepath.Join(dir, "*.rs")), qt.Equals, run.Arg(filepath.Join(dir, "*.rs")))
+	})
+}
+
+func TestTemplate(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	tmpl, err := run.NewTemplate(`echo {{.Name}} {{if .Verbose}}--verbose{{end}} {{range .Files}}{{.}} {{end}}{{.Extra}}`)
+	c.Assert(err, qt.IsNil)
+
+	type data struct {
+		Name    string
+		Verbose bool
+		Files   []string
+		Extra   []string
+	}
+	cmd := tmpl.Cmd(ctx, data{
+		Name:    "it's a name",
+		Verbose: true,
+		Files:   []string{"a b", `"c"`},
+		Extra:   []string{"x y", "z"},
+	})
+	c.Assert(cmd.String(), qt.Equals, run.ArgList("echo", "it's a name", "--verbose", "a b", `"c"`, "x y", "z"))
+	out, err := cmd.Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, `it's a name --verbose a b "c" x y z`)
+
+	c.Run("missing key", func(c *qt.C) {
+		err := tmpl.Cmd(ctx, map[string]interface{}{"Name": "foo"}).Run().Wait()
+		c.Assert(err, qt.IsNotNil)
+	})
+
+	c.Run("parse error", func(c *qt.C) {
+		_, err := run.NewTemplate("echo {{.Name")
+		c.Assert(err, qt.IsNotNil)
+	})
+}
+
+func TestExpandGlobs(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	for _, name := range []string{"a.go", "b.go", "c.txt"} {
+		c.Assert(os.WriteFile(filepath.Join(dir, name), nil, 0o644), qt.IsNil)
+	}
+
+	out, err := run.Cmd(ctx, "echo *.go *.rs").Dir(dir).ExpandGlobs().Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "a.go b.go *.rs")
+
+	out, err = run.Cmd(ctx, "echo", filepath.Join(dir, "*.txt")).ExpandGlobs().Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, filepath.Join(dir, "c.txt"))
+
+	out, err = run.Cmd(ctx, "echo *.go").Dir(dir).Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "*.go")
+}
+
+func TestRequireApproval(t *testing.T) {
+	c := qt.New(t)
+
+	var asked []string
+	approve := func(approved bool) run.ApproveFunc {
+		return func(ctx context.Context, cmd run.ExecutedCommand) (bool, error) {
+			asked = append(asked, strings.Join(cmd.Args, " "))
+			return approved, nil
+		}
+	}
+
+	c.Run("approved", func(c *qt.C) {
+		asked = nil
+		ctx := run.RequireApproval(context.Background(), approve(true))
+		out, err := run.Cmd(ctx, "echo deleted").Destructive().Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "deleted")
+		c.Assert(asked, qt.DeepEquals, []string{"echo deleted"})
+	})
+
+	c.Run("declined", func(c *qt.C) {
+		asked = nil
+		ctx, history := run.History(context.Background())
+		var registry run.CommandRegistry
+		ctx = run.RecordCommands(ctx, &registry)
+		ctx = run.RequireApproval(ctx, approve(false))
+		err := run.Cmd(ctx, "echo deleted").Destructive().Run().Wait()
+		var declined *run.DeclinedError
+		c.Assert(errors.As(err, &declined), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(declined.Command.Args, qt.DeepEquals, []string{"echo", "deleted"})
+
+		// Declined commands are not recorded
+		c.Assert(history.Commands(), qt.HasLen, 0)
+		c.Assert(registry.Commands(), qt.HasLen, 0)
+
+		// Commands that are not destructive are not affected
+		c.Assert(run.Cmd(ctx, "echo hello").Run().Wait(), qt.IsNil)
+		c.Assert(asked, qt.DeepEquals, []string{"echo deleted"})
+	})
+
+	c.Run("error", func(c *qt.C) {
+		ctx := run.RequireApproval(context.Background(), func(ctx context.Context, cmd run.ExecutedCommand) (bool, error) {
+			return false, errors.New("policy unavailable")
+		})
+		err := run.Cmd(ctx, "echo deleted").Destructive().Run().Wait()
+		c.Assert(err, qt.ErrorMatches, ".*policy unavailable")
+	})
+}
+
+func TestFlags(t *testing.T) {
+	c := qt.New(t)
+
+	type Common struct {
+		Debug bool `flag:"--debug"`
+	}
+	type opts struct {
+		Common
+		Name     string        `flag:"--name"`
+		Detach   bool          `flag:"-d"`
+		Env      []string      `flag:"--env"`
+		Replicas *int          `flag:"--replicas"`
+		TTY      *bool         `flag:"--tty"`
+		Timeout  time.Duration `flag:"--timeout"`
+		Ignored  string        `flag:"-"`
+		Untagged string
+	}
+
+	zero, no := 0, false
+	c.Assert(run.Flags(opts{
+		Common:   Common{Debug: true},
+		Name:     "my container",
+		Detach:   true,
+		Env:      []string{"A=1", "B=2"},
+		Replicas: &zero,
+		TTY:      &no,
+		Timeout:  time.Minute,
+		Ignored:  "ignored",
+		Untagged: "ignored",
+	}), qt.DeepEquals, []string{
+		"--debug",
+		"--name", "my container",
+		"-d",
+		"--env", "A=1", "--env", "B=2",
+		"--replicas", "0",
+		"--tty=false",
+		"--timeout", "1m0s",
+	})
+
+	c.Assert(run.Flags(&opts{}), qt.HasLen, 0)
+	c.Assert(func() { run.Flags("foo") }, qt.PanicMatches, "run.Flags: expected struct, got string")
+
+	out, err := run.Cmd(context.Background(), "echo").Args(run.Flags(opts{Name: "it's"})...).Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "--name it's")
+}
+
+func TestTimeout(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("Timeout", func(c *qt.C) {
+		start := time.Now()
+		err := run.Cmd(ctx, "sleep 10").Timeout(50 * time.Millisecond).Run().Wait()
+		c.Assert(errors.Is(err, run.ErrTimeout), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(time.Since(start) < 5*time.Second, qt.IsTrue)
+	})
+
+	c.Run("Deadline", func(c *qt.C) {
+		err := run.Cmd(ctx, "sleep 10").
+			Timeout(time.Hour).
+			Deadline(time.Now().Add(50 * time.Millisecond)).
+			Run().Wait()
+		c.Assert(errors.Is(err, run.ErrTimeout), qt.IsTrue, qt.Commentf("got %v", err))
+	})
+
+	c.Run("exits before timeout", func(c *qt.C) {
+		err := run.Bash(ctx, "exit 2").Timeout(time.Hour).Run().Wait()
+		c.Assert(run.ExitCode(err), qt.Equals, 2)
+		c.Assert(errors.Is(err, run.ErrTimeout), qt.IsFalse)
+	})
+}
+
+func TestConfig(t *testing.T) {
+	c := qt.New(t)
+
+	cmd := run.Cmd(context.Background(), "git status").
+		Dir("/tmp").
+		Env(map[string]string{"FOO": "bar=baz"}).
+		Unsetenv("HOME").
+		StdOut()
+	config := cmd.Config()
+	c.Assert(config.Args, qt.DeepEquals, []string{"git", "status"})
+	c.Assert(config.Dir, qt.Equals, "/tmp")
+	c.Assert(config.EnvMap(), qt.DeepEquals, map[string]string{"FOO": "bar=baz"})
+	c.Assert(config.InheritEnv, qt.IsTrue)
+	c.Assert(config.Unsetenv, qt.DeepEquals, []string{"HOME"})
+	c.Assert(config.Attach.Stdout.String(), qt.Equals, "capture")
+	c.Assert(config.Attach.Stderr.String(), qt.Equals, "discard")
+
+	// Modifying the configuration does not modify the command
+	config.Args[0] = "rm"
+	c.Assert(cmd.Config().Args[0], qt.Equals, "git")
+}
+
+func TestNew(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	dir := c.TempDir()
+
+	opts := []run.Option{
+		run.WithArgs("-c", `echo "$FOO $(pwd)"; cat`),
+		run.WithDir(dir),
+		run.WithEnv(map[string]string{"FOO": "foo bar"}),
+		run.WithInput(strings.NewReader("input")),
+	}
+	verbose := false
+	if verbose {
+		opts = append(opts, run.WithArgs("--verbose"))
+	}
+	opts = append(opts, func(c *run.Command) { c.StdOut() })
+
+	out, err := run.New(ctx, "bash", opts...).Run().Lines()
+	c.Assert(err, qt.IsNil)
+	realDir, err := filepath.EvalSymlinks(dir)
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.DeepEquals, []string{"foo bar " + realDir, "input"})
+}
+
+func TestStart(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("kill", func(c *qt.C) {
+		p, err := run.Bash(ctx, "echo started; sleep 10").Start()
+		c.Assert(err, qt.IsNil)
+		c.Assert(p.Pid() > 0, qt.IsTrue)
+
+		var lines []string
+		err = p.Output().StreamLines(func(line string) {
+			lines = append(lines, line)
+			c.Assert(p.Kill(), qt.IsNil)
+		})
+		c.Assert(err, qt.IsNotNil)
+		c.Assert(lines, qt.DeepEquals, []string{"started"})
+		c.Assert(p.Kill(), qt.ErrorIs, os.ErrProcessDone)
+	})
+
+	c.Run("start error", func(c *qt.C) {
+		_, err := run.Cmd(ctx, "definitely-not-a-command").Start()
+		c.Assert(err, qt.IsNotNil)
+	})
+
+	c.Run("dry run", func(c *qt.C) {
+		p, err := run.Cmd(run.DryRun(ctx), "echo hello").Start()
+		c.Assert(err, qt.IsNil)
+		c.Assert(p.Pid(), qt.Equals, 0)
+		c.Assert(p.Kill(), qt.ErrorIs, os.ErrProcessDone)
+
+		out, err := p.Output().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "echo hello")
+	})
+}
@@ -0,0 +1,61 @@
+package run
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// GzipCompress creates a reader that provides the content of input compressed with gzip,
+// which can be used between commands when gzip is not available, for example:
+//
+//	dump := run.Cmd(ctx, "pg_dump mydb").Run()
+//	err := run.Cmd(ctx, "aws s3 cp - s3://backups/mydb.gz").
+//		Input(run.GzipCompress(dump)).
+//		Run().Wait()
+//
+// Input is compressed in the background as the returned reader is read, so the returned
+// reader should be read until it returns an error, such as io.EOF, or closed. When it is
+// provided to Command.Input, it is closed once the command exits, in case the command
+// does not read all of its input.
+func GzipCompress(input io.Reader) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(w)
+		_, err := io.Copy(gz, input)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		w.CloseWithError(err)
+	}()
+	return &gzipCompressReader{PipeReader: r}
+}
+
+// gzipCompressReader is the reader returned by GzipCompress. Closing it stops
+// compressing input, since writes to the pipe then fail.
+type gzipCompressReader struct{ *io.PipeReader }
+
+func (r *gzipCompressReader) Close() error { return r.CloseWithError(nil) }
+
+// GzipDecompress creates a reader that provides the content of the gzip-compressed input,
+// which can be used between commands when gzip is not available.
+func GzipDecompress(input io.Reader) io.Reader {
+	return &gzipDecompressReader{input: input}
+}
+
+// gzipDecompressReader defers creating a gzip.Reader until the first read, since
+// gzip.NewReader blocks on reading the gzip header.
+type gzipDecompressReader struct {
+	input  io.Reader
+	reader *gzip.Reader
+	err    error
+}
+
+func (r *gzipDecompressReader) Read(p []byte) (int, error) {
+	if r.reader == nil && r.err == nil {
+		r.reader, r.err = gzip.NewReader(r.input)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,141 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// Format denotes the format of a command's output, as detected by Output.Detect.
+type Format string
+
+const (
+	// FormatText denotes plain text, or output that does not match any other format.
+	FormatText Format = "text"
+	// FormatJSON denotes a single JSON value.
+	FormatJSON Format = "json"
+	// FormatNDJSON denotes newline-delimited JSON values.
+	FormatNDJSON Format = "ndjson"
+	// FormatYAML denotes a YAML document.
+	FormatYAML Format = "yaml"
+	// FormatCSV denotes comma-separated values with a consistent number of fields.
+	FormatCSV Format = "csv"
+)
+
+// detectPeekSize is the number of bytes Output.Detect inspects to determine the format.
+const detectPeekSize = 4096
+
+// peekFormat detects the format of the output available in source, without consuming
+// it.
+func peekFormat(source *bufio.Reader) (Format, error) {
+	peeked, err := source.Peek(detectPeekSize)
+	switch {
+	case err == nil:
+		return detectFormat(peeked, false), nil
+	case errors.Is(err, io.EOF):
+		return detectFormat(peeked, true), nil
+	default:
+		// Surface the error if we did not get any data, otherwise make a best-effort
+		// guess with the data available.
+		if len(peeked) == 0 {
+			return FormatText, err
+		}
+		return detectFormat(peeked, true), nil
+	}
+}
+
+var (
+	yamlDocumentStartRegexp = regexp.MustCompile(`^---(\s|$)`)
+	yamlMappingRegexp       = regexp.MustCompile(`^[\w.\-"']+:(\s|$)`)
+	yamlSequenceRegexp      = regexp.MustCompile(`^- `)
+)
+
+// detectFormat classifies the given data. If complete is false, data is a prefix of the
+// full output, so the last line may be incomplete.
+func detectFormat(data []byte, complete bool) Format {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return FormatText
+	}
+
+	lines := bytes.Split(trimmed, []byte("\n"))
+	if !complete && len(lines) > 1 {
+		lines = lines[:len(lines)-1] // last line may be incomplete
+	}
+
+	if trimmed[0] == '{' || trimmed[0] == '[' {
+		if f, ok := detectJSON(trimmed, lines, complete); ok {
+			return f
+		}
+	}
+
+	if yamlDocumentStartRegexp.Match(lines[0]) {
+		return FormatYAML
+	}
+	if isYAML(lines) {
+		return FormatYAML
+	}
+
+	if isCSV(lines) {
+		return FormatCSV
+	}
+
+	return FormatText
+}
+
+func detectJSON(data []byte, lines [][]byte, complete bool) (Format, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var v json.RawMessage
+	if err := dec.Decode(&v); err != nil {
+		// A truncated value is still likely to be JSON if we only have a prefix.
+		if !complete && errors.Is(err, io.ErrUnexpectedEOF) {
+			return FormatJSON, true
+		}
+		return "", false
+	}
+	if !dec.More() {
+		return FormatJSON, true
+	}
+
+	// Multiple values - check that each line is a value.
+	for _, l := range lines {
+		l = bytes.TrimSpace(l)
+		if len(l) > 0 && !json.Valid(l) {
+			return "", false
+		}
+	}
+	return FormatNDJSON, true
+}
+
+func isYAML(lines [][]byte) bool {
+	var matched int
+	for _, l := range lines {
+		if len(bytes.TrimSpace(l)) == 0 || bytes.HasPrefix(bytes.TrimSpace(l), []byte("#")) {
+			continue
+		}
+		if l[0] == ' ' || l[0] == '\t' {
+			continue // nested content
+		}
+		if !yamlMappingRegexp.Match(l) && !yamlSequenceRegexp.Match(l) {
+			return false
+		}
+		matched++
+	}
+	return matched > 0
+}
+
+func isCSV(lines [][]byte) bool {
+	if len(lines) < 2 {
+		return false
+	}
+	r := csv.NewReader(bytes.NewReader(bytes.Join(lines, []byte("\n"))))
+	records, err := r.ReadAll() // enforces a consistent number of fields
+	if err != nil {
+		return false
+	}
+	return len(records) > 1 && len(records[0]) > 1
+}
@@ -0,0 +1,53 @@
+package run
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestDetectFormat(t *testing.T) {
+	c := qt.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		data     string
+		complete bool
+		want     Format
+	}{
+		{name: "empty", data: "", complete: true, want: FormatText},
+		{name: "text", data: "hello world\nthis is text\n", complete: true, want: FormatText},
+		{name: "json object", data: `{"hello": "world"}`, complete: true, want: FormatJSON},
+		{name: "json array", data: "[\n  1,\n  2\n]\n", complete: true, want: FormatJSON},
+		{name: "truncated json", data: `{"hello": ["wor`, complete: false, want: FormatJSON},
+		{name: "invalid json", data: `{"hello": ["wor`, complete: true, want: FormatText},
+		{name: "ndjson", data: "{\"a\": 1}\n{\"a\": 2}\n", complete: true, want: FormatNDJSON},
+		{name: "truncated ndjson", data: "{\"a\": 1}\n{\"a\": 2}\n{\"a\":", complete: false, want: FormatNDJSON},
+		{name: "yaml document", data: "---\nfoo: bar\n", complete: true, want: FormatYAML},
+		{name: "yaml mapping", data: "foo: bar\nbaz:\n  - 1\n  - 2\n", complete: true, want: FormatYAML},
+		{name: "csv", data: "name,age\nrobert,30\njh,31\n", complete: true, want: FormatCSV},
+		{name: "inconsistent csv", data: "name,age\nrobert\n", complete: true, want: FormatText},
+	} {
+		c.Run(tc.name, func(c *qt.C) {
+			c.Assert(detectFormat([]byte(tc.data), tc.complete), qt.Equals, tc.want)
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	output := Cmd(ctx, "cat").Input(strings.NewReader(`{"hello": "world"}`)).Run()
+
+	format, err := output.Detect()
+	c.Assert(err, qt.IsNil)
+	c.Assert(format, qt.Equals, FormatJSON)
+
+	// Output should not have been consumed
+	res, err := output.JQ(".hello")
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(res), qt.Equals, `"world"`)
+}
@@ -0,0 +1,73 @@
+package run
+
+import (
+	"io"
+	"sync"
+
+	"github.com/djherbis/buffer"
+)
+
+// discardStderrTail is the number of bytes at the end of stderr that are retained for
+// errors from commands configured with Discard.
+const discardStderrTail = 4 * 1024
+
+// Discard configures the command to discard all output, which is the cheapest way to run
+// a command when only its success matters. Stdout and stderr are not collected into
+// Output, and stdout is not read at all - only the last 4KiB of stderr are retained to
+// be included in errors.
+//
+// It replaces any configuration made with StdOut, StdErr, or Attach.
+func (c *Command) Discard() *Command {
+	c.attach = AttachSpec{Stdout: AttachDiscard, Stderr: AttachDiscard}
+	c.stderrTail = discardStderrTail
+	return c
+}
+
+// RunDiscard runs the command with Discard and waits for it to complete.
+func (c *Command) RunDiscard() error {
+	return c.Discard().Run().Wait()
+}
+
+// tailBuffer is a buffer.Buffer that retains only the last size bytes written to it.
+type tailBuffer struct {
+	mux  sync.Mutex
+	size int
+	buf  []byte
+}
+
+var _ buffer.Buffer = &tailBuffer{}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.size; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) Read(p []byte) (int, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if len(t.buf) == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, t.buf)
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+func (t *tailBuffer) Len() int64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return int64(len(t.buf))
+}
+
+func (t *tailBuffer) Cap() int64 { return int64(t.size) }
+
+func (t *tailBuffer) Reset() {
+	t.mux.Lock()
+	t.buf = nil
+	t.mux.Unlock()
+}
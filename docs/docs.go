@@ -0,0 +1,236 @@
+// Package docs provides helpers for executable documentation: extracting fenced code
+// blocks from Markdown, executing them with sourcegraph/run, and injecting their output
+// back into the document.
+package docs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sourcegraph/run"
+)
+
+const (
+	// ExecAttribute marks a fenced code block for execution by Execute when it appears
+	// in the block's info string, for example "```sh exec".
+	ExecAttribute = "exec"
+	// OutputLang is the language of fenced code blocks that contain the output of the
+	// preceding executed block.
+	OutputLang = "output"
+)
+
+// Block is a fenced code block in a Markdown document.
+type Block struct {
+	// Info is the info string following the opening fence, e.g. "go" or "sh exec".
+	Info string
+	// Content is the content of the block, excluding fences.
+	Content string
+
+	// Start and End are the byte offsets of the entire block in the document, including
+	// fences and the trailing newline.
+	Start, End int
+}
+
+// Lang returns the language of the block, which is the first word of its info string.
+func (b Block) Lang() string {
+	if fields := strings.Fields(b.Info); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// HasAttribute indicates if the given word appears in the block's info string after the
+// language.
+func (b Block) HasAttribute(attr string) bool {
+	fields := strings.Fields(b.Info)
+	for i := 1; i < len(fields); i++ {
+		if fields[i] == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractBlocks returns all fenced code blocks in the given Markdown document.
+func ExtractBlocks(doc []byte) []Block {
+	var (
+		blocks []Block
+		open   *Block
+		fence  string
+		offset int
+	)
+	for offset < len(doc) {
+		end := bytes.IndexByte(doc[offset:], '\n')
+		if end < 0 {
+			end = len(doc)
+		} else {
+			end += offset + 1
+		}
+		line := strings.TrimRight(string(doc[offset:end]), "\r\n")
+		trimmed := strings.TrimLeft(line, " ")
+
+		switch {
+		case open == nil && len(line)-len(trimmed) <= 3 &&
+			(strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+			open = &Block{
+				Info:  strings.TrimSpace(trimmed[len(fence):]),
+				Start: offset,
+			}
+
+		case open != nil && strings.HasPrefix(trimmed, fence) &&
+			strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1])) == "":
+			open.End = end
+			blocks = append(blocks, *open)
+			open = nil
+
+		case open != nil:
+			open.Content += line + "\n"
+		}
+
+		offset = end
+	}
+	return blocks
+}
+
+// ReplaceBetween replaces the content between the first occurrence of the start and end
+// anchors in doc with content, retaining the anchors. Anchors are typically HTML
+// comments, e.g. "<!-- START EXAMPLE -->", which are not rendered in Markdown.
+func ReplaceBetween(doc []byte, startAnchor, endAnchor string, content []byte) ([]byte, error) {
+	start := bytes.Index(doc, []byte(startAnchor))
+	if start < 0 {
+		return nil, fmt.Errorf("start anchor %q not found", startAnchor)
+	}
+	start += len(startAnchor)
+	end := bytes.Index(doc[start:], []byte(endAnchor))
+	if end < 0 {
+		return nil, fmt.Errorf("end anchor %q not found", endAnchor)
+	}
+	end += start
+
+	var b bytes.Buffer
+	b.Write(doc[:start])
+	b.Write(content)
+	b.Write(doc[end:])
+	return b.Bytes(), nil
+}
+
+// Execute runs each fenced code block in doc marked with ExecAttribute, e.g.
+// "```sh exec", using run.Bash, and writes the combined output of each block into a
+// fenced code block with the language OutputLang directly following the executed block.
+// Existing output blocks are replaced, and missing output blocks are inserted.
+//
+// Only blocks with the languages "sh", "bash", and "shell" can be executed. Execution
+// stops at the first block that fails.
+func Execute(ctx context.Context, doc []byte) ([]byte, error) {
+	blocks := ExtractBlocks(doc)
+
+	var (
+		result bytes.Buffer
+		offset int
+	)
+	for i, block := range blocks {
+		if !block.HasAttribute(ExecAttribute) {
+			continue
+		}
+		switch block.Lang() {
+		case "sh", "bash", "shell":
+		default:
+			return nil, fmt.Errorf("block at offset %d: unsupported language %q", block.Start, block.Lang())
+		}
+
+		out, err := run.Bash(ctx, block.Content).Run().String()
+		if err != nil {
+			return nil, fmt.Errorf("block at offset %d: %w", block.Start, err)
+		}
+
+		result.Write(doc[offset:block.End])
+		offset = block.End
+
+		// Replace the following output block if there is one, otherwise insert one.
+		if output, ok := outputBlock(doc, blocks, i); ok {
+			result.Write(doc[block.End:output.Start])
+			offset = output.End
+		} else {
+			result.WriteString("\n")
+		}
+		writeBlock(&result, OutputLang, out)
+	}
+	result.Write(doc[offset:])
+
+	return result.Bytes(), nil
+}
+
+// Verify executes doc with Execute, and returns an error if the output of any executed
+// blocks differs from the output recorded in the document - useful for checking that
+// documentation is up to date in CI.
+func Verify(ctx context.Context, doc []byte) error {
+	executed, err := Execute(ctx, doc)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(executed, doc) {
+		return nil
+	}
+
+	// Name the executed blocks with outdated output. Execute does not add or remove
+	// executed blocks, so they can be matched up in order.
+	want, got := executedBlocks(executed), executedBlocks(doc)
+	var outdated []string
+	for i, g := range got {
+		if i >= len(want) || g.hasOutput != want[i].hasOutput ||
+			g.output.Content != want[i].output.Content {
+			outdated = append(outdated, fmt.Sprintf("block at offset %d", g.block.Start))
+		}
+	}
+	if len(outdated) == 0 {
+		return errors.New("outdated output")
+	}
+	return errors.New("outdated output: " + strings.Join(outdated, ", "))
+}
+
+// executedBlock is a block marked with ExecAttribute, and its output block if it has one.
+type executedBlock struct {
+	block     Block
+	output    Block
+	hasOutput bool
+}
+
+func executedBlocks(doc []byte) []executedBlock {
+	blocks := ExtractBlocks(doc)
+	var executed []executedBlock
+	for i, block := range blocks {
+		if !block.HasAttribute(ExecAttribute) {
+			continue
+		}
+		output, ok := outputBlock(doc, blocks, i)
+		executed = append(executed, executedBlock{block: block, output: output, hasOutput: ok})
+	}
+	return executed
+}
+
+// outputBlock returns the output block directly following blocks[i], if there is one.
+func outputBlock(doc []byte, blocks []Block, i int) (Block, bool) {
+	if i+1 < len(blocks) && blocks[i+1].Info == OutputLang &&
+		len(bytes.TrimSpace(doc[blocks[i].End:blocks[i+1].Start])) == 0 {
+		return blocks[i+1], true
+	}
+	return Block{}, false
+}
+
+func writeBlock(b *bytes.Buffer, info, content string) {
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	b.WriteString(fence + info + "\n")
+	b.WriteString(content)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(fence + "\n")
+}
@@ -0,0 +1,96 @@
+package docs_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/sourcegraph/run/docs"
+)
+
+const testDoc = "# Example\n\n" +
+	"```go\nfmt.Println(\"hello\")\n```\n\n" +
+	"```sh exec\necho hello world\n```\n\n" +
+	"Some text\n"
+
+func TestExtractBlocks(t *testing.T) {
+	c := qt.New(t)
+
+	blocks := docs.ExtractBlocks([]byte(testDoc))
+	c.Assert(blocks, qt.HasLen, 2)
+	c.Assert(blocks[0].Lang(), qt.Equals, "go")
+	c.Assert(blocks[0].Content, qt.Equals, "fmt.Println(\"hello\")\n")
+	c.Assert(blocks[1].Lang(), qt.Equals, "sh")
+	c.Assert(blocks[1].HasAttribute(docs.ExecAttribute), qt.IsTrue)
+	c.Assert(testDoc[blocks[1].Start:blocks[1].End], qt.Equals, "```sh exec\necho hello world\n```\n")
+}
+
+func TestExecute(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	const want = "# Example\n\n" +
+		"```go\nfmt.Println(\"hello\")\n```\n\n" +
+		"```sh exec\necho hello world\n```\n\n" +
+		"```output\nhello world\n```\n\n" +
+		"Some text\n"
+
+	executed, err := docs.Execute(ctx, []byte(testDoc))
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(executed), qt.Equals, want)
+
+	// Executing again should replace the existing output, and be verifiable.
+	executed, err = docs.Execute(ctx, executed)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(executed), qt.Equals, want)
+	c.Assert(docs.Verify(ctx, executed), qt.IsNil)
+	c.Assert(docs.Verify(ctx, []byte(testDoc)), qt.IsNotNil)
+}
+
+func TestReplaceBetween(t *testing.T) {
+	c := qt.New(t)
+
+	replaced, err := docs.ReplaceBetween([]byte("a <!-- START -->old<!-- END --> b"),
+		"<!-- START -->", "<!-- END -->", []byte("new"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(replaced), qt.Equals, "a <!-- START -->new<!-- END --> b")
+}
+
+func TestVerify(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	const upToDate = "```sh exec\necho one\n```\n\n" +
+		"```output\none\n```\n\n" +
+		"```sh exec\necho two\n```\n\n" +
+		"```output\ntwo\n```\n"
+	c.Assert(docs.Verify(ctx, []byte(upToDate)), qt.IsNil)
+
+	c.Run("missing output", func(c *qt.C) {
+		// Only the first block is outdated, even though its output block being inserted
+		// shifts all later blocks.
+		const doc = "```sh exec\necho one\n```\n\n" +
+			"```sh exec\necho two\n```\n\n" +
+			"```output\ntwo\n```\n"
+		err := docs.Verify(ctx, []byte(doc))
+		c.Assert(err, qt.ErrorMatches, "outdated output: block at offset 0")
+	})
+
+	c.Run("changed output", func(c *qt.C) {
+		const doc = "```sh exec\necho one\n```\n\n" +
+			"```output\none\n```\n\n" +
+			"```sh exec\necho two\n```\n\n" +
+			"```output\nthree\n```\n"
+		err := docs.Verify(ctx, []byte(doc))
+		c.Assert(err, qt.ErrorMatches, "outdated output: block at offset 44")
+	})
+
+	c.Run("stale output", func(c *qt.C) {
+		const doc = "```sh exec\necho one\n```\n\n" +
+			"Some text\n\n" +
+			"```output\none\n```\n"
+		err := docs.Verify(ctx, []byte(doc))
+		c.Assert(err, qt.ErrorMatches, "outdated output: block at offset 0")
+	})
+}
@@ -0,0 +1,20 @@
+package run
+
+import "context"
+
+const contextKeyDryRun contextKey = "dryRun"
+
+// DryRun configures all commands executed by sourcegraph/run within this context to not
+// be executed. Instead, commands are logged and recorded as usual, and Output provides
+// the shell-quoted command line that would have been executed, including environment
+// variables that are not inherited and the directory, e.g. to implement '--dry-run'
+// flags.
+func DryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyDryRun, true)
+}
+
+// isDryRun returns true if commands should not be executed.
+func isDryRun(ctx context.Context) bool {
+	v, _ := ctx.Value(contextKeyDryRun).(bool)
+	return v
+}
@@ -0,0 +1,213 @@
+package run
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EncryptionKeySize is the size of keys used with NewEncryptWriter and NewDecryptReader.
+const EncryptionKeySize = 32
+
+const (
+	encryptMagic      = "run-encrypted-v1\n"
+	encryptNonceSize  = 16
+	encryptChunkSize  = 64 * 1024
+	encryptLengthSize = 4
+)
+
+// ErrDecrypt is returned when encrypted content cannot be decrypted, for example because
+// the key is incorrect or the content has been modified or truncated.
+var ErrDecrypt = errors.New("failed to decrypt content")
+
+// NewEncryptionKey generates a random key for use with NewEncryptWriter.
+func NewEncryptionKey() ([]byte, error) {
+	key := make([]byte, EncryptionKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewEncryptWriter creates a writer that encrypts everything written to it with
+// AES-256-GCM using key before writing it to dst, for example to capture output that
+// contains sensitive data in support bundles:
+//
+//	sink, err := run.NewEncryptWriter(file, key)
+//	err = run.Cmd(ctx, "my-command").Run().Map(redact).Stream(sink)
+//	err = sink.Close()
+//
+// Content is encrypted in chunks so that it can be streamed. The writer must be closed
+// to write the final chunk, without which the content cannot be decrypted. Use
+// NewDecryptReader to decrypt the content.
+func NewEncryptWriter(dst io.Writer, key []byte) (io.WriteCloser, error) {
+	nonce := make([]byte, encryptNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	aead, err := newEncryptAEAD(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(dst, encryptMagic); err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(nonce); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{dst: dst, aead: aead}, nil
+}
+
+// NewDecryptReader creates a reader that decrypts content from src that was encrypted
+// with NewEncryptWriter using key. If decryption fails, reads return ErrDecrypt.
+func NewDecryptReader(src io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, len(encryptMagic)+encryptNonceSize)
+	if _, err := io.ReadFull(src, header); err != nil || string(header[:len(encryptMagic)]) != encryptMagic {
+		return nil, fmt.Errorf("%w: invalid header", ErrDecrypt)
+	}
+	aead, err := newEncryptAEAD(key, header[len(encryptMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{src: src, aead: aead}, nil
+}
+
+// newEncryptAEAD derives a key for a single encrypted stream from key and nonce.
+func newEncryptAEAD(key, nonce []byte) (cipher.AEAD, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("invalid key size %d, expected %d", len(key), EncryptionKeySize)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for the chunk at counter. The final chunk uses a distinct
+// nonce so that truncation can be detected.
+func chunkNonce(aead cipher.AEAD, counter uint64, final bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(w.buf) == encryptChunkSize {
+			// Full chunks are only written once more content is available, since the
+			// last chunk must be marked as final.
+			if err := w.writeChunk(false); err != nil {
+				return written, err
+			}
+		}
+		n := encryptChunkSize - len(w.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) writeChunk(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.aead, w.counter, final), w.buf, nil)
+	w.counter++
+	w.buf = w.buf[:0]
+
+	var length [encryptLengthSize]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := w.dst.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.dst.Write(sealed)
+	return err
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.writeChunk(true)
+}
+
+type decryptReader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	final   bool
+	err     error
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.final {
+			r.err = io.EOF
+			continue
+		}
+		r.err = r.readChunk()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *decryptReader) readChunk() error {
+	var length [encryptLengthSize]byte
+	if _, err := io.ReadFull(r.src, length[:]); err != nil {
+		// Content ended before the final chunk.
+		return fmt.Errorf("%w: content is truncated", ErrDecrypt)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > encryptChunkSize+uint32(r.aead.Overhead()) {
+		return fmt.Errorf("%w: invalid chunk size", ErrDecrypt)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return fmt.Errorf("%w: content is truncated", ErrDecrypt)
+	}
+
+	// Try the chunk as a regular chunk first, and then as the final chunk.
+	plain, err := r.aead.Open(nil, chunkNonce(r.aead, r.counter, false), sealed, nil)
+	if err != nil {
+		plain, err = r.aead.Open(nil, chunkNonce(r.aead, r.counter, true), sealed, nil)
+		if err != nil {
+			return ErrDecrypt
+		}
+		r.final = true
+	}
+	r.counter++
+	r.buf = plain
+	return nil
+}
@@ -0,0 +1,79 @@
+package run_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/sourcegraph/run"
+)
+
+func TestEncrypt(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	key, err := run.NewEncryptionKey()
+	c.Assert(err, qt.IsNil)
+
+	encrypt := func(c *qt.C, content string) []byte {
+		var encrypted bytes.Buffer
+		sink, err := run.NewEncryptWriter(&encrypted, key)
+		c.Assert(err, qt.IsNil)
+		err = run.Cmd(ctx, "cat").Input(strings.NewReader(content)).Run().Stream(sink)
+		c.Assert(err, qt.IsNil)
+		c.Assert(sink.Close(), qt.IsNil)
+		return encrypted.Bytes()
+	}
+
+	decrypt := func(encrypted []byte, key []byte) (string, error) {
+		src, err := run.NewDecryptReader(bytes.NewReader(encrypted), key)
+		if err != nil {
+			return "", err
+		}
+		b, err := io.ReadAll(src)
+		return string(b), err
+	}
+
+	c.Run("round trip", func(c *qt.C) {
+		for _, content := range []string{
+			"",
+			"secret output\n",
+			strings.Repeat("large secret output\n", 10000),
+		} {
+			encrypted := encrypt(c, content)
+			c.Assert(bytes.Contains(encrypted, []byte("secret")), qt.IsFalse)
+
+			decrypted, err := decrypt(encrypted, key)
+			c.Assert(err, qt.IsNil)
+			c.Assert(decrypted, qt.Equals, content)
+		}
+	})
+
+	c.Run("wrong key", func(c *qt.C) {
+		otherKey, err := run.NewEncryptionKey()
+		c.Assert(err, qt.IsNil)
+
+		_, err = decrypt(encrypt(c, "secret output\n"), otherKey)
+		c.Assert(errors.Is(err, run.ErrDecrypt), qt.IsTrue)
+	})
+
+	c.Run("tampered", func(c *qt.C) {
+		encrypted := encrypt(c, "secret output\n")
+		encrypted[len(encrypted)-1] ^= 1
+
+		_, err := decrypt(encrypted, key)
+		c.Assert(errors.Is(err, run.ErrDecrypt), qt.IsTrue)
+	})
+
+	c.Run("truncated", func(c *qt.C) {
+		encrypted := encrypt(c, strings.Repeat("large secret output\n", 10000))
+
+		// Drop the final chunk
+		_, err := decrypt(encrypted[:len(encrypted)-200], key)
+		c.Assert(errors.Is(err, run.ErrDecrypt), qt.IsTrue)
+	})
+}
@@ -0,0 +1,294 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const contextKeyWithoutInheritedEnv contextKey = "withoutInheritedEnv"
+
+// WithoutInheritedEnv configures all commands executed by sourcegraph/run within this
+// context to not inherit the environment of the current process by default, so that
+// commands only receive environment variables that are explicitly set with Env or
+// Environ. Commands can opt back in with InheritEnv.
+func WithoutInheritedEnv(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyWithoutInheritedEnv, true)
+}
+
+// isWithoutInheritedEnv indicates if WithoutInheritedEnv is configured in ctx.
+func isWithoutInheritedEnv(ctx context.Context) bool {
+	v, _ := ctx.Value(contextKeyWithoutInheritedEnv).(bool)
+	return v
+}
+
+// InheritEnv configures whether the command inherits the environment of the current
+// process, in addition to environment variables set with Env or Environ, which take
+// precedence. By default, commands inherit the environment unless the command's context
+// is configured with WithoutInheritedEnv.
+func (c *Command) InheritEnv(inherit bool) *Command {
+	c.inheritEnv = &inherit
+	return c
+}
+
+// hermeticEnvKeys are the environment variables passed through from the current process
+// by Hermetic, if they are set.
+var hermeticEnvKeys = []string{"PATH", "HOME", "SOURCE_DATE_EPOCH"}
+
+// hermeticLocale is the locale set by Hermetic.
+const hermeticLocale = "C.UTF-8"
+
+// Hermetic strips the environment of the command down to a minimal, deterministic set
+// of variables for reproducible build steps: PATH, HOME, and SOURCE_DATE_EPOCH are
+// passed through from the current process if they are set, along with the given allow
+// list of variables, and LANG is set to C.UTF-8. Environment variables that are already
+// set on the command are kept, and variables can be set with Env or Environ afterwards.
+func (c *Command) Hermetic(allow ...string) *Command {
+	c.InheritEnv(false)
+	set := func(k, v string) {
+		for _, kv := range c.environ {
+			if strings.HasPrefix(kv, k+"=") {
+				return // already set on the command
+			}
+		}
+		c.setenv(k, k+"="+v)
+	}
+	for _, k := range append(append([]string(nil), hermeticEnvKeys...), allow...) {
+		if v, ok := os.LookupEnv(k); ok {
+			set(k, v)
+		}
+	}
+	set("LANG", hermeticLocale)
+	return c
+}
+
+// inheritsEnv indicates if the command inherits the environment of the current process.
+func (c *Command) inheritsEnv() bool {
+	if c.inheritEnv != nil {
+		return *c.inheritEnv
+	}
+	return c.ctx == nil || !isWithoutInheritedEnv(c.ctx)
+}
+
+// commandEnv returns the environment a command is started with, given the environment
+// variables set on it and the variables removed from the inherited environment.
+func commandEnv(environ, unset []string, inherit bool) []string {
+	if !inherit {
+		// A nil environment makes exec.Cmd use the current process's environment.
+		return append([]string{}, environ...)
+	}
+	if environ == nil && len(unset) == 0 {
+		return nil
+	}
+	return withoutEnvKeys(append(os.Environ(), environ...), unset)
+}
+
+// withoutEnvKeys returns the entries of environ that do not set any of keys.
+func withoutEnvKeys(environ []string, keys []string) []string {
+	if len(keys) == 0 {
+		return environ
+	}
+	filtered := environ[:0:0]
+environ:
+	for _, kv := range environ {
+		k, _, _ := strings.Cut(kv, "=")
+		for _, key := range keys {
+			if k == key {
+				continue environ
+			}
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
+
+// withoutStrings returns the values of s that are not v.
+func withoutStrings(s []string, v string) []string {
+	filtered := s[:0:0]
+	for _, e := range s {
+		if e != v {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// EnvFile adds the environment variables in the dotenv-format file at path to the
+// command, as if they were set with Environ - see ParseEnvFile for the supported format.
+// Variables in the file take precedence over variables set by earlier calls, so multiple
+// files can be layered. If the file cannot be read or parsed, running the command
+// returns the error.
+func (c *Command) EnvFile(path string) *Command {
+	f, err := os.Open(path)
+	if err != nil {
+		return c.setBuildError(fmt.Errorf("failed to read env file: %w", err))
+	}
+	defer f.Close()
+
+	environ, err := ParseEnvFile(f)
+	if err != nil {
+		return c.setBuildError(fmt.Errorf("failed to parse env file %s: %w", path, err))
+	}
+	return c.Environ(environ)
+}
+
+// setBuildError records err as an error building the command, unless an error has
+// already been recorded.
+func (c *Command) setBuildError(err error) *Command {
+	if c.buildError == nil {
+		c.buildError = err
+	}
+	return c
+}
+
+// ParseEnvFile parses dotenv-format content into strings representing the environment
+// (key=value), which can be provided to Environ. Each line has the format KEY=VALUE, and
+// may be prefixed with 'export'. Blank lines and lines starting with '#' are ignored.
+//
+// Values may be single-quoted, in which case they are used as-is, or double-quoted, in
+// which case the escape sequences \n, \t, \", and \\ are interpreted. Unquoted values
+// are trimmed, and comments starting with ' #' are removed.
+func ParseEnvFile(r io.Reader) ([]string, error) {
+	var environ []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || strings.ContainsAny(k, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		v, err := parseEnvValue(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		environ = append(environ, k+"="+v)
+	}
+	return environ, scanner.Err()
+}
+
+// parseEnvValue parses a value in a dotenv-format file.
+func parseEnvValue(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	switch quote := v[0]; quote {
+	case '\'', '"':
+		end := strings.IndexByte(v[1:], quote) + 1
+		if quote == '"' {
+			// Skip escaped quotes.
+			for end > 0 && isEscaped(v, end) {
+				next := strings.IndexByte(v[end+1:], quote)
+				if next < 0 {
+					end = 0
+					break
+				}
+				end += next + 1
+			}
+		}
+		if end <= 0 {
+			return "", errors.New("unterminated quoted value")
+		}
+		if rest := strings.TrimSpace(v[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", errors.New("unexpected content after quoted value")
+		}
+		if quote == '\'' {
+			return v[1:end], nil
+		}
+		return envEscapes.Replace(v[1:end]), nil
+	default:
+		if i := strings.Index(v, " #"); i >= 0 {
+			v = v[:i]
+		}
+		return strings.TrimSpace(v), nil
+	}
+}
+
+// isEscaped indicates if the byte at i in s is preceded by an odd number of backslashes.
+func isEscaped(s string, i int) bool {
+	backslashes := 0
+	for j := i - 1; j >= 0 && s[j] == '\\'; j-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// envEscapes interprets escape sequences in double-quoted values.
+var envEscapes = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`)
+
+// ExpandEnv configures the command to expand references to environment variables in its
+// arguments of the form '${ENVIRONMENT}' when it is run. Other forms, such as '$ENVIRONMENT',
+// '$1', or '$$', are left as-is so that arguments such as awk programs are not affected.
+// Variables are expanded using the environment the command is run with, including
+// variables set after ExpandEnv is called, rather than the environment of the current
+// process. Referring to a variable that is not set is an error.
+func (c *Command) ExpandEnv() *Command {
+	c.expandEnv = true
+	return c
+}
+
+// expandArgs expands references of the form '${NAME}' to environment variables in args
+// with environ, or the current process's environment if environ is nil. It returns an
+// error if a referenced variable is not set.
+func expandArgs(args []string, environ []string) ([]string, error) {
+	if environ == nil {
+		environ = os.Environ()
+	}
+	values := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		values[k] = v
+	}
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		var b strings.Builder
+		for {
+			start := strings.Index(arg, "${")
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(arg[start:], '}')
+			if end < 0 {
+				break
+			}
+			name := arg[start+2 : start+end]
+			if !isEnvName(name) {
+				b.WriteString(arg[:start+2])
+				arg = arg[start+2:]
+				continue
+			}
+			value, ok := values[name]
+			if !ok {
+				return nil, fmt.Errorf("ExpandEnv: environment variable %q is not set", name)
+			}
+			b.WriteString(arg[:start])
+			b.WriteString(value)
+			arg = arg[start+end+1:]
+		}
+		b.WriteString(arg)
+		expanded[i] = b.String()
+	}
+	return expanded, nil
+}
+
+// isEnvName indicates if name is a valid environment variable name for expandArgs.
+func isEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
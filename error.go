@@ -2,19 +2,77 @@ package run
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"syscall"
 )
 
+// ErrConcurrentConsumption is returned by functions on Output that consume output when
+// output is already being consumed by another goroutine. Output should only be consumed
+// by one caller at a time.
+var ErrConcurrentConsumption = errors.New("output is already being consumed")
+
+// ErrAlreadyConsumed is returned by Output.Wait when the command's result has already
+// been consumed, for example because output has been consumed with String.
+var ErrAlreadyConsumed = errors.New("output has already been consumed")
+
+var (
+	// ErrCanceled indicates a command was terminated because its context was canceled.
+	// Errors that match ErrCanceled also match context.Canceled.
+	ErrCanceled error = &causeError{msg: "command canceled", is: context.Canceled}
+	// ErrTimeout indicates a command was terminated because its context deadline was
+	// exceeded, or because of a timeout configured with Command.Timeout or
+	// Command.Deadline. Errors that match ErrTimeout also match context.DeadlineExceeded.
+	ErrTimeout error = &causeError{msg: "command timed out", is: context.DeadlineExceeded}
+	// ErrClosed indicates a command was terminated because its Output was closed.
+	ErrClosed error = &causeError{msg: "command output closed"}
+)
+
+// causeError denotes the reason a command was terminated.
+type causeError struct {
+	msg string
+	// is is an additional error this cause should match.
+	is error
+}
+
+func (e *causeError) Error() string { return e.msg }
+
+func (e *causeError) Is(target error) bool { return e.is != nil && target == e.is }
+
+// startError indicates a command failed to start.
+type startError struct{ err error }
+
+func (e *startError) Error() string { return "failed to start command: " + e.err.Error() }
+
+func (e *startError) Unwrap() error { return e.err }
+
+// SignalCauser is an error that also denotes the signal that terminated a command. Users
+// of Output can check if an error implements this interface to get the underlying signal
+// that terminated a command execution, if any.
+type SignalCauser interface {
+	error
+	CauseSignal() os.Signal
+}
+
 // runError wraps exec.ExitError such that it always includes the embedded stderr.
-type runError struct{ execErr *exec.ExitError }
+type runError struct {
+	execErr *exec.ExitError
+	// cause, if set, is the reason the command was terminated, e.g. ErrCanceled.
+	cause error
+	// oomKilled indicates the command was likely killed by the out-of-memory killer.
+	oomKilled bool
+}
 
 var _ ExitCoder = &runError{}
+var _ SignalCauser = &runError{}
 
-// newError creats a new *Error, and can be provided a nil error and/or nil stdErr
-func newError(err error, stdErr io.Reader) error {
+// newError creats a new *Error, and can be provided a nil error, nil stdErr, and/or a nil
+// cause.
+func newError(err error, stdErr io.Reader, cause error) error {
 	if err == nil {
 		return nil
 	}
@@ -28,19 +86,70 @@ func newError(err error, stdErr io.Reader) error {
 				exitErr.Stderr = bytes.TrimSpace(b)
 			}
 		}
-		return &runError{execErr: exitErr}
+		return &runError{execErr: exitErr, cause: cause}
 	}
 
 	return err
 }
 
+// terminationCause returns the reason a command was terminated, if any, based on the
+// command's context and whether the command's output was closed.
+func terminationCause(ctx context.Context, closed bool) error {
+	switch {
+	case closed:
+		return ErrClosed
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrTimeout
+	case ctx.Err() != nil:
+		return ErrCanceled
+	default:
+		return nil
+	}
+}
+
 func (e *runError) Error() string {
+	msg := e.execErr.String()
+	if e.oomKilled {
+		msg = "likely killed by out-of-memory killer: " + msg
+	}
+	if e.cause != nil {
+		msg = fmt.Sprintf("%s: %s", e.cause.Error(), msg)
+	}
 	if len(e.execErr.Stderr) == 0 {
-		return e.execErr.String()
+		return msg
 	}
-	return fmt.Sprintf("%s: %s", e.execErr.String(), string(e.execErr.Stderr))
+	return fmt.Sprintf("%s: %s", msg, string(e.execErr.Stderr))
+}
+
+func (e *runError) Unwrap() error {
+	return e.cause
 }
 
 func (e *runError) ExitCode() int {
 	return e.execErr.ExitCode()
 }
+
+// CauseSignal returns the signal that terminated the command, or nil if the command was
+// not terminated by a signal.
+func (e *runError) CauseSignal() os.Signal {
+	status, ok := e.execErr.Sys().(interface {
+		Signaled() bool
+		Signal() syscall.Signal
+	})
+	if !ok || !status.Signaled() {
+		return nil
+	}
+	return status.Signal()
+}
+
+// CoreDumped indicates if the command was terminated by a signal and dumped core.
+func (e *runError) CoreDumped() bool {
+	status, ok := e.execErr.Sys().(interface {
+		Signaled() bool
+		CoreDump() bool
+	})
+	return ok && status.Signaled() && status.CoreDump()
+}
+
+// OOMKilled indicates if the command was likely killed by the out-of-memory killer.
+func (e *runError) OOMKilled() bool { return e.oomKilled }
@@ -1,5 +1,12 @@
 package run
 
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
 // ExitCoder is an error that also denotes an exit code to exit with. Users of Output can
 // check if an error implements this interface to get the underlying exit code of a
 // command execution.
@@ -24,9 +31,133 @@ func ExitCode(err error) int {
 		return 0
 	}
 
-	if exitCoder, ok := err.(ExitCoder); ok {
+	var exitCoder ExitCoder
+	if errors.As(err, &exitCoder) {
 		return exitCoder.ExitCode()
 	}
 
 	return 1
 }
+
+// ExitSignal returns the signal that terminated the command that produced err, if the
+// command was terminated by a signal. This distinguishes, for example, commands killed
+// with SIGKILL, such as by an out-of-memory killer, from commands that crashed with
+// SIGSEGV, which ExitCode reports identically as -1.
+//
+// Note that commands run by a shell may instead exit with code 128+n when a command run
+// by the shell is terminated by signal n - see ShellSignalErrors.
+func ExitSignal(err error) (os.Signal, bool) {
+	var signalCauser SignalCauser
+	if !errors.As(err, &signalCauser) {
+		return nil, false
+	}
+	signal := signalCauser.CauseSignal()
+	return signal, signal != nil
+}
+
+// CoreDumped indicates if the command that produced err was terminated by a signal and
+// dumped core. Whether a core dump is produced depends on the system configuration, e.g.
+// 'ulimit -c'.
+func CoreDumped(err error) bool {
+	var coreDumper interface{ CoreDumped() bool }
+	return errors.As(err, &coreDumper) && coreDumper.CoreDumped()
+}
+
+// ErrUsage can be used with MapExitCodes to denote that a command was invoked
+// incorrectly, which many commands indicate with exit code 2.
+var ErrUsage = errors.New("usage error")
+
+// MapExitCodes configures the command to convert the given exit codes into the given
+// errors, so that errors from the command can be handled with errors.Is and errors.As.
+// The original error is retained, so ExitCode and the command's stderr remain available.
+// Multiple calls merge the given mappings.
+//
+// For example, to handle a usage error:
+//
+//	err := run.Cmd(ctx, "mytool").MapExitCodes(map[int]error{2: run.ErrUsage}).Run().Wait()
+//	if errors.Is(err, run.ErrUsage) { ... }
+func (c *Command) MapExitCodes(codes map[int]error) *Command {
+	if c.exitCodes == nil {
+		c.exitCodes = make(map[int]error, len(codes))
+	}
+	for code, err := range codes {
+		c.exitCodes[code] = err
+	}
+	return c
+}
+
+// ShellSignalErrors returns a mapping for MapExitCodes that converts the exit codes shells
+// report for commands terminated by a signal, 128+n where n is the signal number, into
+// a *ShellSignalError for the signal. This is useful for commands run by a shell, e.g.
+// Bash, so that ExitSignal and OOMKilled also work for commands run by the shell.
+func ShellSignalErrors() map[int]error {
+	codes := make(map[int]error, 31)
+	for n := 1; n <= 31; n++ {
+		codes[128+n] = &ShellSignalError{Signal: syscall.Signal(n)}
+	}
+	return codes
+}
+
+// ShellSignalError indicates that a command run by a shell was terminated by Signal, as
+// reported by the shell with exit code 128+n where n is the signal number. It is
+// returned for errors mapped with ShellSignalErrors.
+type ShellSignalError struct {
+	Signal syscall.Signal
+}
+
+var _ SignalCauser = &ShellSignalError{}
+
+func (e *ShellSignalError) Error() string {
+	return fmt.Sprintf("terminated by signal: %s", e.Signal)
+}
+
+// CauseSignal returns Signal.
+func (e *ShellSignalError) CauseSignal() os.Signal { return e.Signal }
+
+// mapExitCode converts err into the error mapped to its exit code in codes, if any.
+func mapExitCode(err error, codes map[int]error) error {
+	var exitCoder ExitCoder
+	if len(codes) == 0 || !errors.As(err, &exitCoder) {
+		return err
+	}
+	if mapped, ok := codes[exitCoder.ExitCode()]; ok && mapped != nil {
+		return &mappedExitError{mapped: mapped, err: exitCoder}
+	}
+	return err
+}
+
+// mappedExitError is a command error that has been converted into another error with
+// MapExitCodes. It matches both the mapped and original errors.
+type mappedExitError struct {
+	mapped error
+	err    ExitCoder
+}
+
+var _ ExitCoder = &mappedExitError{}
+var _ SignalCauser = &mappedExitError{}
+
+func (e *mappedExitError) Error() string { return fmt.Sprintf("%s: %s", e.mapped, e.err) }
+
+func (e *mappedExitError) Unwrap() error { return e.err }
+
+func (e *mappedExitError) Is(target error) bool { return errors.Is(e.mapped, target) }
+
+func (e *mappedExitError) As(target interface{}) bool { return errors.As(e.mapped, target) }
+
+func (e *mappedExitError) ExitCode() int { return e.err.ExitCode() }
+
+// CauseSignal returns the signal that terminated the command, or the signal denoted by
+// the mapped error, e.g. a *ShellSignalError.
+func (e *mappedExitError) CauseSignal() os.Signal {
+	if signal, ok := ExitSignal(e.err); ok {
+		return signal
+	}
+	if signal, ok := ExitSignal(e.mapped); ok {
+		return signal
+	}
+	return nil
+}
+
+func (e *mappedExitError) CoreDumped() bool { return CoreDumped(e.err) }
+
+func (e *mappedExitError) OOMKilled() bool { return OOMKilled(e.err) }
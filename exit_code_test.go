@@ -2,8 +2,14 @@ package run_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"regexp"
+	"syscall"
+	"testing"
 
+	qt "github.com/frankban/quicktest"
 	"github.com/sourcegraph/run"
 )
 
@@ -24,3 +30,171 @@ func ExampleExitCode() {
 	// 0
 	// 1
 }
+
+func TestExitSignal(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("signaled", func(c *qt.C) {
+		err := run.Cmd(ctx, "sh -c", run.Arg("kill -TERM $$")).Run().Wait()
+		signal, ok := run.ExitSignal(err)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(signal, qt.Equals, syscall.SIGTERM)
+		c.Assert(run.CoreDumped(err), qt.IsFalse)
+	})
+
+	c.Run("exited", func(c *qt.C) {
+		err := run.Bash(ctx, "exit 137").Run().Wait()
+		_, ok := run.ExitSignal(err)
+		c.Assert(ok, qt.IsFalse)
+		c.Assert(run.CoreDumped(err), qt.IsFalse)
+
+		_, ok = run.ExitSignal(nil)
+		c.Assert(ok, qt.IsFalse)
+	})
+}
+
+type notFoundError struct{ name string }
+
+func (e *notFoundError) Error() string { return e.name + " not found" }
+
+func TestMapExitCodes(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("mapped", func(c *qt.C) {
+		err := run.Bash(ctx, "echo 'bad flag' >&2; exit 2").
+			MapExitCodes(map[int]error{2: run.ErrUsage}).
+			Run().Wait()
+		c.Assert(errors.Is(err, run.ErrUsage), qt.IsTrue)
+		c.Assert(run.ExitCode(err), qt.Equals, 2)
+		c.Assert(err, qt.ErrorMatches, "usage error: exit status 2: bad flag")
+	})
+
+	c.Run("typed", func(c *qt.C) {
+		err := run.Bash(ctx, "exit 3").
+			MapExitCodes(map[int]error{3: &notFoundError{name: "widget"}}).
+			Run().Wait()
+		var notFound *notFoundError
+		c.Assert(errors.As(err, &notFound), qt.IsTrue)
+		c.Assert(notFound.name, qt.Equals, "widget")
+	})
+
+	c.Run("unmapped", func(c *qt.C) {
+		err := run.Bash(ctx, "exit 1").
+			MapExitCodes(map[int]error{2: run.ErrUsage}).
+			Run().Wait()
+		c.Assert(errors.Is(err, run.ErrUsage), qt.IsFalse)
+		c.Assert(run.ExitCode(err), qt.Equals, 1)
+	})
+
+	c.Run("shell signals", func(c *qt.C) {
+		err := run.Bash(ctx, "exit 137").
+			MapExitCodes(run.ShellSignalErrors()).
+			Run().Wait()
+		c.Assert(err, qt.ErrorMatches, "terminated by signal: killed: exit status 137")
+
+		var signalErr *run.ShellSignalError
+		c.Assert(errors.As(err, &signalErr), qt.IsTrue)
+		c.Assert(signalErr.Signal, qt.Equals, syscall.SIGKILL)
+
+		signal, ok := run.ExitSignal(err)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(signal, qt.Equals, os.Signal(syscall.SIGKILL))
+		c.Assert(run.ExitCode(err), qt.Equals, 137)
+	})
+}
+
+func TestClassifyErrors(t *testing.T) {
+	c := qt.New(t)
+	ctx := run.ClassifyErrors(context.Background(), []run.ErrorRule{{
+		Pattern:  regexp.MustCompile(`Permission denied \(publickey\)`),
+		Category: "auth",
+		Hint:     "add your SSH key to the remote",
+	}, {
+		Pattern:  regexp.MustCompile(`Could not resolve host`),
+		Category: "network",
+	}})
+	ctx = run.ClassifyErrors(ctx, []run.ErrorRule{{
+		Pattern:  regexp.MustCompile(`Could not resolve`),
+		Category: "dns",
+	}})
+
+	c.Run("matched", func(c *qt.C) {
+		err := run.Bash(ctx, "echo 'Permission denied (publickey).' >&2; exit 128").Run().Wait()
+		rule, ok := run.ErrorClassification(err)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(rule.Category, qt.Equals, "auth")
+		c.Assert(rule.Hint, qt.Equals, "add your SSH key to the remote")
+		c.Assert(run.ExitCode(err), qt.Equals, 128)
+		c.Assert(err, qt.ErrorMatches, ".*Permission denied \\(publickey\\).")
+	})
+
+	c.Run("inner rules first", func(c *qt.C) {
+		err := run.Bash(ctx, "echo 'Could not resolve host: example.com' >&2; exit 1").Run().Wait()
+		rule, ok := run.ErrorClassification(err)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(rule.Category, qt.Equals, "dns")
+	})
+
+	c.Run("with mapped exit codes", func(c *qt.C) {
+		err := run.Bash(ctx, "echo 'Permission denied (publickey).' >&2; exit 2").
+			MapExitCodes(map[int]error{2: run.ErrUsage}).
+			Run().Wait()
+		c.Assert(err, qt.ErrorIs, run.ErrUsage)
+		rule, ok := run.ErrorClassification(err)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(rule.Category, qt.Equals, "auth")
+	})
+
+	c.Run("unmatched", func(c *qt.C) {
+		err := run.Bash(ctx, "echo 'something else' >&2; exit 1").Run().Wait()
+		c.Assert(err, qt.IsNotNil)
+		_, ok := run.ErrorClassification(err)
+		c.Assert(ok, qt.IsFalse)
+	})
+}
+
+func TestRenderErrors(t *testing.T) {
+	c := qt.New(t)
+	ctx := run.ClassifyErrors(context.Background(), []run.ErrorRule{{
+		Pattern: regexp.MustCompile(`not a git repository`),
+		Hint:    "run this command in a git repository",
+	}})
+	ctx = run.RenderErrors(ctx, func(cmd run.ExecutedCommand, err error) error {
+		if rule, ok := run.ErrorClassification(err); ok {
+			return fmt.Errorf("%s failed, %s: %w", cmd.Args[0], rule.Hint, err)
+		}
+		return fmt.Errorf("%s failed: %w", cmd.Args[0], err)
+	})
+
+	c.Run("exit error", func(c *qt.C) {
+		err := run.Cmd(ctx, "sh -c", run.Arg("echo 'fatal: not a git repository' >&2; exit 128")).Run().Wait()
+		c.Assert(err, qt.ErrorMatches, "sh failed, run this command in a git repository: exit status 128: fatal: not a git repository")
+		c.Assert(errors.As(err, new(run.ExitCoder)), qt.IsTrue)
+		c.Assert(run.ExitCode(err), qt.Equals, 128)
+		_, ok := run.ErrorClassification(err)
+		c.Assert(ok, qt.IsTrue)
+	})
+
+	c.Run("exit code is preserved", func(c *qt.C) {
+		err := run.Bash(ctx, "exit 3").Run().Wait()
+		c.Assert(run.ExitCode(err), qt.Equals, 3)
+	})
+
+	c.Run("exit codes are mapped before rendering", func(c *qt.C) {
+		errMapped := errors.New("mapped")
+		err := run.Bash(ctx, "exit 3").MapExitCodes(map[int]error{3: errMapped}).Run().Wait()
+		c.Assert(err, qt.ErrorIs, errMapped)
+		c.Assert(run.ExitCode(err), qt.Equals, 3)
+	})
+
+	c.Run("start error", func(c *qt.C) {
+		err := run.Cmd(ctx, "non-existing-binary").Run().Wait()
+		c.Assert(err, qt.ErrorMatches, "non-existing-binary failed: failed to start command: .*")
+	})
+
+	c.Run("success", func(c *qt.C) {
+		c.Assert(run.Cmd(ctx, "true").Run().Wait(), qt.IsNil)
+	})
+}
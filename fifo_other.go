@@ -0,0 +1,24 @@
+//go:build !windows
+
+package run
+
+import (
+	"os"
+	"syscall"
+)
+
+func mkfifo(path string, mode uint32) error {
+	return syscall.Mkfifo(path, mode)
+}
+
+// unblockFIFO unblocks a pending open of the FIFO at path by opening the other end of
+// the FIFO.
+func unblockFIFO(path string, write bool) {
+	flag := os.O_WRONLY
+	if write {
+		flag = os.O_RDONLY
+	}
+	if f, err := os.OpenFile(path, flag|syscall.O_NONBLOCK, 0); err == nil {
+		f.Close()
+	}
+}
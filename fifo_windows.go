@@ -0,0 +1,11 @@
+//go:build windows
+
+package run
+
+import "errors"
+
+func mkfifo(path string, mode uint32) error {
+	return errors.New("FIFOs are not supported on Windows")
+}
+
+func unblockFIFO(path string, write bool) {}
@@ -0,0 +1,139 @@
+package run
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FingerprintEnv configures the environment variables that participate in the command's
+// Fingerprint. Values are taken from the command's environment set with Env or Environ,
+// and from the current process's environment if it is inherited.
+//
+// By default, only environment variables explicitly set on the command participate.
+func (c *Command) FingerprintEnv(keys ...string) *Command {
+	c.fingerprintEnv = append([]string{}, keys...)
+	return c
+}
+
+// Fingerprint returns a stable hash of the command's arguments, relevant environment,
+// directory, output configuration, and input. Commands with the same fingerprint can be
+// expected to behave the same, which makes it suitable for use as a key when caching or
+// deduplicating command executions. Use FingerprintEnv to configure which environment
+// variables participate.
+//
+// Arguments are hashed as they are executed, once they are expanded, for example with
+// ExpandEnv, and wrapped by options such as Sudo, Nice, and Umask. Cgroup limits also
+// participate.
+//
+// If the command has input, it is read in its entirety to compute the fingerprint, and
+// replaced with the content that was read so that the command can still be run. Commands
+// with input from StdinPipe cannot be fingerprinted.
+func (c *Command) Fingerprint() (string, error) {
+	if c.buildError != nil {
+		return "", c.buildError
+	}
+	if c.stdinPipe != nil {
+		return "", errors.New("cannot fingerprint a command with input from StdinPipe")
+	}
+	args, err := c.wrappedArgs()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	writeFingerprintField(h, "args")
+	writeFingerprintStrings(h, args)
+	writeFingerprintField(h, "env")
+	writeFingerprintStrings(h, c.fingerprintEnviron())
+	writeFingerprintField(h, "dir")
+	writeFingerprintField(h, c.dir)
+	writeFingerprintField(h, "attach")
+	writeFingerprintField(h, c.attach.Stdout.String())
+	writeFingerprintField(h, c.attach.Stderr.String())
+	if c.cgroup != nil {
+		writeFingerprintField(h, "cgroup")
+		writeFingerprintField(h, fmt.Sprintf("%v %d %d %s",
+			c.cgroup.CPUs, c.cgroup.Memory, c.cgroup.PIDs, c.cgroup.Parent))
+	}
+
+	if c.stdin != nil {
+		input, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return "", err
+		}
+		c.stdin = bytes.NewReader(input)
+		c.inputs = []io.Reader{c.stdin}
+
+		digest := sha256.Sum256(input)
+		writeFingerprintField(h, "stdin")
+		writeFingerprintField(h, hex.EncodeToString(digest[:]))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isDigest indicates if s is a hex-encoded SHA-256 digest, such as a fingerprint, which
+// ensures it is safe to use as a file name.
+func isDigest(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+// fingerprintEnviron returns the sorted environment entries that participate in the
+// command's fingerprint.
+func (c *Command) fingerprintEnviron() []string {
+	environ := c.environ
+	if c.fingerprintEnv != nil && c.inheritsEnv() {
+		environ = withoutEnvKeys(append(os.Environ(), c.environ...), c.unsetenv)
+	}
+
+	// Later entries take precedence, like in exec.Cmd.
+	values := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		values[k] = v
+	}
+
+	var entries []string
+	if c.fingerprintEnv != nil {
+		for _, k := range c.fingerprintEnv {
+			if v, ok := values[k]; ok {
+				entries = append(entries, k+"="+v)
+			}
+		}
+	} else {
+		for k, v := range values {
+			entries = append(entries, k+"="+v)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+func writeFingerprintStrings(h hash.Hash, values []string) {
+	writeFingerprintLength(h, len(values))
+	for _, v := range values {
+		writeFingerprintField(h, v)
+	}
+}
+
+// writeFingerprintField writes a length-prefixed value so that adjacent fields cannot be
+// confused with each other.
+func writeFingerprintField(h hash.Hash, value string) {
+	writeFingerprintLength(h, len(value))
+	h.Write([]byte(value))
+}
+
+func writeFingerprintLength(h hash.Hash, n int) {
+	var b [binary.MaxVarintLen64]byte
+	h.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
@@ -0,0 +1,86 @@
+package run
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Flags converts the fields of a struct, or a pointer to a struct, with 'flag' tags into
+// command arguments, so that wrappers around CLIs can be declared as typed structs:
+//
+//	type RunOpts struct {
+//		Name    string   `flag:"--name"`
+//		Detach  bool     `flag:"--detach"`
+//		Env     []string `flag:"--env"`
+//		Restart *int     `flag:"--restart-count"`
+//	}
+//	run.Cmd(ctx, "docker run").Args(run.Flags(opts)...).Arg(image)
+//
+// Fields are converted in the order they are declared:
+//
+//   - Fields with zero values, such as empty strings or nil pointers, are omitted. To
+//     provide a zero value, use a pointer to it.
+//   - Booleans that are true are provided as just the flag, e.g. '--detach', and pointers
+//     to booleans that are false are provided as e.g. '--detach=false'.
+//   - Slices are provided as the flag followed by each element, repeated for each
+//     element, e.g. '--env A=1 --env B=2'.
+//   - Other values are provided as the flag followed by the value formatted with
+//     fmt.Sprint, e.g. '--name foo'.
+//
+// Fields without a 'flag' tag, or with the tag 'flag:"-"', are ignored, except for
+// embedded structs, whose fields are converted as if they were part of the outer struct.
+// Flags panics if v is not a struct or a pointer to a struct.
+func Flags(v interface{}) []string {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("run.Flags: expected struct, got %T", v))
+	}
+	return appendFlags(nil, rv)
+}
+
+func appendFlags(args []string, rv reflect.Value) []string {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		value := rv.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		flag, tagged := field.Tag.Lookup("flag")
+		if !tagged {
+			if field.Anonymous && value.Kind() == reflect.Struct {
+				args = appendFlags(args, value)
+			}
+			continue
+		}
+		if flag == "" || flag == "-" || value.IsZero() {
+			continue
+		}
+		pointer := value.Kind() == reflect.Ptr
+		for value.Kind() == reflect.Ptr {
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.Bool:
+			if value.Bool() {
+				args = append(args, flag)
+			} else if pointer {
+				args = append(args, flag+"=false")
+			}
+		case reflect.Slice, reflect.Array:
+			for j := 0; j < value.Len(); j++ {
+				args = append(args, flag, fmt.Sprint(value.Index(j).Interface()))
+			}
+		default:
+			args = append(args, flag, fmt.Sprint(value.Interface()))
+		}
+	}
+	return args
+}
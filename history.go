@@ -0,0 +1,107 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"bitbucket.org/creachadair/shell"
+)
+
+const contextKeyCommandHistory contextKey = "commandHistory"
+
+// CommandHistory accumulates every command executed by sourcegraph/run within a context
+// configured with History, in order of execution. It is safe for concurrent use.
+type CommandHistory struct {
+	mux      sync.Mutex
+	commands []ExecutedCommand
+	// errs are the errors commands exited with, keyed by index in commands.
+	errs map[int]error
+}
+
+// History enables accumulating all commands executed by sourcegraph/run within this
+// context in the returned CommandHistory, which can be exported as a shell script that
+// reproduces what was executed, for example for support bundles.
+//
+// Note that arguments and environments may contain sensitive information.
+func History(ctx context.Context) (context.Context, *CommandHistory) {
+	history := &CommandHistory{}
+	return context.WithValue(ctx, contextKeyCommandHistory, history), history
+}
+
+// getCommandHistory returns the history configured in ctx, or nil.
+func getCommandHistory(ctx context.Context) *CommandHistory {
+	v, _ := ctx.Value(contextKeyCommandHistory).(*CommandHistory)
+	return v
+}
+
+// record adds e to the history, and returns its index for recordError.
+func (h *CommandHistory) record(e ExecutedCommand) int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.commands = append(h.commands, e)
+	return len(h.commands) - 1
+}
+
+// recordError records the error that the command at index i exited with.
+func (h *CommandHistory) recordError(i int, err error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.errs == nil {
+		h.errs = make(map[int]error)
+	}
+	h.errs[i] = err
+}
+
+// failedCommand is a command in a CommandHistory that exited with an error.
+type failedCommand struct {
+	command ExecutedCommand
+	err     error
+}
+
+// failures returns all commands that exited with an error in order of execution.
+func (h *CommandHistory) failures() []failedCommand {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	var failures []failedCommand
+	for i, e := range h.commands {
+		if err, ok := h.errs[i]; ok {
+			failures = append(failures, failedCommand{command: e, err: err})
+		}
+	}
+	return failures
+}
+
+// Commands returns all executed commands in order of execution.
+func (h *CommandHistory) Commands() []ExecutedCommand {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return append([]ExecutedCommand(nil), h.commands...)
+}
+
+// Script writes all executed commands to dst as a shell script, with one command per
+// line. Commands executed in a different directory are run in a subshell that changes
+// to that directory first. Input provided to commands is not reproduced, but if it was
+// captured with CaptureInput, its digest is included as a comment.
+func (h *CommandHistory) Script(dst io.Writer) error {
+	if _, err := fmt.Fprintln(dst, "#!/bin/sh"); err != nil {
+		return err
+	}
+	for _, e := range h.Commands() {
+		if e.Input != nil {
+			if _, err := fmt.Fprintf(dst, "# input sha256:%s\n", e.Input.Digest); err != nil {
+				return err
+			}
+		}
+
+		line := e.String()
+		if e.Dir != "" {
+			line = fmt.Sprintf("(cd %s && %s)", shell.Quote(e.Dir), line)
+		}
+		if _, err := fmt.Fprintln(dst, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package run
+
+import "time"
+
+// ExecutedCommandResult is the result of an executed command, provided to AfterExit
+// hooks.
+type ExecutedCommandResult struct {
+	// Command is the command that was executed.
+	Command ExecutedCommand
+	// Err is the error the command exited with, or failed to start with, as returned by
+	// Output. Use ExitCode to get the exit code.
+	Err error
+	// StartedAt is when the command was started.
+	StartedAt time.Time
+	// Duration is how long the command ran for.
+	Duration time.Duration
+}
+
+// BeforeStart adds a hook that is called with the command before it is started, for
+// example to prompt for confirmation or to modify the final arguments. The hook may
+// modify the command. If the hook returns an error, the command is not started, and
+// Output returns the error. Hooks are called in the order they were added.
+func (c *Command) BeforeStart(hook func(cmd *ExecutedCommand) error) *Command {
+	c.beforeStart = append(c.beforeStart, hook)
+	return c
+}
+
+// AfterExit adds a hook that is called with the result of the command once it has
+// exited or failed to start, for example for bookkeeping. Secrets registered with
+// RedactSecrets are redacted from the provided command. Hooks are called in the order
+// they were added, and should not block.
+func (c *Command) AfterExit(hook func(result ExecutedCommandResult)) *Command {
+	c.afterExit = append(c.afterExit, hook)
+	return c
+}
@@ -0,0 +1,96 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// InputString pipes the given string to the command. If an input is already set, the
+// given input is appended.
+func (c *Command) InputString(s string) *Command {
+	return c.Input(strings.NewReader(s))
+}
+
+// InputBytes pipes the given bytes to the command. If an input is already set, the
+// given input is appended.
+func (c *Command) InputBytes(b []byte) *Command {
+	return c.Input(bytes.NewReader(b))
+}
+
+// InputFile pipes the contents of the file at path to the command. If an input is
+// already set, the given input is appended. The file is opened when the command reads
+// it, and closed once it has been read or the command exits. If the file cannot be
+// opened, the command returns the error.
+func (c *Command) InputFile(path string) *Command {
+	f := &lazyFile{path: path}
+	c.inputClosers = append(c.inputClosers, f)
+	return c.Input(f)
+}
+
+// lazyFile is an io.ReadCloser that opens the file at path on the first read, and
+// closes it when reading is done.
+type lazyFile struct {
+	path string
+
+	mux  sync.Mutex
+	file *os.File
+	// done is set when the file should no longer be read.
+	done bool
+}
+
+func (f *lazyFile) Read(p []byte) (int, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if f.done {
+		return 0, io.EOF
+	}
+	if f.file == nil {
+		file, err := os.Open(f.path)
+		if err != nil {
+			f.done = true
+			return 0, fmt.Errorf("failed to open input: %w", err)
+		}
+		f.file = file
+	}
+	n, err := f.file.Read(p)
+	if err != nil {
+		f.closeLocked()
+	}
+	return n, err
+}
+
+func (f *lazyFile) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.closeLocked()
+}
+
+func (f *lazyFile) closeLocked() error {
+	f.done = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// StdinPipe returns a pipe that is connected to the command's input when the command is
+// run, so that input can be written incrementally, for example in response to output
+// from the command. It replaces any input that has already been set. The pipe should be
+// closed once all input has been written, and writes to the pipe fail once the command
+// has exited.
+func (c *Command) StdinPipe() (io.WriteCloser, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c.ResetInput()
+	c.stdinPipe = r
+	c.Input(r)
+	return w, nil
+}
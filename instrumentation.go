@@ -2,6 +2,10 @@ package run
 
 import (
 	"context"
+	"os"
+	"strings"
+
+	"bitbucket.org/creachadair/shell"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -20,6 +24,58 @@ type ExecutedCommand struct {
 	Args    []string
 	Dir     string
 	Environ []string
+	// ClearEnv indicates the command does not inherit the environment of the current
+	// process, so it only has the variables in Environ.
+	ClearEnv bool
+	// Unsetenv are the variables removed from the inherited environment.
+	Unsetenv []string
+
+	// Input is the input provided to the command, if enabled with CaptureInput and the
+	// command has input.
+	Input *CapturedInput
+}
+
+// String returns the command as a shell-quoted command line that can be copied into a
+// shell. Environment variables that are not inherited from the current process are
+// included as a prefix, e.g. 'FOO=bar cmd arg'. If the command does not inherit the
+// environment, or removes variables from it, the command line uses 'env -i' or
+// 'env -u KEY' respectively. Dir and Input are not included.
+func (e ExecutedCommand) String() string {
+	inherited := make(map[string]struct{})
+	if !e.ClearEnv {
+		for _, kv := range os.Environ() {
+			inherited[kv] = struct{}{}
+		}
+	}
+
+	parts := make([]string, 0, 2+2*len(e.Unsetenv)+len(e.Environ)+len(e.Args))
+	if e.ClearEnv {
+		parts = append(parts, "env", "-i")
+	} else if len(e.Unsetenv) > 0 {
+		parts = append(parts, "env")
+		for _, k := range e.Unsetenv {
+			parts = append(parts, "-u", shell.Quote(k))
+		}
+	}
+	for _, kv := range e.Environ {
+		if _, ok := inherited[kv]; ok {
+			continue
+		}
+		k, v, _ := strings.Cut(kv, "=")
+		parts = append(parts, k+"="+shell.Quote(v))
+	}
+	for _, arg := range e.Args {
+		parts = append(parts, shell.Quote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// commandLine is like String, but prefixes the command with 'cd dir &&' if Dir is set.
+func (e ExecutedCommand) commandLine() string {
+	if e.Dir != "" {
+		return "cd " + shell.Quote(e.Dir) + " && " + e.String()
+	}
+	return e.String()
 }
 
 // LogFunc can be used to generate a log entry for the executed command.
@@ -1,8 +1,13 @@
 package run_test
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,6 +36,170 @@ func TestInstrumentation(t *testing.T) {
 		// Check logged results
 		c.Assert(entries, qt.HasLen, 1)
 		c.Assert(entries[0].Args, qt.CmpEquals(), []string{"echo", "hello world"})
+		c.Assert(entries[0].Input, qt.IsNil)
+	})
+
+	c.Run("ExecutedCommand.String", func(c *qt.C) {
+		var entries []run.ExecutedCommand
+		ctx := run.LogCommands(context.Background(), func(e run.ExecutedCommand) {
+			entries = append(entries, e)
+		})
+
+		_ = run.Cmd(ctx, "echo", run.Arg("hello world"), run.Arg("it's")).
+			Environ(os.Environ()).
+			Env(map[string]string{"FOO": "bar baz"}).
+			Run().Wait()
+
+		c.Assert(entries, qt.HasLen, 1)
+		c.Assert(entries[0].String(), qt.Equals, `FOO='bar baz' echo 'hello world' it\'s`)
+
+		entries = nil
+		_ = run.Cmd(ctx, "true").InheritEnv(false).Env(map[string]string{"LANG": "C"}).Run().Wait()
+		_ = run.Cmd(ctx, "true").Unsetenv("FOO", "BAR").Env(map[string]string{"BAZ": "qux"}).Run().Wait()
+		c.Assert(entries, qt.HasLen, 2)
+		c.Assert(entries[0].String(), qt.Equals, "env -i LANG=C true")
+		c.Assert(entries[1].String(), qt.Equals, "env -u FOO -u BAR BAZ=qux true")
+	})
+
+	c.Run("History", func(c *qt.C) {
+		ctx, history := run.History(context.Background())
+
+		_ = run.Cmd(ctx, "echo 'hello world'").Run().Wait()
+		_ = run.Cmd(ctx, "ls").Dir("/tmp").Run().Wait()
+
+		var script strings.Builder
+		c.Assert(history.Script(&script), qt.IsNil)
+		c.Assert(script.String(), qt.Equals, `#!/bin/sh
+echo 'hello world'
+(cd /tmp && ls)
+`)
+	})
+
+	c.Run("Capture input", func(c *qt.C) {
+		var entries []run.ExecutedCommand
+		ctx := run.LogCommands(context.Background(), func(e run.ExecutedCommand) {
+			entries = append(entries, e)
+		})
+		ctx = run.CaptureInput(ctx, 16, func(input []byte) []byte {
+			return []byte(strings.ReplaceAll(string(input), "secret", "REDACTED"))
+		})
+
+		out, err := run.Cmd(ctx, "cat").
+			Input(strings.NewReader("password: secret\nmore input")).
+			Run().
+			String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "password: secret\nmore input")
+
+		c.Assert(entries, qt.HasLen, 1)
+		c.Assert(entries[0].Input, qt.IsNotNil)
+		c.Assert(string(entries[0].Input.Content), qt.Equals, "password: REDACTED")
+		c.Assert(entries[0].Input.Truncated, qt.IsTrue)
+		c.Assert(entries[0].Input.Digest, qt.HasLen, 64)
+	})
+
+	c.Run("Capture input from StdinPipe", func(c *qt.C) {
+		var entries []run.ExecutedCommand
+		ctx := run.LogCommands(context.Background(), func(e run.ExecutedCommand) {
+			entries = append(entries, e)
+		})
+		ctx = run.CaptureInput(ctx, 1024, nil)
+
+		cmd := run.Cmd(ctx, "cat")
+		stdin, err := cmd.StdinPipe()
+		c.Assert(err, qt.IsNil)
+		cmd.InputString(" world")
+
+		// Run must not block waiting for input to be captured.
+		started := make(chan run.Output)
+		go func() { started <- cmd.Run() }()
+		var out run.Output
+		select {
+		case out = <-started:
+		case <-time.After(5 * time.Second):
+			c.Fatal("Run blocked on StdinPipe")
+		}
+
+		_, err = io.WriteString(stdin, "hello")
+		c.Assert(err, qt.IsNil)
+		c.Assert(stdin.Close(), qt.IsNil)
+		res, err := out.String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(res, qt.Equals, "hello world")
+
+		c.Assert(entries, qt.HasLen, 1)
+		c.Assert(entries[0].Input, qt.IsNil)
+	})
+
+	c.Run("Capture streaming input", func(c *qt.C) {
+		var entries []run.ExecutedCommand
+		ctx := run.LogCommands(context.Background(), func(e run.ExecutedCommand) {
+			entries = append(entries, e)
+		})
+		ctx = run.CaptureInput(ctx, 1024, nil)
+
+		r, w := io.Pipe()
+		cmd := run.Cmd(ctx, "cat").InputString("hello").Input(r)
+
+		// Run must not block waiting for input to be captured.
+		started := make(chan run.Output)
+		go func() { started <- cmd.Run() }()
+		var out run.Output
+		select {
+		case out = <-started:
+		case <-time.After(5 * time.Second):
+			c.Fatal("Run blocked on streaming input")
+		}
+
+		_, err := io.WriteString(w, " world")
+		c.Assert(err, qt.IsNil)
+		c.Assert(w.Close(), qt.IsNil)
+		res, err := out.String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(res, qt.Equals, "hello world")
+
+		c.Assert(entries, qt.HasLen, 1)
+		c.Assert(entries[0].Input, qt.IsNil)
+	})
+
+	c.Run("Recording", func(c *qt.C) {
+		var registry run.CommandRegistry
+		ctx := run.RecordCommands(context.Background(), &registry)
+
+		_ = run.Cmd(ctx, "echo 'hello world'").Run().Wait()
+		_ = run.Cmd(ctx, "echo -n hello").Run().Wait()
+		_ = run.Cmd(ctx, "git status").Run().Wait()
+		_ = run.Cmd(ctx, "git --version").Run().Wait()
+
+		c.Assert(registry.Commands(), qt.CmpEquals(), []run.RegisteredCommand{
+			{Name: "echo", Subcommands: []string{"hello world"}, Executions: 2},
+			{Name: "git", Subcommands: []string{"status"}, Executions: 2},
+		})
+
+		var report strings.Builder
+		c.Assert(registry.Report(&report), qt.IsNil)
+		c.Assert(report.String(), qt.Equals, "echo hello world (2)\ngit status (2)\n")
+	})
+
+	c.Run("Leak detection", func(c *qt.C) {
+		ctx, leaks := run.DetectLeaks(context.Background())
+
+		out := run.Cmd(ctx, "echo 'hello world'").Run()
+		c.Assert(leaks.Leaks(), qt.HasLen, 1)
+		c.Assert(leaks.Check(), qt.ErrorMatches, `(?s)1 Outputs were never consumed or closed.*instrumentation_test.go.*`)
+
+		var reported []string
+		leaks.Report(func(args ...interface{}) { reported = append(reported, fmt.Sprint(args...)) })
+		c.Assert(reported, qt.HasLen, 1)
+		c.Assert(reported[0], qt.Matches, `(?s)Output was never consumed or closed: \["echo" "hello world"\] created at:.*instrumentation_test.go.*`)
+
+		c.Assert(out.Wait(), qt.IsNil)
+		c.Assert(leaks.Leaks(), qt.HasLen, 0)
+		c.Assert(leaks.Check(), qt.IsNil)
+
+		// Closing also counts
+		_ = run.Cmd(ctx, "echo 'hello world'").Run().Close()
+		c.Assert(leaks.Check(), qt.IsNil)
 	})
 
 	c.Run("Tracing", func(c *qt.C) {
@@ -84,3 +253,78 @@ func TestInstrumentation(t *testing.T) {
 		})
 	})
 }
+
+func TestSupportBundle(t *testing.T) {
+	c := qt.New(t)
+	t.Setenv("RUN_TEST_API_TOKEN", "hunter2")
+
+	ctx, _ := run.History(context.Background())
+	ctx = run.RecordCommands(ctx, &run.CommandRegistry{})
+	_ = run.Cmd(ctx, "echo hello").Env(map[string]string{"GITHUB_TOKEN": "hunter3"}).Run().Wait()
+	_ = run.Bash(ctx, "echo oh no >&2; exit 1").Run().Wait()
+
+	var buf bytes.Buffer
+	c.Assert(run.SupportBundle(ctx, &buf, "echo"), qt.IsNil)
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	c.Assert(err, qt.IsNil)
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		c.Assert(err, qt.IsNil)
+		b, err := io.ReadAll(r)
+		c.Assert(err, qt.IsNil)
+		files[f.Name] = string(b)
+	}
+
+	c.Assert(files["environment.txt"], qt.Contains, "RUN_TEST_API_TOKEN=REDACTED\n")
+	c.Assert(files["history.sh"], qt.Equals, "#!/bin/sh\nGITHUB_TOKEN=REDACTED echo hello\nbash -c 'echo oh no >&2; exit 1'\n")
+	c.Assert(files["errors.txt"], qt.Equals, "$ bash -c 'echo oh no >&2; exit 1'\nexit status 1: oh no\n\n")
+	c.Assert(files["commands.txt"], qt.Contains, "echo hello (1)\n")
+	// Only the given binaries are queried, not binaries from the history.
+	c.Assert(files["versions.txt"], qt.Matches, "echo: [^\n]+\n")
+	c.Assert(files["system.txt"], qt.Contains, "go: go")
+	for _, content := range files {
+		c.Assert(content, qt.Not(qt.Contains), "hunter")
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	c := qt.New(t)
+
+	var entries []run.ExecutedCommand
+	ctx := run.LogCommands(context.Background(), func(e run.ExecutedCommand) {
+		entries = append(entries, e)
+	})
+	ctx, secrets := run.RedactSecrets(ctx)
+	secrets.Add("hunter2")
+
+	c.Run("commands and errors", func(c *qt.C) {
+		entries = nil
+		cmd := run.Bash(ctx, "echo $TOKEN-"+run.Secret(ctx, "sekrit")+" >&2; exit 1").
+			Env(map[string]string{"TOKEN": "hunter2"})
+		c.Assert(cmd.String(), qt.Equals, `TOKEN='<redacted>' bash -c 'echo $TOKEN-<redacted> >&2; exit 1'`)
+
+		out, err := cmd.StdOut().Run().String()
+		c.Assert(out, qt.Equals, "")
+		c.Assert(err, qt.ErrorMatches, "exit status 1: <redacted>-<redacted>")
+
+		c.Assert(entries, qt.HasLen, 1)
+		c.Assert(entries[0].Args, qt.DeepEquals, []string{"bash", "-c", "echo $TOKEN-<redacted> >&2; exit 1"})
+		c.Assert(entries[0].Environ, qt.DeepEquals, []string{"TOKEN=<redacted>"})
+	})
+
+	c.Run("output is not redacted by default", func(c *qt.C) {
+		out, err := run.Cmd(ctx, "echo hunter2").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "hunter2")
+	})
+
+	c.Run("output", func(c *qt.C) {
+		ctx, secrets := run.RedactSecrets(ctx)
+		secrets.RedactOutput().Add("hunter2", "hunter")
+		out, err := run.Cmd(ctx, "echo hunter2 hunter").Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "<redacted> <redacted>")
+	})
+}
@@ -10,8 +10,18 @@ import (
 	"github.com/itchyny/gojq"
 )
 
+// jqQuery is a compiled jq query.
+type jqQuery struct {
+	// code is the compiled query.
+	code *gojq.Code
+	// iterCode, if set, is compiled from the remainder of a query that begins by
+	// iterating over its input, e.g. '.[] | .foo', and can be executed against each
+	// element of a top-level JSON array individually.
+	iterCode *gojq.Code
+}
+
 // buildJQ parses and compiles a jq query.
-func buildJQ(query string) (*gojq.Code, error) {
+func buildJQ(query string) (*jqQuery, error) {
 	jq, err := gojq.Parse(query)
 	if err != nil {
 		return nil, fmt.Errorf("jq.Parse: %w", err)
@@ -20,16 +30,52 @@ func buildJQ(query string) (*gojq.Code, error) {
 	if err != nil {
 		return nil, fmt.Errorf("jq.Compile: %w", err)
 	}
-	return jqCode, nil
+
+	q := &jqQuery{code: jqCode}
+	if len(jq.FuncDefs) == 0 && len(jq.Imports) == 0 && jq.Meta == nil {
+		if remainder, ok := trimLeadingIter(jq); ok {
+			// If this fails, we just fall back to executing the entire query.
+			q.iterCode, _ = gojq.Compile(remainder)
+		}
+	}
+	return q, nil
+}
+
+// trimLeadingIter returns the remainder of a query that begins by iterating over its
+// input, for example '.foo' for '.[] | .foo' and '.[].foo'.
+func trimLeadingIter(q *gojq.Query) (*gojq.Query, bool) {
+	if q.Op == gojq.OpPipe {
+		left, ok := trimLeadingIter(q.Left)
+		if !ok {
+			return nil, false
+		}
+		return &gojq.Query{Left: left, Op: gojq.OpPipe, Right: q.Right}, true
+	}
+
+	t := q.Term
+	if q.Op != 0 || t == nil || t.Type != gojq.TermTypeIdentity ||
+		len(t.SuffixList) == 0 || !t.SuffixList[0].Iter {
+		return nil, false
+	}
+	remainder := t.SuffixList[1:]
+	if len(remainder) > 0 && remainder[0].Optional {
+		return nil, false // '.[]?' also suppresses errors from non-iterable input
+	}
+	for _, s := range remainder {
+		if s.Bind != nil {
+			return nil, false
+		}
+	}
+	return &gojq.Query{Term: &gojq.Term{Type: gojq.TermTypeIdentity, SuffixList: remainder}}, true
 }
 
 // execJQBytes can be used to execute a compiled jq query against small content bytes,
 // e.g. lines. Errors are annotated with the provided content for ease of debugging.
-func execJQBytes(ctx context.Context, jqCoode *gojq.Code, content []byte) ([]byte, error) {
+func execJQBytes(ctx context.Context, jq *jqQuery, content []byte) ([]byte, error) {
 	if len(content) == 0 {
 		return nil, nil
 	}
-	result, err := execJQ(ctx, jqCoode, bytes.NewReader(content))
+	result, err := execJQ(ctx, jq, bytes.NewReader(content))
 	if err != nil {
 		// Embed the consumed content
 		return nil, fmt.Errorf("%w: %s", err, string(content))
@@ -37,30 +83,99 @@ func execJQBytes(ctx context.Context, jqCoode *gojq.Code, content []byte) ([]byt
 	return result, nil
 }
 
-// execJQ executes the compiled jq query against content from reader.
-func execJQ(ctx context.Context, jqCode *gojq.Code, reader io.Reader) ([]byte, error) {
-	var input interface{}
-	if err := json.NewDecoder(reader).Decode(&input); err != nil {
-		return nil, fmt.Errorf("json: %w", err)
+// execJQ executes the compiled jq query against each JSON value from reader, e.g. a
+// single JSON document or newline-delimited JSON. If an error occurs, results produced
+// before the error are returned with it. Values are decoded incrementally, and
+// if the query begins by iterating over its input, top-level arrays are evaluated one
+// element at a time so that the entire array need not be held in memory.
+func execJQ(ctx context.Context, jq *jqQuery, reader io.Reader) ([]byte, error) {
+	var result bytes.Buffer
+	dec := json.NewDecoder(reader)
+	for values := 0; ; values++ {
+		tok, err := dec.Token()
+		if err == io.EOF && values > 0 {
+			break
+		} else if err != nil {
+			return result.Bytes(), fmt.Errorf("json: %w", err)
+		}
+
+		switch tok {
+		case json.Delim('['):
+			var array []interface{}
+			for dec.More() {
+				var elem interface{}
+				if err := dec.Decode(&elem); err != nil {
+					return result.Bytes(), fmt.Errorf("json: %w", err)
+				}
+				if jq.iterCode != nil {
+					if err := runJQ(ctx, jq.iterCode, elem, &result); err != nil {
+						return result.Bytes(), err
+					}
+				} else {
+					array = append(array, elem)
+				}
+			}
+			if _, err := dec.Token(); err != nil { // consume ']'
+				return result.Bytes(), fmt.Errorf("json: %w", err)
+			}
+			if jq.iterCode == nil {
+				if array == nil {
+					array = []interface{}{}
+				}
+				if err := runJQ(ctx, jq.code, array, &result); err != nil {
+					return result.Bytes(), err
+				}
+			}
+
+		case json.Delim('{'):
+			object := map[string]interface{}{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return result.Bytes(), fmt.Errorf("json: %w", err)
+				}
+				var value interface{}
+				if err := dec.Decode(&value); err != nil {
+					return result.Bytes(), fmt.Errorf("json: %w", err)
+				}
+				object[key.(string)] = value
+			}
+			if _, err := dec.Token(); err != nil { // consume '}'
+				return result.Bytes(), fmt.Errorf("json: %w", err)
+			}
+			if err := runJQ(ctx, jq.code, object, &result); err != nil {
+				return result.Bytes(), err
+			}
+
+		default:
+			if _, isDelim := tok.(json.Delim); isDelim {
+				return result.Bytes(), fmt.Errorf("json: unexpected delimiter %q", tok)
+			}
+			if err := runJQ(ctx, jq.code, tok, &result); err != nil {
+				return result.Bytes(), err
+			}
+		}
 	}
+	return result.Bytes(), nil
+}
 
-	var result bytes.Buffer
+// runJQ executes jqCode against input and writes the encoded results to dst.
+func runJQ(ctx context.Context, jqCode *gojq.Code, input interface{}, dst *bytes.Buffer) error {
 	iter := jqCode.RunWithContext(ctx, input)
 	for {
 		v, ok := iter.Next()
 		if !ok {
-			break
+			return nil
 		}
 
 		if err, ok := v.(error); ok {
-			return nil, fmt.Errorf("jq: %w", err)
+			return fmt.Errorf("jq: %w", err)
 		}
 
 		encoded, err := gojq.Marshal(v)
 		if err != nil {
-			return nil, fmt.Errorf("jq: %w", err)
+			return fmt.Errorf("jq: %w", err)
 		}
-		result.Write(encoded)
+		dst.Write(encoded)
 	}
-	return result.Bytes(), nil
 }
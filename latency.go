@@ -0,0 +1,47 @@
+package run
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"bitbucket.org/creachadair/shell"
+)
+
+// LineLatency configures the command to flush its output line by line, for commands
+// that buffer output when it is not written to a terminal, which causes output to arrive
+// in bursts. This is useful when output is streamed to users, e.g. with StreamLines.
+//
+// If available, the command is run with 'stdbuf -oL -eL', which affects programs that
+// use C standard I/O. Otherwise, the command is run with 'script', which runs the command
+// in a pseudo-terminal - in this case, stdout and stderr cannot be told apart, and lines
+// may end with '\r\n'. If neither is available, such as on Windows, LineLatency has no
+// effect.
+func (c *Command) LineLatency() *Command {
+	c.lineLatency = true
+	return c
+}
+
+// lineLatencyWrap wraps args with a platform-appropriate helper that makes the command
+// flush output line by line, if one is available.
+func lineLatencyWrap(args []string) []string {
+	if _, err := exec.LookPath("stdbuf"); err == nil {
+		return append([]string{"stdbuf", "-oL", "-eL"}, args...)
+	}
+	if _, err := exec.LookPath("script"); err != nil {
+		return args
+	}
+	switch runtime.GOOS {
+	case "linux":
+		// util-linux script accepts a single command string.
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = shell.Quote(arg)
+		}
+		return []string{"script", "-q", "-e", "-c", strings.Join(quoted, " "), "/dev/null"}
+	case "darwin", "freebsd", "netbsd", "openbsd", "dragonfly":
+		return append([]string{"script", "-q", "/dev/null"}, args...)
+	default:
+		return args
+	}
+}
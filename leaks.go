@@ -0,0 +1,101 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+const contextKeyLeakDetector contextKey = "leakDetector"
+
+// LeakDetector tracks Outputs created within a context configured with DetectLeaks, and
+// reports Outputs that were never consumed or closed. Abandoned Outputs can leak
+// goroutines and buffers, and leave commands running.
+type LeakDetector struct {
+	mux     sync.Mutex
+	outputs map[*commandOutput]Leak
+}
+
+// Leak describes an Output that was never consumed or closed.
+type Leak struct {
+	// Args are the arguments of the command that created the Output.
+	Args []string
+	// Stack is the stack trace of where the command was run.
+	Stack string
+}
+
+// DetectLeaks enables tracking of all Outputs created by commands run within this
+// context with the returned LeakDetector. This incurs some overhead, so it is intended
+// for use in tests or debugging.
+//
+// For example, in tests:
+//
+//	ctx, leaks := run.DetectLeaks(ctx)
+//	t.Cleanup(func() { leaks.Report(t.Error) })
+func DetectLeaks(ctx context.Context) (context.Context, *LeakDetector) {
+	detector := &LeakDetector{outputs: make(map[*commandOutput]Leak)}
+	return context.WithValue(ctx, contextKeyLeakDetector, detector), detector
+}
+
+// getLeakDetector returns the leak detector configured in ctx, or nil.
+func getLeakDetector(ctx context.Context) *LeakDetector {
+	v, _ := ctx.Value(contextKeyLeakDetector).(*LeakDetector)
+	return v
+}
+
+func (d *LeakDetector) track(o *commandOutput, args []string) {
+	d.mux.Lock()
+	d.outputs[o] = Leak{Args: args, Stack: string(debug.Stack())}
+	d.mux.Unlock()
+}
+
+func (d *LeakDetector) release(o *commandOutput) {
+	d.mux.Lock()
+	delete(d.outputs, o)
+	d.mux.Unlock()
+}
+
+// Leaks returns all Outputs that have not yet been consumed or closed.
+func (d *LeakDetector) Leaks() []Leak {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	leaks := make([]Leak, 0, len(d.outputs))
+	for _, l := range d.outputs {
+		leaks = append(leaks, l)
+	}
+	return leaks
+}
+
+// Check returns an error describing all Outputs that have not yet been consumed or
+// closed, including where they were created, or nil if there are none.
+func (d *LeakDetector) Check() error {
+	leaks := d.Leaks()
+	if len(leaks) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d Outputs were never consumed or closed:", len(leaks))
+	for _, l := range leaks {
+		fmt.Fprintf(&b, "\n\n%s", l)
+	}
+	return errors.New(b.String())
+}
+
+// Report calls report for each Output that has not yet been consumed or closed, with a
+// description of the Output including where it was created. report can be a method of
+// testing.TB such as Error or Log, so that each leak is reported separately in tests.
+func (d *LeakDetector) Report(report func(args ...interface{})) {
+	for _, l := range d.Leaks() {
+		report("Output was never consumed or closed: " + l.String())
+	}
+}
+
+// String describes the leaked Output, including where it was created.
+func (l Leak) String() string {
+	return fmt.Sprintf("%q created at:\n%s", l.Args, l.Stack)
+}
@@ -0,0 +1,45 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"go.bobheadxi.dev/streamline"
+	"go.bobheadxi.dev/streamline/pipeline"
+)
+
+const contextKeyNormalizeLineEndings contextKey = "normalizeLineEndings"
+
+// NormalizeLineEndings configures all usages of sourcegraph/run within this context to
+// convert Windows line endings (\r\n) in output to \n, before output is processed by
+// LineMaps and Pipelines and aggregated by functions such as Lines. This prevents stray
+// \r characters in output from Windows binaries, for example when run under WSL.
+//
+// By default, line endings are preserved as-is.
+func NormalizeLineEndings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyNormalizeLineEndings, true)
+}
+
+// isNormalizeLineEndings indicates if NormalizeLineEndings is configured in ctx.
+func isNormalizeLineEndings(ctx context.Context) bool {
+	v, _ := ctx.Value(contextKeyNormalizeLineEndings).(bool)
+	return v
+}
+
+// newStream creates the stream that aggregates output read from r, applying the
+// pipelines configured in ctx.
+func newStream(ctx context.Context, r io.Reader) *streamline.Stream {
+	stream := streamline.New(r)
+	if isNormalizeLineEndings(ctx) {
+		stream = stream.WithPipeline(pipeline.Map(func(line []byte) []byte {
+			return bytes.TrimSuffix(line, []byte("\r"))
+		}))
+	}
+	if secrets := getSecretRegistry(ctx); secrets != nil {
+		if p := secrets.outputPipeline(); p != nil {
+			stream = stream.WithPipeline(p)
+		}
+	}
+	return stream
+}
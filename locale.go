@@ -0,0 +1,32 @@
+package run
+
+import (
+	"context"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// Locale configures the command to run with the given locale, e.g. "C.UTF-8", by
+// setting LANG and LC_ALL. Many tools format output, such as dates, numbers, and error
+// messages, differently depending on the locale, so setting a fixed locale makes output
+// predictable for parsing regardless of the user's locale.
+func (c *Command) Locale(locale string) *Command {
+	return c.Env(map[string]string{
+		"LANG":   locale,
+		"LC_ALL": locale,
+	})
+}
+
+// WarnInvalidUTF8 creates a LineMap that calls warn with the first line of output that
+// is not valid UTF-8, for example to warn that a command is not running with a UTF-8
+// locale - see Command.Locale. Lines are passed through as-is.
+func WarnInvalidUTF8(warn func(line []byte)) LineMap {
+	var once sync.Once
+	return func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+		if !utf8.Valid(line) {
+			once.Do(func() { warn(append([]byte(nil), line...)) })
+		}
+		return dst.Write(line)
+	}
+}
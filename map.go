@@ -3,7 +3,10 @@ package run
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"reflect"
+	"runtime"
 
 	"go.bobheadxi.dev/streamline/pipeline"
 )
@@ -24,13 +27,13 @@ type LineMap func(ctx context.Context, line []byte, dst io.Writer) (int, error)
 //
 // Refer to https://github.com/itchyny/gojq for the specifics of supported syntax.
 func MapJQ(query string) (LineMap, error) {
-	jqCode, err := buildJQ(query)
+	jq, err := buildJQ(query)
 	if err != nil {
 		return nil, err
 	}
 
 	return func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
-		b, err := execJQBytes(ctx, jqCode, line)
+		b, err := execJQBytes(ctx, jq, line)
 		if err != nil {
 			return 0, err
 		}
@@ -48,19 +51,73 @@ var _ pipeline.Pipeline = &lineMapPipelineAdapter{}
 
 func (l *lineMapPipelineAdapter) Inactive() bool { return l == nil || l.lineMap == nil }
 
-func (l *lineMapPipelineAdapter) ProcessLine(line []byte) ([]byte, error) {
+func (l *lineMapPipelineAdapter) ProcessLine(line []byte) (_ []byte, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = newLineMapPanicError(l.lineMap, line, v)
+		}
+	}()
+
 	// Use a shared buffer when applying this LineMap - it gets reset on each
 	// line, and lines are processed synchronously.
 	l.buffer.Reset()
 
 	buf := tracedBuffer{Buffer: l.buffer}
-	_, err := l.lineMap(l.ctx, line, &buf)
+	_, err = l.lineMap(l.ctx, line, &buf)
 	if !buf.writeCalled || err != nil {
 		return nil, err // omit the line or return the error
 	}
 	return buf.Bytes(), nil
 }
 
+// maxLineMapPanicLine is the maximum length of the line included in LineMapPanicError.
+const maxLineMapPanicLine = 256
+
+// LineMapPanicError is returned when a LineMap panics while processing a line, instead
+// of crashing the program. To omit lines that cause a LineMap to panic instead, use
+// SkipPanics.
+type LineMapPanicError struct {
+	// Name is the name of the LineMap function that panicked.
+	Name string
+	// Line is the line that was being processed, truncated if it is long.
+	Line []byte
+	// Value is the value the LineMap panicked with.
+	Value interface{}
+}
+
+func newLineMapPanicError(f LineMap, line []byte, v interface{}) *LineMapPanicError {
+	name := "unknown"
+	if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+		name = fn.Name()
+	}
+	if len(line) > maxLineMapPanicLine {
+		line = line[:maxLineMapPanicLine]
+	}
+	return &LineMapPanicError{Name: name, Line: append([]byte(nil), line...), Value: v}
+}
+
+func (e *LineMapPanicError) Error() string {
+	return fmt.Sprintf("LineMap %s panicked on line %q: %v", e.Name, e.Line, e.Value)
+}
+
+// SkipPanics wraps a LineMap such that lines that cause it to panic are omitted from
+// output, instead of interrupting line processing with a LineMapPanicError.
+func SkipPanics(f LineMap) LineMap {
+	return func(ctx context.Context, line []byte, dst io.Writer) (n int, err error) {
+		defer func() {
+			if recover() != nil {
+				// Omit the line, discarding anything written by the panicking LineMap.
+				if t, ok := dst.(*tracedBuffer); ok {
+					t.Reset()
+					t.writeCalled = false
+				}
+				n, err = 0, nil
+			}
+		}()
+		return f(ctx, line, dst)
+	}
+}
+
 type tracedBuffer struct {
 	// writeCalled indicates that Write was called at all, even with empty input.
 	writeCalled bool
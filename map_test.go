@@ -2,6 +2,10 @@ package run_test
 
 import (
 	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os/exec"
 	"strings"
 	"testing"
 
@@ -33,3 +37,144 @@ func TestJQMap(t *testing.T) {
 		`"hi robert!"`,
 	})
 }
+
+func TestNormalize(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	dir := c.TempDir()
+	const output = `2022-03-04T05:06:07.123Z starting
+15:04:05 took 1m30.5s
+ok in 250ms
+`
+
+	lines, err := run.Cmd(ctx, "cat").
+		Input(strings.NewReader(output + dir + "/out.txt")).
+		Run().
+		Map(run.NormalizeTimestamps()).
+		Map(run.NormalizeDurations()).
+		Map(run.NormalizeTempPaths(dir)).
+		Map(run.NormalizePathSeparators()).
+		Lines()
+	c.Assert(err, qt.IsNil)
+	c.Assert(lines, qt.CmpEquals(), []string{
+		"<timestamp> starting",
+		"<timestamp> took <duration>",
+		"ok in <duration>",
+		"<tmp>/out.txt",
+	})
+}
+
+func TestGzip(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	input := run.Cmd(ctx, "echo hello world").Run()
+	out, err := run.Cmd(ctx, "cat").
+		Input(run.GzipDecompress(run.GzipCompress(input))).
+		Run().
+		String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "hello world")
+
+	c.Run("compatible with gzip", func(c *qt.C) {
+		if _, err := exec.LookPath("gzip"); err != nil {
+			c.Skip("gzip not available")
+		}
+		out, err := run.Cmd(ctx, "gzip -dc").
+			Input(run.GzipCompress(strings.NewReader("hello world\n"))).
+			Run().
+			String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "hello world")
+	})
+
+	c.Run("closed once the command exits", func(c *qt.C) {
+		// Provide more input than the command reads, or pipes can buffer.
+		input := run.GzipCompress(io.LimitReader(rand.New(rand.NewSource(1)), 16<<20))
+		err := run.Cmd(ctx, "true").Input(input).Run().Wait()
+		c.Assert(err, qt.IsNil)
+
+		_, err = input.Read(make([]byte, 1))
+		c.Assert(err, qt.Equals, io.ErrClosedPipe)
+	})
+
+	c.Run("invalid input", func(c *qt.C) {
+		_, err := io.ReadAll(run.GzipDecompress(strings.NewReader("hello world")))
+		c.Assert(err, qt.IsNotNil)
+	})
+}
+
+func panicOnB(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+	if string(line) == "b" {
+		dst.Write([]byte("partial"))
+		panic("unexpected line")
+	}
+	return dst.Write(line)
+}
+
+func TestLineMapPanics(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	input := "a\nb\nc\n"
+
+	c.Run("recovered", func(c *qt.C) {
+		_, err := run.Cmd(ctx, "cat").Input(strings.NewReader(input)).Run().
+			Map(panicOnB).
+			Lines()
+		var panicErr *run.LineMapPanicError
+		c.Assert(errors.As(err, &panicErr), qt.IsTrue)
+		c.Assert(panicErr.Name, qt.Equals, "github.com/sourcegraph/run_test.panicOnB")
+		c.Assert(string(panicErr.Line), qt.Equals, "b")
+		c.Assert(panicErr.Value, qt.Equals, "unexpected line")
+		c.Assert(err, qt.ErrorMatches, `LineMap .*panicOnB panicked on line "b": unexpected line`)
+	})
+
+	c.Run("truncated", func(c *qt.C) {
+		_, err := run.Cmd(ctx, "cat").Input(strings.NewReader(strings.Repeat("x", 1000))).Run().
+			Map(func(context.Context, []byte, io.Writer) (int, error) { panic("oops") }).
+			Lines()
+		var panicErr *run.LineMapPanicError
+		c.Assert(errors.As(err, &panicErr), qt.IsTrue)
+		c.Assert(len(panicErr.Line) < 1000, qt.IsTrue)
+	})
+
+	c.Run("skipped", func(c *qt.C) {
+		lines, err := run.Cmd(ctx, "cat").Input(strings.NewReader(input)).Run().
+			Map(run.SkipPanics(panicOnB)).
+			Lines()
+		c.Assert(err, qt.IsNil)
+		c.Assert(lines, qt.DeepEquals, []string{"a", "c"})
+	})
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	c := qt.New(t)
+	ctx := run.NormalizeLineEndings(context.Background())
+
+	var mapped []string
+	lines, err := run.Cmd(ctx, "printf", run.Arg(`a\r\nb\r\nc`)).Run().
+		Map(func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+			mapped = append(mapped, string(line))
+			return dst.Write(line)
+		}).
+		Lines()
+	c.Assert(err, qt.IsNil)
+	c.Assert(lines, qt.DeepEquals, []string{"a", "b", "c"})
+	c.Assert(mapped, qt.DeepEquals, []string{"a", "b", "c"})
+}
+
+func TestWarnInvalidUTF8(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var warnings []string
+	lines, err := run.Cmd(ctx, "printf", run.Arg(`ok\n\377a\n\376b\n`)).Run().
+		Map(run.WarnInvalidUTF8(func(line []byte) {
+			warnings = append(warnings, string(line))
+		})).
+		Lines()
+	c.Assert(err, qt.IsNil)
+	c.Assert(lines, qt.HasLen, 3)
+	c.Assert(warnings, qt.DeepEquals, []string{"\xffa"})
+}
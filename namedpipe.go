@@ -0,0 +1,211 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/djherbis/nio/v3"
+)
+
+// fifoUnblockInterval is how often a pending open of a FIFO is interrupted once the
+// context is done, until the open returns.
+const fifoUnblockInterval = 10 * time.Millisecond
+
+// NamedPipe is a temporary FIFO or UNIX socket, for commands that refuse to read input
+// from stdin or write output to stdout, and insist on file arguments instead. Provide
+// Path to the command as an argument, and use Output to read what the command writes to
+// the pipe, or Feed to provide input for the command to read from the pipe.
+//
+// Each NamedPipe should only be used once, and removed with Remove when it is no longer
+// needed.
+type NamedPipe struct {
+	// Path is the path to the pipe, which should be provided to a command.
+	Path string
+
+	dir string
+	// listener is set if the pipe is a UNIX socket.
+	listener *net.UnixListener
+}
+
+// NewFIFO creates a NamedPipe backed by a FIFO in a temporary directory. FIFOs are not
+// supported on Windows.
+func NewFIFO() (*NamedPipe, error) {
+	dir, err := os.MkdirTemp("", "run-fifo-")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "fifo")
+	if err := mkfifo(path, 0o600); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create FIFO: %w", err)
+	}
+	return &NamedPipe{Path: path, dir: dir}, nil
+}
+
+// NewUnixSocket creates a NamedPipe backed by a UNIX socket in a temporary directory. The
+// pipe accepts a single connection from the command.
+func NewUnixSocket() (*NamedPipe, error) {
+	dir, err := os.MkdirTemp("", "run-sock-")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create UNIX socket: %w", err)
+	}
+	return &NamedPipe{Path: path, dir: dir, listener: listener}, nil
+}
+
+// Output provides what a command writes to the pipe as Output. Output is collected in
+// the background until the command closes the pipe, or ctx is done.
+func (p *NamedPipe) Output(ctx context.Context) Output {
+	buffer := makeUnboundedBuffer(ctx)
+	reader, writer := nio.Pipe(buffer)
+
+	transferCtx, cancel := context.WithCancel(ctx)
+	var (
+		closed int32
+		err    error
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		err = p.transfer(transferCtx, false, func(conn io.ReadWriter) error {
+			_, err := io.Copy(writer, conn)
+			return err
+		})
+		if transferCtx.Err() != nil {
+			err = terminationCause(ctx, atomic.LoadInt32(&closed) == 1)
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return newReaderOutput(ctx, reader,
+		func() error {
+			<-done
+			return err
+		},
+		func() {
+			atomic.StoreInt32(&closed, 1)
+			cancel()
+			<-done
+			reader.Close()
+			buffer.Reset()
+		})
+}
+
+// Feed writes input to the pipe in the background for a command to read. The returned
+// channel receives the result once all input has been written, or ctx is done.
+func (p *NamedPipe) Feed(ctx context.Context, input io.Reader) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- p.transfer(ctx, true, func(conn io.ReadWriter) error {
+			_, err := io.Copy(conn, input)
+			return err
+		})
+	}()
+	return result
+}
+
+// Remove removes the pipe.
+func (p *NamedPipe) Remove() error {
+	if p.listener != nil {
+		_ = p.listener.Close()
+	}
+	return os.RemoveAll(p.dir)
+}
+
+// transfer opens the pipe for reading or writing, and calls fn with the opened pipe. The
+// pipe is closed when ctx is done, which interrupts fn.
+func (p *NamedPipe) transfer(ctx context.Context, write bool, fn func(conn io.ReadWriter) error) error {
+	conn, err := p.open(ctx, write)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	err = fn(conn)
+	if closeErr := conn.Close(); err == nil && ctx.Err() == nil {
+		err = closeErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// open waits for a command to open the other end of the pipe, or for ctx to be done.
+func (p *NamedPipe) open(ctx context.Context, write bool) (io.ReadWriteCloser, error) {
+	type result struct {
+		conn io.ReadWriteCloser
+		err  error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		var r result
+		if p.listener != nil {
+			var conn net.Conn
+			if conn, r.err = p.listener.Accept(); r.err == nil {
+				r.conn = conn
+			}
+		} else {
+			flag := os.O_RDONLY
+			if write {
+				flag = os.O_WRONLY
+			}
+			var f *os.File
+			if f, r.err = os.OpenFile(p.Path, flag, 0); r.err == nil {
+				r.conn = f
+			}
+		}
+		opened <- r
+	}()
+
+	select {
+	case r := <-opened:
+		return r.conn, r.err
+
+	case <-ctx.Done():
+		// Interrupt the pending open, and make sure anything that was opened is closed.
+		// The deadline also applies to an Accept that has not started yet, but opening
+		// the other end of a FIFO only unblocks an open that is already pending, so
+		// keep doing so until the open returns.
+		if p.listener != nil {
+			_ = p.listener.SetDeadline(time.Now())
+		} else {
+			unblockFIFO(p.Path, write)
+		}
+		retry := time.NewTicker(fifoUnblockInterval)
+		defer retry.Stop()
+		for {
+			select {
+			case r := <-opened:
+				if r.err == nil {
+					r.conn.Close()
+				}
+				return nil, ctx.Err()
+			case <-retry.C:
+				if p.listener == nil {
+					unblockFIFO(p.Path, write)
+				}
+			}
+		}
+	}
+}
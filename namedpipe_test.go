@@ -0,0 +1,93 @@
+package run_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/sourcegraph/run"
+)
+
+func TestNamedPipe(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	newFIFO := func(c *qt.C) *run.NamedPipe {
+		if runtime.GOOS == "windows" {
+			c.Skip("FIFOs are not supported on Windows")
+		}
+		fifo, err := run.NewFIFO()
+		c.Assert(err, qt.IsNil)
+		c.Cleanup(func() { fifo.Remove() })
+		return fifo
+	}
+
+	c.Run("FIFO output", func(c *qt.C) {
+		fifo := newFIFO(c)
+		out := fifo.Output(ctx)
+
+		err := run.Bash(ctx, "echo hello > "+run.Arg(fifo.Path)).Run().Wait()
+		c.Assert(err, qt.IsNil)
+
+		res, err := out.String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(res, qt.Equals, "hello")
+	})
+
+	c.Run("FIFO feed", func(c *qt.C) {
+		fifo := newFIFO(c)
+		fed := fifo.Feed(ctx, strings.NewReader("hello\n"))
+
+		res, err := run.Cmd(ctx, "cat", run.Arg(fifo.Path)).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(res, qt.Equals, "hello")
+		c.Assert(<-fed, qt.IsNil)
+	})
+
+	c.Run("FIFO canceled", func(c *qt.C) {
+		// Each side uses its own FIFO, and nothing opens the other end of either, so
+		// only cancellation can end the pending opens.
+		c.Run("output", func(c *qt.C) {
+			fifo := newFIFO(c)
+			ctx, cancel := context.WithCancel(ctx)
+			out := fifo.Output(ctx)
+
+			cancel()
+			c.Assert(errors.Is(out.Wait(), run.ErrCanceled), qt.IsTrue)
+		})
+
+		c.Run("feed", func(c *qt.C) {
+			fifo := newFIFO(c)
+			ctx, cancel := context.WithCancel(ctx)
+			fed := fifo.Feed(ctx, strings.NewReader("hello\n"))
+
+			cancel()
+			c.Assert(errors.Is(<-fed, context.Canceled), qt.IsTrue)
+		})
+	})
+
+	c.Run("UNIX socket", func(c *qt.C) {
+		if _, err := exec.LookPath("python3"); err != nil {
+			c.Skip("python3 not available")
+		}
+		sock, err := run.NewUnixSocket()
+		c.Assert(err, qt.IsNil)
+		defer sock.Remove()
+
+		out := sock.Output(ctx)
+		err = run.Cmd(ctx, "python3 -c", run.Arg(`import socket, sys
+s = socket.socket(socket.AF_UNIX)
+s.connect(sys.argv[1])
+s.sendall(b"hello\n")
+s.close()`), run.Arg(sock.Path)).Run().Wait()
+		c.Assert(err, qt.IsNil)
+
+		res, err := out.String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(res, qt.Equals, "hello")
+	})
+}
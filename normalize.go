@@ -0,0 +1,82 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Placeholders written by the normalizing LineMaps in place of the values they replace.
+const (
+	TimestampPlaceholder = "<timestamp>"
+	DurationPlaceholder  = "<duration>"
+	TempPathPlaceholder  = "<tmp>"
+)
+
+var (
+	timestampPattern = regexp.MustCompile(
+		`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?|\b\d{2}:\d{2}:\d{2}(\.\d+)?\b`)
+	durationPattern = regexp.MustCompile(`\b(\d+(\.\d+)?(ns|us|µs|ms|h|m|s))+\b`)
+)
+
+// NormalizeTimestamps creates a LineMap that replaces dates with times, such as RFC3339
+// timestamps, and times of day in each line with TimestampPlaceholder.
+//
+// Like the other normalizing LineMaps, it is intended for comparing command output
+// against expected output in tests, and can be combined with other LineMaps using
+// Output.Map.
+func NormalizeTimestamps() LineMap {
+	return mapReplacePattern(timestampPattern, []byte(TimestampPlaceholder))
+}
+
+// NormalizeDurations creates a LineMap that replaces durations formatted like
+// time.Duration, such as "1.5s" or "2m30s", in each line with DurationPlaceholder.
+func NormalizeDurations() LineMap {
+	return mapReplacePattern(durationPattern, []byte(DurationPlaceholder))
+}
+
+// NormalizeTempPaths creates a LineMap that replaces occurrences of the given
+// directories in each line with TempPathPlaceholder, for example directories created
+// with (*testing.T).TempDir(). If no directories are provided, os.TempDir() is used.
+//
+// Directories are also matched with symbolic links resolved, since some platforms
+// provide temporary directories through a symbolic link.
+func NormalizeTempPaths(dirs ...string) LineMap {
+	if len(dirs) == 0 {
+		dirs = []string{os.TempDir()}
+	}
+	var replace [][]byte
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		// Replace resolved paths first, since they may contain the unresolved path.
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil && resolved != dir {
+			replace = append(replace, []byte(resolved))
+		}
+		replace = append(replace, []byte(dir))
+	}
+	placeholder := []byte(TempPathPlaceholder)
+	return func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+		for _, dir := range replace {
+			line = bytes.ReplaceAll(line, dir, placeholder)
+		}
+		return dst.Write(line)
+	}
+}
+
+// NormalizePathSeparators creates a LineMap that replaces the platform's path separator
+// in each line with a forward slash, similar to filepath.ToSlash. This allows expected
+// output to be shared between platforms.
+func NormalizePathSeparators() LineMap {
+	return func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+		return dst.Write([]byte(filepath.ToSlash(string(line))))
+	}
+}
+
+func mapReplacePattern(pattern *regexp.Regexp, replacement []byte) LineMap {
+	return func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+		return dst.Write(pattern.ReplaceAllLiteral(line, replacement))
+	}
+}
@@ -0,0 +1,95 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OOMKilled indicates if the command that produced err was likely killed by the kernel's
+// out-of-memory killer. This is only detected on Linux, based on the OOM kill counters
+// of the command's transient cgroup if it was run with Cgroup, or otherwise of the
+// memory cgroup of the current process, which commands typically share.
+func OOMKilled(err error) bool {
+	var oomKiller interface{ OOMKilled() bool }
+	return errors.As(err, &oomKiller) && oomKiller.OOMKilled()
+}
+
+// annotateOOMKill marks err as caused by the out-of-memory killer if the command was
+// killed with SIGKILL, including as reported by a shell with ShellSignalErrors, and
+// oomKilled reports an OOM kill. oomKilled is only called if the command was killed with
+// SIGKILL, and may be nil if OOM kills cannot be detected.
+func annotateOOMKill(err error, oomKilled func() bool) error {
+	if oomKilled == nil {
+		return err
+	}
+	var runErr *runError
+	if signal, _ := ExitSignal(err); signal != os.Kill || !errors.As(err, &runErr) {
+		return err
+	}
+	if oomKilled() {
+		runErr.oomKilled = true
+	}
+	return err
+}
+
+// sharedOOMKills tracks OOM kills in the memory cgroup of the current process.
+var sharedOOMKills = &oomKillTracker{count: oomKillCount}
+
+// oomKillTracker attributes increases of an OOM kill count, e.g. of the memory cgroup of
+// the current process, to commands killed with SIGKILL.
+type oomKillTracker struct {
+	// count returns the current OOM kill count, or -1 if it is not available.
+	count func() int64
+	once  sync.Once
+
+	mux sync.Mutex
+	// seen is the last OOM kill count observed, or -1 if it is not available.
+	seen int64
+}
+
+// start records the initial OOM kill count, the first time it is called. It should be
+// called before a command is started.
+func (t *oomKillTracker) start() {
+	t.once.Do(func() {
+		t.mux.Lock()
+		t.seen = t.count()
+		t.mux.Unlock()
+	})
+}
+
+// killed indicates if the OOM kill count has increased since it was last observed, and
+// should be called when a command is killed with SIGKILL. Each OOM kill is only
+// attributed to a single command.
+func (t *oomKillTracker) killed() bool {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if t.seen < 0 {
+		return false
+	}
+	count := t.count()
+	killed := count > t.seen
+	t.seen = count
+	return killed
+}
+
+// readOOMKillField reads the 'oom_kill' field from a cgroup file.
+func readOOMKillField(path string) (int64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, err := strconv.ParseInt(fields[1], 10, 64)
+			return count, err == nil
+		}
+	}
+	return 0, false
+}
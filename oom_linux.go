@@ -0,0 +1,46 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// oomKillCount returns the number of processes killed by the out-of-memory killer in the
+// memory cgroup of the current process, or -1 if it is not available.
+func oomKillCount() int64 {
+	return readOOMKillCount("/proc/self/cgroup", "/sys/fs/cgroup")
+}
+
+func readOOMKillCount(procCgroup, cgroupRoot string) int64 {
+	data, err := os.ReadFile(procCgroup)
+	if err != nil {
+		return -1
+	}
+
+	// Collect candidate files that report OOM kills, for cgroup v2 and v1 respectively.
+	var candidates []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			candidates = append(candidates,
+				filepath.Join(cgroupRoot, parts[2], "memory.events"))
+		case strings.Contains(","+parts[1]+",", ",memory,"):
+			candidates = append(candidates,
+				filepath.Join(cgroupRoot, "memory", parts[2], "memory.oom_control"),
+				// Within containers, the cgroup may be mounted at the root.
+				filepath.Join(cgroupRoot, "memory", "memory.oom_control"))
+		}
+	}
+
+	for _, path := range candidates {
+		if count, ok := readOOMKillField(path); ok {
+			return count
+		}
+	}
+	return -1
+}
@@ -0,0 +1,65 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestReadOOMKillCount(t *testing.T) {
+	c := qt.New(t)
+
+	write := func(c *qt.C, path, content string) {
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0o755), qt.IsNil)
+		c.Assert(os.WriteFile(path, []byte(content), 0o644), qt.IsNil)
+	}
+
+	c.Run("cgroup v2", func(c *qt.C) {
+		dir := c.TempDir()
+		write(c, filepath.Join(dir, "cgroup"), "0::/user.slice/session-1.scope\n")
+		write(c, filepath.Join(dir, "fs", "user.slice", "session-1.scope", "memory.events"),
+			"low 0\nhigh 0\nmax 2\noom 1\noom_kill 3\n")
+
+		c.Assert(readOOMKillCount(filepath.Join(dir, "cgroup"), filepath.Join(dir, "fs")), qt.Equals, int64(3))
+	})
+
+	c.Run("cgroup v1", func(c *qt.C) {
+		dir := c.TempDir()
+		write(c, filepath.Join(dir, "cgroup"), "5:devices:/\n4:memory:/docker/abc\n0::/\n")
+		write(c, filepath.Join(dir, "fs", "memory", "memory.oom_control"),
+			"oom_kill_disable 0\nunder_oom 0\noom_kill 2\n")
+
+		c.Assert(readOOMKillCount(filepath.Join(dir, "cgroup"), filepath.Join(dir, "fs")), qt.Equals, int64(2))
+	})
+
+	c.Run("unavailable", func(c *qt.C) {
+		dir := c.TempDir()
+		write(c, filepath.Join(dir, "cgroup"), "0::/\n")
+
+		c.Assert(readOOMKillCount(filepath.Join(dir, "cgroup"), filepath.Join(dir, "fs")), qt.Equals, int64(-1))
+	})
+}
+
+func TestOOMKillTracker(t *testing.T) {
+	c := qt.New(t)
+
+	count := int64(1)
+	tracker := &oomKillTracker{count: func() int64 { return count }}
+	tracker.start()
+	c.Assert(tracker.killed(), qt.IsFalse)
+
+	// The baseline is only recorded once.
+	count = 2
+	tracker.start()
+	c.Assert(tracker.killed(), qt.IsTrue)
+	// Each OOM kill is only attributed once.
+	c.Assert(tracker.killed(), qt.IsFalse)
+
+	c.Run("unavailable", func(c *qt.C) {
+		tracker := &oomKillTracker{count: func() int64 { return -1 }}
+		tracker.start()
+		c.Assert(tracker.killed(), qt.IsFalse)
+	})
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package run
+
+// oomKillCount is not supported on this platform, and always returns -1.
+func oomKillCount() int64 { return -1 }
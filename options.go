@@ -0,0 +1,55 @@
+package run
+
+import (
+	"context"
+	"io"
+)
+
+// Option configures a Command, for callers that assemble configuration programmatically,
+// for example in slices or conditionals, where chaining builder functions is awkward.
+// Any builder function can be used as an Option with a function literal:
+//
+//	run.New(ctx, "git", run.WithArgs("status"), func(c *run.Command) { c.StdOut() })
+type Option func(c *Command)
+
+// New builds a command that executes name, configured with the given options. Like
+// CmdStrict, name and arguments provided with WithArgs are not split.
+func New(ctx context.Context, name string, opts ...Option) *Command {
+	return CmdStrict(ctx, name).With(opts...)
+}
+
+// With applies the given options to the command.
+func (c *Command) With(opts ...Option) *Command {
+	for _, opt := range opts {
+		if opt != nil {
+			opt(c)
+		}
+	}
+	return c
+}
+
+// WithArgs appends the given arguments verbatim, as with (*Command).Args.
+func WithArgs(args ...string) Option {
+	return func(c *Command) { c.Args(args...) }
+}
+
+// WithDir sets the directory the command is executed in, as with (*Command).Dir.
+func WithDir(dir string) Option {
+	return func(c *Command) { c.Dir(dir) }
+}
+
+// WithEnv sets environment variables for the command, as with (*Command).Env.
+func WithEnv(env map[string]string) Option {
+	return func(c *Command) { c.Env(env) }
+}
+
+// WithEnviron sets environment variables in the form "key=value" for the command, as
+// with (*Command).Environ.
+func WithEnviron(environ []string) Option {
+	return func(c *Command) { c.Environ(environ) }
+}
+
+// WithInput provides input to the command, as with (*Command).Input.
+func WithInput(input io.Reader) Option {
+	return func(c *Command) { c.Input(input) }
+}
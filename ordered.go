@@ -0,0 +1,25 @@
+package run
+
+import "context"
+
+const contextKeyOrderedOutput contextKey = "orderedOutput"
+
+// OrderedOutput configures all usages of sourcegraph/run within this context to collect
+// combined output in strict order of arrival. Stdout and stderr of each command are
+// attached to a single pipe, so output written by the command is never reordered by
+// the goroutines that copy stdout and stderr independently. This is useful for tests
+// that compare combined output against expected values.
+//
+// Because stdout and stderr cannot be told apart in this mode, errors from commands
+// that collect combined output include all output rather than only stderr. It has no
+// effect on commands that do not collect both stdout and stderr into Output, such as
+// those configured with StdOut, StdErr, or Attach.
+func OrderedOutput(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyOrderedOutput, true)
+}
+
+// isOrderedOutput indicates if OrderedOutput is configured in ctx.
+func isOrderedOutput(ctx context.Context) bool {
+	v, _ := ctx.Value(contextKeyOrderedOutput).(bool)
+	return v
+}
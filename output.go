@@ -1,12 +1,18 @@
 package run
 
 import (
+	"bufio"
 	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"io"
+	"os"
 	"os/exec"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/djherbis/nio/v3"
 	"go.bobheadxi.dev/streamline"
@@ -17,6 +23,14 @@ import (
 
 // Output configures output and aggregation from a command.
 //
+// Functions that consume output should not be called concurrently - if they are, all but
+// one call returns ErrConcurrentConsumption.
+//
+// Functions that consume output behave consistently when errors occur: output collected
+// before an error is returned along with the error, and if the command has exited with
+// an error, for example because its context is done, that error is returned in
+// preference to errors from processing output, such as errors from a LineMap or JQ.
+//
 // It is behind an interface to more easily enable mock outputs and build different types
 // of outputs, such as multi-outputs and error-only outputs, without complicating the core
 // commandOutput implementation.
@@ -36,6 +50,13 @@ type Output interface {
 	// TODO wishlist functionality
 	// Mode(mode OutputMode) Output
 
+	// Detect inspects the first few kilobytes of output, without consuming it, to
+	// determine the format of the output. It blocks until enough output is available or
+	// the command exits, and should be called before output is consumed.
+	//
+	// This is useful for routing output to the appropriate decoder, e.g. JQ for JSON.
+	Detect() (Format, error)
+
 	// Stream writes mapped output from the command to the destination writer until
 	// command completion.
 	Stream(dst io.Writer) error
@@ -49,17 +70,45 @@ type Output interface {
 	// single string.
 	String() (string, error)
 	// JQ waits for command completion executes a JQ query against the entire output.
+	// If the output consists of multiple JSON values, e.g. newline-delimited JSON, the
+	// query is executed against each value. Output is decoded incrementally, and queries
+	// that begin by iterating over a top-level array, e.g. '.[] | .foo', are executed
+	// against each element as it is decoded.
 	//
 	// Refer to https://github.com/itchyny/gojq for the specifics of supported syntax.
 	JQ(query string) ([]byte, error)
+	// PrettyJSON waits for command completion and writes each JSON value from the output
+	// to dst, indented and optionally colorized for display in a terminal. Key order is
+	// preserved.
+	PrettyJSON(dst io.Writer, colorize bool) error
+	// StoreTo writes mapped output from the command to a new blob in blobs until command
+	// completion, without buffering it in memory, and returns the hex-encoded SHA-256
+	// digest of the output. If the command fails, output collected before the error is
+	// still stored and its digest is returned along with the error.
+	StoreTo(blobs BlobStore) (digest string, err error)
 	// Reader is implemented so that Output can be provided directly to another Command
 	// using Input().
 	io.Reader
 	// WriterTo is implemented for convenience when chaining commands in LineMap.
 	io.WriterTo
 
+	// BytesRead returns the number of bytes of output read from the command so far,
+	// before any Map or Pipeline is applied. It is safe to call while output is being
+	// consumed, for example to report throughput, and reflects all output once output
+	// has been consumed.
+	BytesRead() int64
+	// LinesEmitted returns the number of lines of output read from the command so far,
+	// before any Map or Pipeline is applied. Like BytesRead, it is safe to call while
+	// output is being consumed.
+	LinesEmitted() int64
+
 	// Wait waits for command completion and returns.
 	Wait() error
+	// Close terminates the command if it is still running, discards any remaining
+	// output, and releases resources held for buffering output. It is safe to call Close
+	// after output has been consumed, for example with defer, but it should not be called
+	// concurrently with other functions on Output.
+	Close() error
 }
 
 // commandOutput is the core Output implementation, designed to be attached to an exec.Cmd.
@@ -75,37 +124,193 @@ type commandOutput struct {
 	// stream is the underlying output aggregation implementation. It reads from a
 	// read side of a pipe which receives output from a command.
 	stream *streamline.Stream
+	// source is the buffered read side of the pipe, which allows for peeking at output.
+	source *bufio.Reader
+	// budget wraps the reader underlying stream, and is used to enforce memory budgets
+	// when aggregating output.
+	budget *budgetedReader
 
-	// waitAndCloseFunc should only be called via doWaitOnce(). It should wait for command
+	// state is the outputState of this output, and should only be accessed via
+	// transition() and getState().
+	state int32
+
+	// waitAndCloseFunc should only be called via waitAndClose(). It should wait for command
 	// exit and handle setting an error such that once reads from reader are complete, the
 	// reader should return the error from the command.
 	waitAndCloseFunc func() error
-	waitAndCloseOnce sync.Once
+	// waitAndCloseDone is closed when waitAndCloseFunc returns, once the state is
+	// outputDone.
+	waitAndCloseDone chan struct{}
+	// waitErr is the error returned by waitAndCloseFunc, and should only be read once
+	// waitAndCloseDone is closed.
+	waitErr error
+
+	// closeFunc should only be called via Close(). It should terminate the command and
+	// release buffers.
+	closeFunc func()
+	closeOnce sync.Once
+	// closed is set to 1 when closeFunc is called.
+	closed int32
+
+	// leaks, if set, tracks whether this output has been consumed.
+	leaks *LeakDetector
+
+	// consuming is set to 1 while a function is consuming output, and should only be
+	// accessed via acquire() and release().
+	consuming int32
 }
 
 var _ Output = &commandOutput{}
 
-type attachedOutput int
+// outputState denotes the lifecycle of a commandOutput. States only move forward:
+//
+//	outputRunning -> outputWaiting -> outputDone
+//
+// Output can be consumed in any state, but only one function can consume output at a
+// time - see acquire(). Close can be called in any state.
+type outputState int32
 
 const (
-	attachCombined   attachedOutput = 0
-	attachOnlyStdOut attachedOutput = 1
-	attachOnlyStdErr attachedOutput = 2
+	// outputRunning indicates the command has started, and nothing is waiting for it to
+	// exit yet.
+	outputRunning outputState = iota
+	// outputWaiting indicates waitAndCloseFunc is waiting for the command to exit,
+	// typically because output is being consumed.
+	outputWaiting
+	// outputDone indicates the command has exited, and waitErr is set.
+	outputDone
 )
 
+// transition moves the output from state from to state to, and returns false if the
+// output is not in state from.
+func (o *commandOutput) transition(from, to outputState) bool {
+	return atomic.CompareAndSwapInt32(&o.state, int32(from), int32(to))
+}
+
+// getState returns the current state of the output.
+func (o *commandOutput) getState() outputState {
+	return outputState(atomic.LoadInt32(&o.state))
+}
+
+// execOptions configures how attachAndRun executes a command.
+type execOptions struct {
+	attachOutput AttachSpec
+	attachInput  io.Reader
+	// stderrTail, if set, is the number of bytes at the end of stderr that are retained
+	// for errors. By default, all of stderr is retained.
+	stderrTail int
+	// inputs are the readers that make up attachInput.
+	inputs []io.Reader
+	// password, if set, is written to stdin ahead of attachInput, and is never
+	// captured.
+	password string
+	// inputClosers are closed once the command exits or fails to start.
+	inputClosers []io.Closer
+	// stdinPipe, if set, is the read end of a pipe created by StdinPipe.
+	stdinPipe *os.File
+
+	// shutdown configures how the command is terminated when its context is done.
+	shutdown gracefulShutdown
+	// prepare are applied to the underlying exec.Cmd before it is started.
+	prepare []func(cmd *exec.Cmd) error
+	// sysProcAttr, if set, is copied to the underlying exec.Cmd before prepare is
+	// applied.
+	sysProcAttr *syscall.SysProcAttr
+	// timeout, if set, is how long the command may run before it is terminated.
+	timeout time.Duration
+	// deadline, if set, is when the command is terminated if it is still running.
+	deadline time.Time
+	// forwardSignals are relayed to the command while it is running.
+	forwardSignals []os.Signal
+	// destructive indicates the command must be approved before it is started.
+	destructive bool
+	// exitCodes maps exit codes to errors to return instead.
+	exitCodes map[int]error
+	// oomKilled, if set, indicates if the command was killed by the out-of-memory killer
+	// once it has been killed with SIGKILL. By default, OOM kills in the memory cgroup of
+	// the current process are attributed to the command.
+	oomKilled func() bool
+	// inheritEnv indicates if the command inherits the current process's environment.
+	inheritEnv bool
+	// unsetEnv are variables removed from the inherited environment.
+	unsetEnv []string
+	// beforeStart are called before the command is started.
+	beforeStart []func(cmd *ExecutedCommand) error
+	// afterExit are called once the command has exited or failed to start.
+	afterExit []func(result ExecutedCommandResult)
+}
+
+// timeoutAfter returns how long a command started at startedAt may run, if a timeout or
+// deadline is configured.
+func (o execOptions) timeoutAfter(startedAt time.Time) (time.Duration, bool) {
+	var after time.Duration
+	ok := false
+	if o.timeout > 0 {
+		after, ok = o.timeout, true
+	}
+	if !o.deadline.IsZero() {
+		if untilDeadline := o.deadline.Sub(startedAt); !ok || untilDeadline < after {
+			after, ok = untilDeadline, true
+		}
+	}
+	return after, ok
+}
+
 // attachOutputAndRun is called by (*Command).Run() to start command execution and collect
 // command output.
 func attachAndRun(
 	ctx context.Context,
-	attachOutput attachedOutput,
-	attachInput io.Reader,
+	opts execOptions,
 	executedCmd ExecutedCommand,
-) Output {
-	// Set up command
-	cmd := exec.CommandContext(ctx, executedCmd.Args[0], executedCmd.Args[1:]...)
+) (*Process, error) {
+	if len(opts.beforeStart) > 0 {
+		// Hooks may modify the command, so make sure the Command is not modified.
+		executedCmd.Args = cloneStrings(executedCmd.Args)
+		executedCmd.Environ = cloneStrings(executedCmd.Environ)
+		executedCmd.Unsetenv = cloneStrings(executedCmd.Unsetenv)
+	}
+	for _, hook := range opts.beforeStart {
+		if err := hook(&executedCmd); err != nil {
+			if opts.stdinPipe != nil {
+				_ = opts.stdinPipe.Close()
+			}
+			return nil, err
+		}
+	}
+	if len(executedCmd.Args) == 0 {
+		return nil, errors.New("Command not instantiated")
+	}
+
+	// Set up command - we handle context cancellation ourselves so that we can terminate
+	// the command's entire process tree where supported.
+	cmd := exec.Command(executedCmd.Args[0], executedCmd.Args[1:]...)
 	cmd.Dir = executedCmd.Dir
-	cmd.Env = executedCmd.Environ
-	cmd.Stdin = attachInput
+	cmd.Env = commandEnv(executedCmd.Environ, opts.unsetEnv, opts.inheritEnv)
+	cmd.SysProcAttr = newSysProcAttr(opts.sysProcAttr)
+	if isKillProcessGroups(ctx) || len(opts.forwardSignals) > 0 {
+		setNewProcessGroup(sysProcAttr(cmd))
+	}
+	cmd.Stdin = opts.attachInput
+
+	// Capture input before it is consumed by the command.
+	if capture := getInputCapture(ctx); capture != nil && cmd.Stdin != nil &&
+		canCapture(opts.inputs) {
+		executedCmd.Input, cmd.Stdin = capture.capture(cmd.Stdin)
+	}
+	if opts.password != "" {
+		password := strings.NewReader(opts.password + "\n")
+		if cmd.Stdin != nil {
+			cmd.Stdin = io.MultiReader(password, cmd.Stdin)
+		} else {
+			cmd.Stdin = password
+		}
+	}
+
+	// Redact secrets from everything that reports on the command from here on
+	secrets := getSecretRegistry(ctx)
+	if secrets != nil {
+		executedCmd = secrets.redactCommand(executedCmd)
+	}
 
 	// Prepare tracing
 	tracer, attrs := getTracer(ctx)
@@ -116,49 +321,171 @@ func attachAndRun(
 
 	// Set up buffers for output and errors - we need to retain a copy of stderr for error
 	// creation.
-	var outputBuffer, stderrCopy = makeUnboundedBuffer(), makeUnboundedBuffer()
+	var outputBuffer, stderrCopy = makeUnboundedBuffer(ctx), makeUnboundedBuffer(ctx)
+	if opts.stderrTail > 0 {
+		stderrCopy = &tailBuffer{size: opts.stderrTail}
+	}
 
 	// We use this buffered pipe from github.com/djherbis/nio that allows async read and
 	// write operations to the reader and writer portions of the pipe respectively.
 	outputReader, outputWriter := nio.Pipe(outputBuffer)
 
 	// Set up output hooks
-	switch attachOutput {
-	case attachCombined:
-		cmd.Stdout = outputWriter
-		cmd.Stderr = io.MultiWriter(stderrCopy, outputWriter)
-
-	case attachOnlyStdOut:
-		cmd.Stdout = outputWriter
-		cmd.Stderr = stderrCopy
-
-	case attachOnlyStdErr:
-		cmd.Stdout = nil // discard
-		cmd.Stderr = io.MultiWriter(stderrCopy, outputWriter)
-
-	default:
-		err := fmt.Errorf("unexpected attach type %d", attachOutput)
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "")
-		span.End()
-		return NewErrorOutput(err)
+	cmd.Stdout, cmd.Stderr = opts.attachOutput.writers(outputWriter, stderrCopy, isOrderedOutput(ctx))
+
+	// Approve the command before it is logged or recorded, so that commands that are
+	// declined are never reported as executed. Commands are not approved in dry runs,
+	// since they are not run.
+	var err error
+	if !isDryRun(ctx) {
+		err = ctx.Err()
+		if err == nil && opts.destructive {
+			err = approve(ctx, executedCmd)
+		}
 	}
 
 	// Log and start command execution
-	if log := getLogger(ctx); log != nil {
-		log(executedCmd)
+	var history *CommandHistory
+	var historyIndex int
+	if err == nil {
+		if log := getLogger(ctx); log != nil {
+			log(executedCmd)
+		}
+		if registry := getCommandRegistry(ctx); registry != nil {
+			registry.record(executedCmd)
+		}
+		if history = getCommandHistory(ctx); history != nil {
+			historyIndex = history.record(executedCmd)
+		}
+	}
+	if isDryRun(ctx) {
+		if opts.stdinPipe != nil {
+			_ = opts.stdinPipe.Close()
+		}
+		for _, c := range opts.inputClosers {
+			_ = c.Close()
+		}
+		span.End()
+		return &Process{output: newBufferedOutput(ctx, []byte(executedCmd.commandLine()), nil)}, nil
+	}
+	var tree processTree
+	for _, p := range opts.prepare {
+		if err != nil {
+			break
+		}
+		err = p(cmd)
+	}
+	oomKilled := opts.oomKilled
+	if oomKilled == nil {
+		sharedOOMKills.start()
+		oomKilled = sharedOOMKills.killed
+	}
+	startedAt := getClock(ctx).Now()
+	afterExit := func(err error) {
+		result := ExecutedCommandResult{
+			Command:   executedCmd,
+			Err:       err,
+			StartedAt: startedAt,
+			Duration:  getClock(ctx).Now().Sub(startedAt),
+		}
+		if history != nil && err != nil {
+			history.recordError(historyIndex, err)
+		}
+		for _, hook := range opts.afterExit {
+			hook(result)
+		}
+	}
+	if err == nil {
+		tree, err = startProcessTree(cmd, opts.shutdown)
+	}
+	if opts.stdinPipe != nil {
+		if err != nil || cmd.Stdin == opts.stdinPipe {
+			// The command has its own copy of the read end of the pipe, so closing ours
+			// ensures writes fail once the command exits.
+			_ = opts.stdinPipe.Close()
+		} else {
+			// The pipe is copied to the command, so it can only be closed once the
+			// command exits.
+			opts.inputClosers = append(opts.inputClosers, opts.stdinPipe)
+		}
 	}
-	if err := cmd.Start(); err != nil {
-		err := fmt.Errorf("failed to start command: %w", err)
+	if err != nil {
+		for _, c := range opts.inputClosers {
+			_ = c.Close()
+		}
+		err := renderError(ctx, executedCmd, &startError{err: err})
+		afterExit(err)
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "")
 		span.End()
-		return NewErrorOutput(err)
+		return nil, err
 	}
 
+	// Terminate the command if the context is done, or the program is interrupted,
+	// before the command exits.
+	exited := make(chan struct{})
+	var interrupted <-chan struct{}
+	untrack := func() {}
+	if cleanup := getCleanupRegistry(ctx); cleanup != nil {
+		interrupted, untrack = cleanup.track()
+	}
+	forwardSignals(opts.forwardSignals, tree, exited)
+	var timeout <-chan time.Time
+	if d, ok := opts.timeoutAfter(startedAt); ok {
+		timeout = getClock(ctx).After(d)
+	}
+	// canceled and timedOut are set to 1 if the command is terminated because its
+	// context is done or it times out respectively, and should be read once exited is
+	// closed.
+	var canceled, timedOut int32
+	go func() {
+		defer untrack()
+		defer tree.release()
+		var terminatedBy *int32
+		select {
+		case <-ctx.Done():
+			terminatedBy = &canceled
+		case <-interrupted:
+		case <-timeout:
+			terminatedBy = &timedOut
+		case <-exited:
+			return
+		}
+		select {
+		case <-exited:
+			return // the command has already exited on its own
+		default:
+		}
+		if terminatedBy != nil {
+			atomic.StoreInt32(terminatedBy, 1)
+		}
+		opts.shutdown.terminate(getClock(ctx), tree, exited)
+	}()
+
+	// Wait for the command in the background, so that exited is closed as soon as the
+	// command exits and it is not terminated, or blamed on its context, afterwards.
+	var waitErr error
+	go func() {
+		waitErr = cmd.Wait()
+		for _, c := range opts.inputClosers {
+			_ = c.Close()
+		}
+		close(exited)
+	}()
+
+	source := bufio.NewReader(outputReader)
+	budget := &budgetedReader{reader: source, budget: getMemoryBudget(ctx)}
 	output := &commandOutput{
 		ctx:    ctx,
-		stream: streamline.New(outputReader),
+		stream: newStream(ctx, budget),
+		source: source,
+		budget: budget,
+
+		waitAndCloseDone: make(chan struct{}),
+	}
+	if leaks := getLeakDetector(ctx); leaks != nil {
+		output.leaks = leaks
+		leaks.track(output, executedCmd.Args)
 	}
 
 	output.waitAndCloseFunc = func() error {
@@ -166,7 +493,26 @@ func attachAndRun(
 		// and all resources are closed.
 		defer span.End()
 
-		err := newError(cmd.Wait(), stderrCopy)
+		<-exited
+
+		var cause error
+		switch {
+		case atomic.LoadInt32(&output.closed) == 1:
+			cause = ErrClosed
+		case atomic.LoadInt32(&timedOut) == 1:
+			cause = ErrTimeout
+		case atomic.LoadInt32(&canceled) == 1:
+			cause = terminationCause(ctx, false)
+		}
+		err := newError(waitErr, stderrCopy, cause)
+		if secrets != nil {
+			err = secrets.redactError(err)
+		}
+		err = mapExitCode(err, opts.exitCodes)
+		err = annotateOOMKill(err, oomKilled)
+		err = classifyError(err, getErrorRules(ctx))
+		err = renderError(ctx, executedCmd, err)
+		afterExit(err)
 		span.AddEvent("Done") // add done event because some time may elapse before span end
 		if err != nil {
 			span.RecordError(err)
@@ -180,7 +526,21 @@ func attachAndRun(
 		return err
 	}
 
-	return output
+	output.closeFunc = func() {
+		// Discard any further output, and make sure the command exits.
+		atomic.StoreInt32(&output.closed, 1)
+		outputReader.Close()
+		_ = tree.kill()
+
+		go output.waitAndClose()
+		<-output.waitAndCloseDone
+
+		// Release buffers, which may have overflowed to disk.
+		outputBuffer.Reset()
+		stderrCopy.Reset()
+	}
+
+	return &Process{process: cmd.Process, tree: tree, exited: exited, output: output}, nil
 }
 
 func (o *commandOutput) Map(f LineMap) Output {
@@ -196,6 +556,17 @@ func (o *commandOutput) Pipeline(p pipeline.Pipeline) Output {
 	return o
 }
 
+func (o *commandOutput) Detect() (format Format, err error) {
+	err = o.consume("Detect", false, func() (err error) {
+		format, err = peekFormat(o.source)
+		return err
+	})
+	if err != nil && format == "" {
+		format = FormatText
+	}
+	return format, err
+}
+
 func (o *commandOutput) Stream(dst io.Writer) error {
 	trace.SpanFromContext(o.ctx).AddEvent("Stream")
 
@@ -204,60 +575,130 @@ func (o *commandOutput) Stream(dst io.Writer) error {
 }
 
 func (o *commandOutput) StreamLines(dst func(line string)) error {
-	trace.SpanFromContext(o.ctx).AddEvent("StreamLines")
-
-	go o.waitAndClose()
-
-	return o.stream.Stream(dst)
+	return o.consume("StreamLines", false, func() error {
+		return o.stream.Stream(dst)
+	})
 }
 
-func (o *commandOutput) Lines() ([]string, error) {
-	trace.SpanFromContext(o.ctx).AddEvent("Lines")
-
-	go o.waitAndClose()
-
-	return o.stream.Lines()
+func (o *commandOutput) Lines() (lines []string, err error) {
+	err = o.consume("Lines", true, func() (err error) {
+		lines, err = o.stream.Lines()
+		return err
+	})
+	return lines, err
 }
 
-func (o *commandOutput) JQ(query string) ([]byte, error) {
-	trace.SpanFromContext(o.ctx).AddEvent("JQ")
-
-	jqCode, err := buildJQ(query)
+func (o *commandOutput) JQ(query string) (res []byte, err error) {
+	jq, err := buildJQ(query)
 	if err != nil {
 		// Record this error because it is not related to reading/writing
 		trace.SpanFromContext(o.ctx).RecordError(err)
 		return nil, err
 	}
 
-	return execJQ(o.ctx, jqCode, o)
+	err = o.consume("JQ", true, func() (err error) {
+		res, err = execJQ(o.ctx, jq, o.stream)
+		return err
+	})
+	return res, err
 }
 
-func (o *commandOutput) String() (string, error) {
-	trace.SpanFromContext(o.ctx).AddEvent("String")
-
-	go o.waitAndClose()
-
-	return o.stream.String()
+func (o *commandOutput) PrettyJSON(dst io.Writer, colorize bool) error {
+	return o.consume("PrettyJSON", false, func() error {
+		return writePrettyJSON(dst, o.stream, colorize)
+	})
 }
 
-func (o *commandOutput) Read(p []byte) (int, error) {
-	trace.SpanFromContext(o.ctx).AddEvent("Read")
-
-	go o.waitAndClose()
+func (o *commandOutput) String() (str string, err error) {
+	err = o.consume("String", true, func() (err error) {
+		str, err = o.stream.String()
+		return err
+	})
+	return str, err
+}
 
-	return o.stream.Read(p)
+func (o *commandOutput) Read(p []byte) (n int, err error) {
+	err = o.consume("Read", false, func() (err error) {
+		n, err = o.stream.Read(p)
+		return err
+	})
+	return n, err
 }
 
 // WriteTo implements io.WriterTo, and returns int64 instead of int because of:
 // https://stackoverflow.com/questions/29658892/why-does-io-writertos-writeto-method-return-an-int64-rather-than-an-int
-func (o *commandOutput) WriteTo(dst io.Writer) (int64, error) {
-	trace.SpanFromContext(o.ctx).AddEvent("WriteTo")
+func (o *commandOutput) WriteTo(dst io.Writer) (n int64, err error) {
+	err = o.consume("WriteTo", false, func() (err error) {
+		n, err = o.stream.WriteTo(dst)
+		return err
+	})
+	return n, err
+}
+
+func (o *commandOutput) StoreTo(blobs BlobStore) (digest string, err error) {
+	err = o.consume("StoreTo", false, func() error {
+		digest, err = storeBlob(blobs, func(dst io.Writer) error {
+			_, err := o.stream.WriteTo(dst)
+			return err
+		})
+		return err
+	})
+	return digest, err
+}
+
+// consume calls f, which should consume output from o.stream, such that all functions
+// that consume output behave consistently:
+//
+//   - only one function can consume output at a time, and other callers get
+//     ErrConcurrentConsumption
+//   - the command is waited on in the background, so that output is complete once the
+//     command exits
+//   - if aggregate is true, output is subject to the memory budget, and the command is
+//     terminated if the budget is exceeded
+//   - if f fails, for example because a LineMap returned an error, but the command has
+//     exited with an error or its context is done, the command's error is returned
+//     instead
+func (o *commandOutput) consume(event string, aggregate bool, f func() error) error {
+	trace.SpanFromContext(o.ctx).AddEvent(event)
+
+	if !o.acquire() {
+		return ErrConcurrentConsumption
+	}
+	defer o.release()
 
 	go o.waitAndClose()
 
-	return o.stream.WriteTo(dst)
+	if aggregate {
+		o.budget.aggregating = true
+	}
+	err := f()
+	if aggregate {
+		err = o.budget.checkError(err)
+		if o.budget.exceeded {
+			// Terminate the command and release buffers, since output would otherwise
+			// continue to be buffered.
+			_ = o.Close()
+			return err
+		}
+	}
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	if o.ctx.Err() != nil {
+		// The command is being terminated, so wait for its error.
+		<-o.waitAndCloseDone
+	}
+	if o.getState() == outputDone && o.waitErr != nil {
+		return o.waitErr
+	}
+	return err
 }
 
+func (o *commandOutput) BytesRead() int64 { return o.budget.bytesRead() }
+
+func (o *commandOutput) LinesEmitted() int64 { return o.budget.linesRead() }
+
 func (o *commandOutput) Wait() error {
 	trace.SpanFromContext(o.ctx).AddEvent("Wait")
 
@@ -268,11 +709,36 @@ func (o *commandOutput) Wait() error {
 // callers do not need to use the returned error - operations that read from o.reader
 // should return the error from that instead, which in most cases should be the same error.
 func (o *commandOutput) waitAndClose() error {
-	// If err is not reset by waitAndCloseOnce.Do, then output has already been consumed,
-	// and we raise this default error.
-	err := fmt.Errorf("output has already been consumed")
-	o.waitAndCloseOnce.Do(func() {
-		err = o.waitAndCloseFunc()
-	})
-	return err
+	if o.leaks != nil {
+		o.leaks.release(o)
+	}
+
+	if !o.transition(outputRunning, outputWaiting) {
+		// Another caller is waiting or has waited for the command, so the result of the
+		// command has already been consumed.
+		<-o.waitAndCloseDone
+		return ErrAlreadyConsumed
+	}
+	defer close(o.waitAndCloseDone)
+	o.waitErr = o.waitAndCloseFunc()
+	o.transition(outputWaiting, outputDone)
+	return o.waitErr
+}
+
+// acquire marks the output as being consumed, and returns false if output is already
+// being consumed by another caller.
+func (o *commandOutput) acquire() bool {
+	return atomic.CompareAndSwapInt32(&o.consuming, 0, 1)
+}
+
+// release should be called when a function is done consuming output after acquire().
+func (o *commandOutput) release() {
+	atomic.StoreInt32(&o.consuming, 0)
+}
+
+func (o *commandOutput) Close() error {
+	trace.SpanFromContext(o.ctx).AddEvent("Close")
+
+	o.closeOnce.Do(o.closeFunc)
+	return nil
 }
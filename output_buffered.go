@@ -0,0 +1,72 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// newBufferedOutput creates an Output that provides output that has already been
+// collected, for example from a previous execution of a command. Once data has been
+// consumed, err is returned if it is set.
+func newBufferedOutput(ctx context.Context, data []byte, err error) Output {
+	return newReaderOutput(ctx,
+		io.MultiReader(bytes.NewReader(data), errorReader{err: err}),
+		func() error { return err },
+		func() {})
+}
+
+// newReaderOutput creates an Output that provides output from reader, which should
+// return the final error once all output has been read. wait should block until no more
+// output will be produced and return the final error, and close should stop output from
+// being produced.
+func newReaderOutput(ctx context.Context, reader io.Reader, wait func() error, close func()) *commandOutput {
+	source := bufio.NewReader(reader)
+	budget := &budgetedReader{reader: source, budget: getMemoryBudget(ctx)}
+	output := &commandOutput{
+		ctx:    ctx,
+		stream: newStream(ctx, budget),
+		source: source,
+		budget: budget,
+
+		waitAndCloseFunc: wait,
+		waitAndCloseDone: make(chan struct{}),
+	}
+	output.closeFunc = func() {
+		atomic.StoreInt32(&output.closed, 1)
+		close()
+		_ = output.waitAndClose()
+	}
+	return output
+}
+
+// errorReader is an io.Reader that always returns err, or io.EOF if err is nil.
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
+
+// exitStatusError denotes the exit code and stderr of a command that was not executed by
+// this process, for example if its result was recorded previously.
+type exitStatusError struct {
+	code   int
+	stderr []byte
+}
+
+var _ ExitCoder = &exitStatusError{}
+
+func (e *exitStatusError) Error() string {
+	if len(e.stderr) == 0 {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return fmt.Sprintf("exit status %d: %s", e.code, string(e.stderr))
+}
+
+func (e *exitStatusError) ExitCode() int { return e.code }
@@ -18,12 +18,19 @@ func (o *errorOutput) StdOut() Output                    { return o }
 func (o *errorOutput) Map(LineMap) Output                { return o }
 func (o *errorOutput) Pipeline(pipeline.Pipeline) Output { return o }
 
-func (o *errorOutput) Stream(io.Writer) error           { return o.err }
-func (o *errorOutput) StreamLines(func(string)) error   { return o.err }
-func (o *errorOutput) Lines() ([]string, error)         { return nil, o.err }
-func (o *errorOutput) String() (string, error)          { return "", o.err }
-func (o *errorOutput) JQ(string) ([]byte, error)        { return nil, o.err }
-func (o *errorOutput) Read([]byte) (int, error)         { return 0, o.err }
-func (o *errorOutput) WriteTo(io.Writer) (int64, error) { return 0, o.err }
-
-func (o *errorOutput) Wait() error { return o.err }
+func (o *errorOutput) Detect() (Format, error)           { return FormatText, o.err }
+func (o *errorOutput) Stream(io.Writer) error            { return o.err }
+func (o *errorOutput) StreamLines(func(string)) error    { return o.err }
+func (o *errorOutput) Lines() ([]string, error)          { return nil, o.err }
+func (o *errorOutput) String() (string, error)           { return "", o.err }
+func (o *errorOutput) JQ(string) ([]byte, error)         { return nil, o.err }
+func (o *errorOutput) PrettyJSON(io.Writer, bool) error  { return o.err }
+func (o *errorOutput) StoreTo(BlobStore) (string, error) { return "", o.err }
+func (o *errorOutput) Read([]byte) (int, error)          { return 0, o.err }
+func (o *errorOutput) WriteTo(io.Writer) (int64, error)  { return 0, o.err }
+
+func (o *errorOutput) BytesRead() int64    { return 0 }
+func (o *errorOutput) LinesEmitted() int64 { return 0 }
+
+func (o *errorOutput) Wait() error  { return o.err }
+func (o *errorOutput) Close() error { return nil }
@@ -0,0 +1,202 @@
+package run_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/sourcegraph/run"
+)
+
+// outputConsumers consume Output in each supported way, returning the output collected
+// as lines.
+var outputConsumers = map[string]func(out run.Output) ([]string, error){
+	"Stream": func(out run.Output) ([]string, error) {
+		var b bytes.Buffer
+		err := out.Stream(&b)
+		return splitLines(b.String()), err
+	},
+	"StreamLines": func(out run.Output) ([]string, error) {
+		var lines []string
+		err := out.StreamLines(func(line string) { lines = append(lines, line) })
+		return lines, err
+	},
+	"Lines": func(out run.Output) ([]string, error) {
+		return out.Lines()
+	},
+	"String": func(out run.Output) ([]string, error) {
+		s, err := out.String()
+		return splitLines(s), err
+	},
+	"JQ": func(out run.Output) ([]string, error) {
+		b, err := out.JQ(".")
+		return splitLines(string(b)), err
+	},
+	"Read": func(out run.Output) ([]string, error) {
+		b, err := io.ReadAll(out)
+		return splitLines(string(b)), err
+	},
+	"WriteTo": func(out run.Output) ([]string, error) {
+		var b bytes.Buffer
+		_, err := out.WriteTo(&b)
+		return splitLines(b.String()), err
+	},
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// TestOutputContract asserts that all ways of consuming Output behave consistently.
+func TestOutputContract(t *testing.T) {
+	errMap := errors.New("map failed")
+	failOnSecondLine := func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+		if string(line) == "2" {
+			return 0, errMap
+		}
+		return dst.Write(line)
+	}
+
+	for _, tc := range []struct {
+		name string
+		run  func(ctx context.Context) run.Output
+		// wantLines is the output that should be collected, or nil if output collected
+		// before the error is not deterministic.
+		wantLines []string
+		// wantErr asserts the error returned.
+		wantErr func(c *qt.C, err error)
+	}{{
+		name: "success",
+		run: func(ctx context.Context) run.Output {
+			return run.Cmd(ctx, "echo 1").Run()
+		},
+		wantLines: []string{"1"},
+		wantErr:   func(c *qt.C, err error) { c.Assert(err, qt.IsNil) },
+	}, {
+		name: "command fails",
+		run: func(ctx context.Context) run.Output {
+			return run.Bash(ctx, "echo 1; exit 3").Run()
+		},
+		wantLines: []string{"1"},
+		wantErr: func(c *qt.C, err error) {
+			c.Assert(run.ExitCode(err), qt.Equals, 3)
+		},
+	}, {
+		name: "map fails",
+		run: func(ctx context.Context) run.Output {
+			return run.Bash(ctx, "echo 1; echo 2; echo 3").Run().Map(failOnSecondLine)
+		},
+		wantLines: []string{"1"},
+		wantErr:   func(c *qt.C, err error) { c.Assert(err, qt.ErrorIs, errMap) },
+	}, {
+		name: "command and map fail",
+		run: func(ctx context.Context) run.Output {
+			return run.Bash(ctx, "echo 1; echo 2; exit 3").Run().Map(failOnSecondLine)
+		},
+		wantLines: []string{"1"},
+		wantErr: func(c *qt.C, err error) {
+			// Errors from the command take precedence if the command has already
+			// exited, which is not deterministic here.
+			if !errors.Is(err, errMap) {
+				c.Assert(run.ExitCode(err), qt.Equals, 3)
+			}
+		},
+	}, {
+		name: "context canceled",
+		run: func(ctx context.Context) run.Output {
+			ctx, cancel := context.WithCancel(ctx)
+			out := run.Bash(ctx, "echo 1; sleep 10").Run()
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				cancel()
+			}()
+			return out
+		},
+		wantErr: func(c *qt.C, err error) {
+			c.Assert(err, qt.ErrorIs, run.ErrCanceled)
+			c.Assert(err, qt.ErrorIs, context.Canceled)
+		},
+	}, {
+		name: "context deadline exceeded",
+		run: func(ctx context.Context) run.Output {
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			_ = cancel // released by the deadline
+			return run.Bash(ctx, "echo 1; sleep 10").Run()
+		},
+		wantErr: func(c *qt.C, err error) {
+			c.Assert(err, qt.ErrorIs, run.ErrTimeout)
+			c.Assert(err, qt.ErrorIs, context.DeadlineExceeded)
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			for name, consume := range outputConsumers {
+				t.Run(name, func(t *testing.T) {
+					c := qt.New(t)
+					out := tc.run(context.Background())
+					defer out.Close()
+
+					lines, err := consume(out)
+					tc.wantErr(c, err)
+					if tc.wantLines != nil {
+						c.Assert(lines, qt.DeepEquals, tc.wantLines)
+					}
+
+					// Once consumed, output cannot be consumed again.
+					if err == nil {
+						c.Assert(out.Wait(), qt.ErrorIs, run.ErrAlreadyConsumed)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestOutputMetrics(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("counts output before maps", func(c *qt.C) {
+		for name, consume := range outputConsumers {
+			c.Run(name, func(c *qt.C) {
+				out := run.Bash(ctx, `printf '1\n22\n333'`).Run().
+					Map(func(ctx context.Context, line []byte, dst io.Writer) (int, error) {
+						return dst.Write([]byte("0"))
+					})
+				defer out.Close()
+
+				_, err := consume(out)
+				c.Assert(err, qt.IsNil)
+				c.Assert(out.BytesRead(), qt.Equals, int64(8))
+				c.Assert(out.LinesEmitted(), qt.Equals, int64(3))
+			})
+		}
+	})
+
+	c.Run("mid-flight", func(c *qt.C) {
+		out := run.Bash(ctx, "echo 1; sleep 0.1; echo 2").Run()
+		defer out.Close()
+
+		var seen []int64
+		err := out.StreamLines(func(line string) {
+			seen = append(seen, out.LinesEmitted())
+		})
+		c.Assert(err, qt.IsNil)
+		c.Assert(seen, qt.DeepEquals, []int64{1, 2})
+	})
+
+	c.Run("error output", func(c *qt.C) {
+		out := run.NewErrorOutput(errors.New("oops"))
+		c.Assert(out.BytesRead(), qt.Equals, int64(0))
+		c.Assert(out.LinesEmitted(), qt.Equals, int64(0))
+	})
+}
@@ -0,0 +1,76 @@
+package run
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// Port is a free TCP port on the loopback interface reserved by AllocatePort.
+type Port struct {
+	// Number is the reserved port number.
+	Number int
+
+	mux      sync.Mutex
+	listener net.Listener
+	// released is closed when the port is released.
+	released chan struct{}
+}
+
+// AllocatePort reserves a free TCP port on the loopback interface, for example to
+// provide to a command that serves on it. The port remains reserved, so that other
+// processes are not assigned the same port, until it is released with Release, by a
+// command configured with ReservePorts starting, or when ctx is done.
+func AllocatePort(ctx context.Context) (*Port, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &Port{
+		Number:   listener.Addr().(*net.TCPAddr).Port,
+		listener: listener,
+		released: make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Release()
+		case <-p.released:
+		}
+	}()
+	return p, nil
+}
+
+// String returns the port number, for use in arguments or environment variables.
+func (p *Port) String() string { return strconv.Itoa(p.Number) }
+
+// Release releases the reserved port so that it can be used. It is safe to call
+// Release multiple times.
+func (p *Port) Release() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	err := p.listener.Close()
+	p.listener = nil
+	close(p.released)
+	return err
+}
+
+// ReservePorts releases the given ports allocated by AllocatePort immediately before the
+// command is started, so that the ports remain reserved for as long as possible before
+// the command can use them.
+func (c *Command) ReservePorts(ports ...*Port) *Command {
+	c.prepare = append(c.prepare, func(*exec.Cmd) error {
+		for _, p := range ports {
+			if err := p.Release(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return c
+}
@@ -0,0 +1,107 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ANSI escape codes used for colorizing JSON, based on jq's default colors.
+const (
+	colorReset  = "\x1b[0m"
+	colorNull   = "\x1b[1;30m"
+	colorValue  = "\x1b[0;39m" // booleans and numbers
+	colorString = "\x1b[0;32m"
+	colorDelim  = "\x1b[1;39m" // objects and arrays
+	colorKey    = "\x1b[34;1m"
+)
+
+// writePrettyJSON re-indents each JSON value from src, optionally colorizing it, and
+// writes each value followed by a newline to dst. Object keys retain their original
+// order.
+func writePrettyJSON(dst io.Writer, src io.Reader, colorize bool) error {
+	dec := json.NewDecoder(src)
+	var indented bytes.Buffer
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("json: %w", err)
+		}
+
+		indented.Reset()
+		if err := json.Indent(&indented, raw, "", "  "); err != nil {
+			return fmt.Errorf("json: %w", err)
+		}
+		if colorize {
+			colorizeJSON(&indented)
+		}
+		indented.WriteByte('\n')
+		if _, err := dst.Write(indented.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// colorizeJSON adds ANSI color codes to valid JSON in buf.
+func colorizeJSON(buf *bytes.Buffer) {
+	src := append([]byte(nil), buf.Bytes()...)
+	buf.Reset()
+
+	write := func(color string, b []byte) {
+		buf.WriteString(color)
+		buf.Write(b)
+		buf.WriteString(colorReset)
+	}
+
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++ // skip escaped character
+				}
+				end++
+			}
+			end++ // include closing quote
+			if end > len(src) {
+				end = len(src)
+			}
+
+			// A string followed by a colon is an object key.
+			next := end
+			for next < len(src) && (src[next] == ' ' || src[next] == '\n') {
+				next++
+			}
+			if next < len(src) && src[next] == ':' {
+				write(colorKey, src[i:end])
+			} else {
+				write(colorString, src[i:end])
+			}
+			i = end
+
+		case c == '{' || c == '}' || c == '[' || c == ']':
+			write(colorDelim, src[i:i+1])
+			i++
+
+		case c == 'n' && bytes.HasPrefix(src[i:], []byte("null")):
+			write(colorNull, src[i:i+4])
+			i += 4
+
+		case c == 't' || c == 'f' || c == '-' || (c >= '0' && c <= '9'):
+			end := i
+			for end < len(src) && bytes.IndexByte([]byte(",]} \n:"), src[end]) < 0 {
+				end++
+			}
+			write(colorValue, src[i:end])
+			i = end
+
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package run
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// I/O scheduling classes for IONice.
+const (
+	IOClassRealtime   = 1
+	IOClassBestEffort = 2
+	IOClassIdle       = 3
+)
+
+// processPriority configures the scheduling priority of a command.
+type processPriority struct {
+	// nice, if set, is the niceness the command is run with.
+	nice *int
+	// ioClass, if non-zero, is the I/O scheduling class the command is run with, and
+	// ioLevel is the priority within the class.
+	ioClass, ioLevel int
+}
+
+// Nice configures the command to be run with the given niceness with 'nice', for example
+// 10 to run long-running background tasks with a lower priority than the processes of
+// the user. Negative values, which increase priority, usually require privileges.
+//
+// If 'nice' is not available, such as on Windows, Nice has no effect.
+func (c *Command) Nice(level int) *Command {
+	c.priority.nice = &level
+	return c
+}
+
+// IONice configures the command to be run with the given I/O scheduling class and
+// priority within that class with 'ionice', for example IOClassIdle to only perform I/O
+// when no other process needs it. Levels range from 0 (highest) to 7 (lowest), and are
+// not used with IOClassIdle.
+//
+// If 'ionice' is not available, such as on macOS and Windows, IONice has no effect.
+func (c *Command) IONice(class, level int) *Command {
+	c.priority.ioClass = class
+	c.priority.ioLevel = level
+	return c
+}
+
+// wrap wraps args with the helpers that apply the configured priority, if they are
+// available.
+func (p processPriority) wrap(args []string) []string {
+	if p.ioClass != 0 {
+		if _, err := exec.LookPath("ionice"); err == nil {
+			wrapped := []string{"ionice", "-c", strconv.Itoa(p.ioClass)}
+			if p.ioClass != IOClassIdle {
+				wrapped = append(wrapped, "-n", strconv.Itoa(p.ioLevel))
+			}
+			args = append(wrapped, args...)
+		}
+	}
+	if p.nice != nil {
+		if _, err := exec.LookPath("nice"); err == nil {
+			args = append([]string{"nice", "-n", strconv.Itoa(*p.nice)}, args...)
+		}
+	}
+	return args
+}
@@ -0,0 +1,148 @@
+package run
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const contextKeyProcessGroups contextKey = "processGroups"
+
+// KillProcessGroups configures all commands executed by sourcegraph/run within this
+// context to be started in a new process group, as with (*Command).NewProcessGroup, so
+// that when a command is terminated, for example because its context is done, processes
+// it spawns are terminated as well. This is useful for commands such as those built with
+// Bash, where the direct child is a shell and terminating it alone may leave the
+// processes it started running.
+//
+// It is not the default, since commands in a new process group no longer receive
+// signals delivered to the process group of the current process, such as from pressing
+// Ctrl-C in a terminal, and cannot read from the terminal. To terminate commands when
+// the program is interrupted, use CleanupOnInterrupt.
+//
+// On Windows, commands are always run in a job object so that all processes spawned by
+// the command are terminated together, but KillProcessGroups still starts commands in a
+// new process group, which no longer receives Ctrl-C from the console.
+func KillProcessGroups(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyProcessGroups, true)
+}
+
+// isKillProcessGroups indicates if KillProcessGroups is configured in ctx.
+func isKillProcessGroups(ctx context.Context) bool {
+	v, _ := ctx.Value(contextKeyProcessGroups).(bool)
+	return v
+}
+
+// Process is a command started with (*Command).Start.
+type Process struct {
+	// process is the started process, or nil if no process was started, for example
+	// because of DryRun.
+	process *os.Process
+	tree    processTree
+	// exited is closed once the command has exited and has been waited on.
+	exited <-chan struct{}
+	output Output
+}
+
+// Pid returns the process ID of the started command, or 0 if no process was started,
+// for example because of DryRun.
+func (p *Process) Pid() int {
+	if p.process == nil {
+		return 0
+	}
+	return p.process.Pid
+}
+
+// Signal delivers sig to the command, or to its process group if it was started in a new
+// process group. It returns os.ErrProcessDone if the command has exited.
+func (p *Process) Signal(sig os.Signal) error {
+	if p.done() {
+		return os.ErrProcessDone
+	}
+	return p.tree.signal(sig)
+}
+
+// Kill forcibly terminates the command and, where supported, all its descendants. It
+// returns os.ErrProcessDone if the command has exited. Unlike Output.Close, the
+// command's output can still be consumed after Kill.
+func (p *Process) Kill() error {
+	if p.done() {
+		return os.ErrProcessDone
+	}
+	return p.tree.kill()
+}
+
+// Output returns the Output of the command, which must be consumed or closed to release
+// resources held by the command.
+func (p *Process) Output() Output { return p.output }
+
+// done indicates if no process was started, or the command has exited.
+func (p *Process) done() bool {
+	if p.process == nil {
+		return true
+	}
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// processTree controls a started command and, where supported, the processes it spawns.
+type processTree interface {
+	// signal delivers sig to the command.
+	signal(sig os.Signal) error
+	// kill forcibly terminates the command and, where supported, all its descendants.
+	kill() error
+	// release releases any resources held for tracking the process tree. It should be
+	// called after the command has exited.
+	release()
+}
+
+// startProcessTree starts cmd and begins tracking it, and where supported, the processes
+// it spawns. shutdown is the command's configured shutdown behaviour.
+func startProcessTree(cmd *exec.Cmd, shutdown gracefulShutdown) (processTree, error) {
+	prepareProcessTree(cmd, shutdown)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	tree, err := trackProcessTree(cmd)
+	if err != nil {
+		// Fall back to only controlling the command itself.
+		return &processOnly{process: cmd.Process}, nil
+	}
+	return tree, nil
+}
+
+// processOnly is a processTree that only controls the command itself.
+type processOnly struct{ process *os.Process }
+
+func (p *processOnly) signal(sig os.Signal) error { return p.process.Signal(sig) }
+func (p *processOnly) kill() error                { return p.process.Kill() }
+func (p *processOnly) release()                   {}
+
+// gracefulShutdown configures how a command is terminated when its context is done.
+type gracefulShutdown struct {
+	// signal is delivered to the command first, if set.
+	signal os.Signal
+	// grace is how long to wait for the command to exit after signal is delivered
+	// before it is forcibly terminated.
+	grace time.Duration
+}
+
+// terminate terminates the command tracked by tree, delivering the configured signal
+// first if there is one. exited should be closed when the command exits.
+func (s gracefulShutdown) terminate(clock Clock, tree processTree, exited <-chan struct{}) {
+	if s.signal != nil && s.grace > 0 {
+		if err := tree.signal(s.signal); err == nil {
+			select {
+			case <-exited:
+				return
+			case <-clock.After(s.grace):
+			}
+		}
+	}
+	_ = tree.kill()
+}
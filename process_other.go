@@ -0,0 +1,37 @@
+//go:build !windows
+
+package run
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func prepareProcessTree(*exec.Cmd, gracefulShutdown) {}
+
+// trackProcessTree tracks the process group of the started command if the command was
+// started as the leader of a new process group, and otherwise only the command itself.
+func trackProcessTree(cmd *exec.Cmd) (processTree, error) {
+	if attr := cmd.SysProcAttr; attr != nil && attr.Setpgid && attr.Pgid == 0 {
+		return &processGroup{process: cmd.Process}, nil
+	}
+	return &processOnly{process: cmd.Process}, nil
+}
+
+// processGroup is a processTree that controls all processes in the process group led
+// by the command.
+type processGroup struct{ process *os.Process }
+
+func (p *processGroup) signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.process.Signal(sig)
+	}
+	// A negative PID delivers the signal to every process in the process group.
+	return syscall.Kill(-p.process.Pid, s)
+}
+
+func (p *processGroup) kill() error { return p.signal(syscall.SIGKILL) }
+
+func (p *processGroup) release() {}
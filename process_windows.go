@@ -0,0 +1,124 @@
+//go:build windows
+
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+var (
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procGenerateConsoleCtrlEvent = kernel32.NewProc("GenerateConsoleCtrlEvent")
+)
+
+const (
+	createNewProcessGroup = 0x00000200
+	ctrlBreakEvent        = 1
+
+	processSetQuota  = 0x0100
+	processTerminate = 0x0001
+)
+
+// prepareProcessTree starts the command in a new process group if it should be
+// interrupted on shutdown, so that CTRL_BREAK events can be delivered to it without
+// affecting the parent process. Commands in a new process group no longer receive
+// Ctrl-C from the console, so this is not done by default.
+func prepareProcessTree(cmd *exec.Cmd, shutdown gracefulShutdown) {
+	if shutdown.signal != nil && shutdown.grace > 0 {
+		setNewProcessGroup(sysProcAttr(cmd))
+	}
+}
+
+// trackProcessTree assigns the started command to a job object, such that all processes
+// it spawns can be terminated together.
+//
+// Processes spawned by the command before it is assigned to the job object are not
+// tracked.
+func trackProcessTree(cmd *exec.Cmd) (processTree, error) {
+	job, _, err := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return nil, fmt.Errorf("CreateJobObject: %w", err)
+	}
+
+	process, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		_ = syscall.CloseHandle(syscall.Handle(job))
+		return nil, fmt.Errorf("OpenProcess: %w", err)
+	}
+	defer syscall.CloseHandle(process)
+
+	if ok, _, err := procAssignProcessToJobObject.Call(job, uintptr(process)); ok == 0 {
+		_ = syscall.CloseHandle(syscall.Handle(job))
+		return nil, fmt.Errorf("AssignProcessToJobObject: %w", err)
+	}
+
+	return &jobObject{
+		job:     syscall.Handle(job),
+		process: cmd.Process,
+		group:   cmd.SysProcAttr != nil && cmd.SysProcAttr.CreationFlags&createNewProcessGroup != 0,
+	}, nil
+}
+
+// jobObject is a processTree backed by a Windows job object.
+type jobObject struct {
+	job     syscall.Handle
+	process *os.Process
+	// group indicates the command leads a new process group, which is required to
+	// deliver CTRL_BREAK events to it.
+	group bool
+
+	// mux guards job against use after release.
+	mux      sync.Mutex
+	released bool
+}
+
+// signal delivers os.Interrupt as a CTRL_BREAK event to the command's process group, if
+// it leads one, and os.Kill by terminating the job. Other signals are not supported on
+// Windows.
+func (j *jobObject) signal(sig os.Signal) error {
+	switch sig {
+	case os.Interrupt:
+		if !j.group {
+			return errors.New("os.Interrupt requires a new process group on windows")
+		}
+		if ok, _, err := procGenerateConsoleCtrlEvent.Call(ctrlBreakEvent, uintptr(j.process.Pid)); ok == 0 {
+			return fmt.Errorf("GenerateConsoleCtrlEvent: %w", err)
+		}
+		return nil
+	case os.Kill:
+		return j.kill()
+	default:
+		return errors.New("unsupported signal on windows")
+	}
+}
+
+func (j *jobObject) kill() error {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if j.released {
+		return os.ErrProcessDone
+	}
+
+	if ok, _, err := procTerminateJobObject.Call(uintptr(j.job), 1); ok == 0 {
+		return fmt.Errorf("TerminateJobObject: %w", err)
+	}
+	return nil
+}
+
+func (j *jobObject) release() {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	if !j.released {
+		j.released = true
+		_ = syscall.CloseHandle(j.job)
+	}
+}
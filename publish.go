@@ -0,0 +1,147 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Publisher publishes batches of records, for example to a message queue such as a NATS
+// subject or a Kafka topic. It is an interface so that any client can be adapted to it
+// without sourcegraph/run depending on it.
+type Publisher interface {
+	// Publish publishes the given records in order. records must not be retained after
+	// Publish returns.
+	Publish(ctx context.Context, records [][]byte) error
+}
+
+// PublisherFunc implements Publisher with a function, for example to adapt a Kafka
+// producer that can write multiple messages at once.
+type PublisherFunc func(ctx context.Context, records [][]byte) error
+
+var _ Publisher = PublisherFunc(nil)
+
+func (f PublisherFunc) Publish(ctx context.Context, records [][]byte) error {
+	return f(ctx, records)
+}
+
+// SubjectPublisher returns a Publisher that publishes each record to subject with
+// publish, which matches the signature of publish functions of subject-based clients,
+// for example (*nats.Conn).Publish.
+func SubjectPublisher(subject string, publish func(subject string, data []byte) error) Publisher {
+	return PublisherFunc(func(ctx context.Context, records [][]byte) error {
+		for _, r := range records {
+			if err := publish(subject, append([]byte(nil), r...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ChannelPublisher returns a Publisher that sends each record to ch, for example for
+// forwarding records to an in-process consumer. Publish blocks until each record is
+// received or ctx is done.
+func ChannelPublisher(ch chan<- []byte) Publisher {
+	return PublisherFunc(func(ctx context.Context, records [][]byte) error {
+		for _, r := range records {
+			select {
+			case ch <- append([]byte(nil), r...):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// PublishOptions configures how Publish batches lines.
+type PublishOptions struct {
+	// BatchSize is the maximum number of lines published at once. If zero, each line is
+	// published individually.
+	BatchSize int
+	// FlushInterval, if set, is the longest a line is held in an incomplete batch before
+	// it is published.
+	FlushInterval time.Duration
+}
+
+// Publish publishes each line of output to publisher in batches until the command
+// completes, for example to forward command output to a central system. If publishing
+// fails, remaining output is discarded, and the error is returned once the command
+// completes. The returned error then also matches the command's error, if any.
+func Publish(ctx context.Context, output Output, publisher Publisher, opts PublishOptions) error {
+	batchSize := opts.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	var (
+		mux     sync.Mutex
+		batch   = make([][]byte, 0, batchSize)
+		failed  error
+		flushed = make(chan struct{})
+	)
+	// flush must be called with mux held.
+	flush := func() {
+		if len(batch) > 0 && failed == nil {
+			failed = publisher.Publish(ctx, batch)
+		}
+		batch = batch[:0]
+	}
+
+	if opts.FlushInterval > 0 {
+		clock := getClock(ctx)
+		go func() {
+			for {
+				select {
+				case <-clock.After(opts.FlushInterval):
+					mux.Lock()
+					flush()
+					mux.Unlock()
+				case <-flushed:
+					return
+				}
+			}
+		}()
+	}
+	defer close(flushed)
+
+	err := output.StreamLines(func(line string) {
+		mux.Lock()
+		defer mux.Unlock()
+		batch = append(batch, []byte(line))
+		if len(batch) >= batchSize {
+			flush()
+		}
+	})
+
+	mux.Lock()
+	defer mux.Unlock()
+	flush()
+	if failed != nil {
+		return &publishError{publishErr: failed, err: err}
+	}
+	return err
+}
+
+// publishError is returned by Publish if publishing fails. It matches both the error
+// from publishing and the command's error, if any.
+type publishError struct {
+	publishErr error
+	err        error
+}
+
+func (e *publishError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("failed to publish output: %s", e.publishErr)
+	}
+	return fmt.Sprintf("failed to publish output: %s (command failed: %s)", e.publishErr, e.err)
+}
+
+func (e *publishError) Unwrap() error { return e.err }
+
+func (e *publishError) Is(target error) bool { return errors.Is(e.publishErr, target) }
+
+func (e *publishError) As(target interface{}) bool { return errors.As(e.publishErr, target) }
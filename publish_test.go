@@ -0,0 +1,91 @@
+package run_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/sourcegraph/run"
+)
+
+func TestPublish(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("batches", func(c *qt.C) {
+		var batches []string
+		publisher := run.PublisherFunc(func(ctx context.Context, records [][]byte) error {
+			var batch []string
+			for _, r := range records {
+				batch = append(batch, string(r))
+			}
+			batches = append(batches, strings.Join(batch, ","))
+			return nil
+		})
+
+		err := run.Publish(ctx, run.Cmd(ctx, "printf", run.Arg("a\nb\nc\nd\ne\n")).Run(),
+			publisher, run.PublishOptions{BatchSize: 2})
+		c.Assert(err, qt.IsNil)
+		c.Assert(batches, qt.DeepEquals, []string{"a,b", "c,d", "e"})
+	})
+
+	c.Run("flush interval", func(c *qt.C) {
+		records := make(chan []byte)
+		output := run.Bash(ctx, "echo a; sleep 10").Run()
+		defer output.Close()
+
+		errC := make(chan error, 1)
+		go func() {
+			errC <- run.Publish(ctx, output, run.ChannelPublisher(records),
+				run.PublishOptions{BatchSize: 10, FlushInterval: 10 * time.Millisecond})
+		}()
+
+		select {
+		case r := <-records:
+			c.Assert(string(r), qt.Equals, "a")
+		case <-time.After(5 * time.Second):
+			c.Fatal("line not flushed")
+		}
+	})
+
+	c.Run("subject", func(c *qt.C) {
+		var published []string
+		publisher := run.SubjectPublisher("logs", func(subject string, data []byte) error {
+			published = append(published, subject+":"+string(data))
+			return nil
+		})
+
+		err := run.Publish(ctx, run.Cmd(ctx, "echo hello").Run(), publisher, run.PublishOptions{})
+		c.Assert(err, qt.IsNil)
+		c.Assert(published, qt.DeepEquals, []string{"logs:hello"})
+	})
+
+	c.Run("publish error", func(c *qt.C) {
+		publishErr := errors.New("unavailable")
+		calls := 0
+		publisher := run.PublisherFunc(func(ctx context.Context, records [][]byte) error {
+			calls++
+			return publishErr
+		})
+
+		err := run.Publish(ctx, run.Cmd(ctx, "printf", run.Arg("a\nb\nc\n")).Run(),
+			publisher, run.PublishOptions{})
+		c.Assert(err, qt.ErrorIs, publishErr)
+		c.Assert(calls, qt.Equals, 1)
+	})
+
+	c.Run("publish and command error", func(c *qt.C) {
+		publishErr := errors.New("unavailable")
+		publisher := run.PublisherFunc(func(ctx context.Context, records [][]byte) error {
+			return publishErr
+		})
+
+		err := run.Publish(ctx, run.Bash(ctx, "echo a; exit 3").Run(),
+			publisher, run.PublishOptions{})
+		c.Assert(err, qt.ErrorIs, publishErr)
+		c.Assert(run.ExitCode(err), qt.Equals, 3)
+	})
+}
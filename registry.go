@@ -0,0 +1,101 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const contextKeyCommandRegistry contextKey = "commandRegistry"
+
+// CommandRegistry records the distinct commands and subcommands executed by
+// sourcegraph/run, for example to generate allowlists for sandboxes or for security
+// reviews of what a program executes. The zero value is ready to use, and a
+// CommandRegistry is safe for concurrent use.
+type CommandRegistry struct {
+	mux sync.Mutex
+	// commands maps command names to subcommands to the number of executions.
+	commands map[string]map[string]int
+}
+
+// RegisteredCommand is a distinct command recorded by a CommandRegistry.
+type RegisteredCommand struct {
+	// Name is the command name, i.e. the first argument.
+	Name string
+	// Subcommands are the distinct first non-flag arguments provided to the command,
+	// e.g. "status" for "git status".
+	Subcommands []string
+	// Executions is the number of times the command was executed.
+	Executions int
+}
+
+// RecordCommands records all commands executed by sourcegraph/run within this context
+// in the given registry. Set to nil to disable (default).
+func RecordCommands(ctx context.Context, registry *CommandRegistry) context.Context {
+	return context.WithValue(ctx, contextKeyCommandRegistry, registry)
+}
+
+// getCommandRegistry returns the registry configured in ctx, or nil.
+func getCommandRegistry(ctx context.Context) *CommandRegistry {
+	v, _ := ctx.Value(contextKeyCommandRegistry).(*CommandRegistry)
+	return v
+}
+
+func (r *CommandRegistry) record(e ExecutedCommand) {
+	if len(e.Args) == 0 {
+		return
+	}
+	var subcommand string
+	if len(e.Args) > 1 && !strings.HasPrefix(e.Args[1], "-") {
+		subcommand = e.Args[1]
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.commands == nil {
+		r.commands = make(map[string]map[string]int)
+	}
+	if r.commands[e.Args[0]] == nil {
+		r.commands[e.Args[0]] = make(map[string]int)
+	}
+	r.commands[e.Args[0]][subcommand]++
+}
+
+// Commands returns all recorded commands, sorted by name.
+func (r *CommandRegistry) Commands() []RegisteredCommand {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	commands := make([]RegisteredCommand, 0, len(r.commands))
+	for name, subcommands := range r.commands {
+		c := RegisteredCommand{Name: name}
+		for sub, count := range subcommands {
+			if sub != "" {
+				c.Subcommands = append(c.Subcommands, sub)
+			}
+			c.Executions += count
+		}
+		sort.Strings(c.Subcommands)
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// Report writes a human-readable report of all recorded commands to dst, with one
+// command per line followed by its subcommands.
+func (r *CommandRegistry) Report(dst io.Writer) error {
+	for _, c := range r.Commands() {
+		line := c.Name
+		if len(c.Subcommands) > 0 {
+			line += " " + strings.Join(c.Subcommands, ",")
+		}
+		if _, err := fmt.Fprintf(dst, "%s (%d)\n", line, c.Executions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package run
+
+import "context"
+
+const contextKeyErrorRenderer contextKey = "errorRenderer"
+
+// ErrorRenderFunc transforms an error from an executed command, for example into a
+// user-facing message that names the command and suggests a fix or links to
+// documentation. Renderers should wrap err, e.g. with fmt.Errorf and '%w', so that the
+// error can still be inspected with errors.As and functions like ErrorClassification.
+type ErrorRenderFunc func(cmd ExecutedCommand, err error) error
+
+// RenderErrors configures all commands executed by sourcegraph/run within this context to
+// transform errors with render, so that programs built on sourcegraph/run can present
+// errors consistently without wrapping every call. It is applied to errors from commands
+// that fail to start or exit with an error, after errors are mapped with MapExitCodes and
+// classified with ClassifyErrors.
+func RenderErrors(ctx context.Context, render ErrorRenderFunc) context.Context {
+	return context.WithValue(ctx, contextKeyErrorRenderer, render)
+}
+
+// renderError transforms err with the renderer configured in ctx, if any.
+func renderError(ctx context.Context, cmd ExecutedCommand, err error) error {
+	render, _ := ctx.Value(contextKeyErrorRenderer).(ErrorRenderFunc)
+	if render == nil || err == nil {
+		return err
+	}
+	return render(cmd, err)
+}
@@ -0,0 +1,112 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Report collects the results of commands to render a summary as Markdown or HTML, for
+// example to post a summary of a run to a pull request comment. Each command is
+// rendered as a collapsible section containing its output, with a badge denoting its
+// exit code.
+//
+// The zero value is ready to use, and a Report is safe for concurrent use.
+type Report struct {
+	mux     sync.Mutex
+	entries []ReportEntry
+}
+
+// ReportEntry is a single command result in a Report.
+type ReportEntry struct {
+	// Title describes the command, for example the command itself.
+	Title string
+	// Output is the aggregated output of the command.
+	Output string
+	// Err is the error the command exited with, if any.
+	Err error
+}
+
+// Add waits for command completion, and adds the aggregated output and result of the
+// command to the report under the given title. The error from the output, if any, is
+// returned.
+func (r *Report) Add(title string, output Output) error {
+	out, err := output.String()
+	r.AddEntry(ReportEntry{Title: title, Output: out, Err: err})
+	return err
+}
+
+// AddEntry adds an entry to the report.
+func (r *Report) AddEntry(entry ReportEntry) {
+	r.mux.Lock()
+	r.entries = append(r.entries, entry)
+	r.mux.Unlock()
+}
+
+// Entries returns a copy of all entries in the report.
+func (r *Report) Entries() []ReportEntry {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return append([]ReportEntry(nil), r.entries...)
+}
+
+// badge renders the status of the entry.
+func (e ReportEntry) badge() string {
+	if e.Err == nil {
+		return "✅"
+	}
+	var startErr *startError
+	if errors.As(e.Err, &startErr) {
+		return "❌ failed to start"
+	}
+	return fmt.Sprintf("❌ exit %d", ExitCode(e.Err))
+}
+
+// Markdown renders the report as a Markdown fragment to dst. Collapsible sections are
+// implemented with <details> tags, which are supported by GitHub-flavoured Markdown.
+func (r *Report) Markdown(dst io.Writer) error {
+	var b strings.Builder
+	for _, e := range r.Entries() {
+		fmt.Fprintf(&b, "<details><summary>%s <code>%s</code></summary>\n\n",
+			e.badge(), html.EscapeString(e.Title))
+
+		writeCodeBlock(&b, e.Output)
+		if e.Err != nil {
+			b.WriteString("\n")
+			writeCodeBlock(&b, e.Err.Error())
+		}
+
+		b.WriteString("\n</details>\n\n")
+	}
+	_, err := io.WriteString(dst, b.String())
+	return err
+}
+
+// writeCodeBlock writes content to b as a fenced Markdown code block.
+func writeCodeBlock(b *strings.Builder, content string) {
+	// Make sure the content cannot terminate the code fence early.
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	fmt.Fprintf(b, "%s\n%s\n%s\n", fence, content, fence)
+}
+
+// HTML renders the report as an HTML fragment to dst.
+func (r *Report) HTML(dst io.Writer) error {
+	var b strings.Builder
+	for _, e := range r.Entries() {
+		fmt.Fprintf(&b, "<details><summary>%s <code>%s</code></summary>\n",
+			e.badge(), html.EscapeString(e.Title))
+		fmt.Fprintf(&b, "<pre>%s</pre>\n", html.EscapeString(e.Output))
+		if e.Err != nil {
+			fmt.Fprintf(&b, "<p>%s</p>\n", html.EscapeString(e.Err.Error()))
+		}
+		b.WriteString("</details>\n")
+	}
+	_, err := io.WriteString(dst, b.String())
+	return err
+}
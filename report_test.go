@@ -0,0 +1,47 @@
+package run_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/sourcegraph/run"
+)
+
+func TestReport(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	var report run.Report
+	c.Assert(report.Add("echo hello", run.Cmd(ctx, "echo hello").Run()), qt.IsNil)
+	c.Assert(report.Add("exit 2", run.Bash(ctx, "echo '<oops>' && exit 2").Run()), qt.IsNotNil)
+	c.Assert(report.Add("stderr", run.Bash(ctx, "printf '# heading\n```\n' >&2 && exit 1").StdOut().Run()), qt.IsNotNil)
+
+	c.Run("Markdown", func(c *qt.C) {
+		var b strings.Builder
+		c.Assert(report.Markdown(&b), qt.IsNil)
+		c.Assert(b.String(), qt.Equals, "<details><summary>✅ <code>echo hello</code></summary>\n\n"+
+			"```\nhello\n```\n\n</details>\n\n"+
+			"<details><summary>❌ exit 2 <code>exit 2</code></summary>\n\n"+
+			"```\n<oops>\n```\n\n```\nexit status 2\n```\n\n</details>\n\n"+
+			"<details><summary>❌ exit 1 <code>stderr</code></summary>\n\n"+
+			"```\n\n```\n\n````\nexit status 1: # heading\n```\n````\n\n</details>\n\n")
+	})
+
+	c.Run("failed to start", func(c *qt.C) {
+		var report run.Report
+		c.Assert(report.Add("missing", run.Cmd(ctx, "definitely-not-a-command").Run()), qt.IsNotNil)
+		var b strings.Builder
+		c.Assert(report.Markdown(&b), qt.IsNil)
+		c.Assert(b.String(), qt.Contains, "❌ failed to start <code>missing</code>")
+	})
+
+	c.Run("HTML", func(c *qt.C) {
+		var b strings.Builder
+		c.Assert(report.HTML(&b), qt.IsNil)
+		c.Assert(b.String(), qt.Contains, "<pre>&lt;oops&gt;</pre>")
+		c.Assert(b.String(), qt.Contains, "❌ exit 2")
+	})
+}
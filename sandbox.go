@@ -0,0 +1,90 @@
+package run
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// SeccompHook wraps the arguments of a command to apply a seccomp profile to it, for
+// example by prefixing a launcher that installs the profile and executes the remaining
+// arguments. Go cannot install seccomp filters between fork and exec, so profiles must be
+// applied by an intermediate process.
+type SeccompHook func(args []string) []string
+
+// NoNetwork runs the command in a new network namespace with no network interfaces
+// other than loopback, so that any attempt to access the network fails. This is useful
+// for enforcing that build steps are hermetic.
+//
+// NoNetwork is only supported on Linux. If the current user is not root, a user
+// namespace is also created, which requires unprivileged user namespaces to be enabled.
+func (c *Command) NoNetwork() *Command {
+	c.prepare = append(c.prepare, func(cmd *exec.Cmd) error {
+		return isolateNetwork(sysProcAttr(cmd))
+	})
+	return c
+}
+
+// ReadOnlyRoot runs the command in a new mount namespace where the root filesystem, and
+// every filesystem mounted under it, is mounted read-only, except for the given
+// directories, which remain writable. This is useful as a guardrail for commands that
+// must not modify a checkout. The pseudo-filesystems mounted at /dev, /proc, and /sys are
+// not affected.
+//
+// ReadOnlyRoot is only supported on Linux, and requires the 'sh' and 'mount' binaries. If
+// the current user is not root, a user namespace is also created where the current user
+// is mapped to root, which requires unprivileged user namespaces to be enabled.
+func (c *Command) ReadOnlyRoot(allowWrites ...string) *Command {
+	c.readOnlyRoot = &readOnlyRoot{allowWrites: allowWrites}
+	c.prepare = append(c.prepare, func(cmd *exec.Cmd) error {
+		return isolateMounts(sysProcAttr(cmd))
+	})
+	return c
+}
+
+// readOnlyRoot configures a command's root filesystem to be read-only.
+type readOnlyRoot struct{ allowWrites []string }
+
+// readOnlyRootScript sets up mounts in a new mount namespace. The first argument is the
+// number of writable directories, followed by the writable directories and finally the
+// command to execute.
+//
+// Each mount listed in /proc/self/mountinfo is remounted read-only, since remounting the
+// root only affects the root mount itself. Bind mounts copy the flags of their source,
+// so writable directories are bind mounted and then remounted read-write.
+const readOnlyRootScript = `set -e
+mount --make-rprivate /
+cut -d' ' -f5 /proc/self/mountinfo | while read -r mp; do
+	mp=$(printf '%b' "$mp")
+	case "$mp" in /dev|/dev/*|/proc|/proc/*|/sys|/sys/*) continue ;; esac
+	mount -o remount,bind,ro "$mp"
+done
+n=$1; shift
+while [ "$n" -gt 0 ]; do
+	mount --bind "$1" "$1"
+	mount -o remount,bind,rw "$1"
+	shift; n=$((n-1))
+done
+exec "$@"`
+
+// wrap wraps args with a script that sets up the read-only root filesystem.
+func (r *readOnlyRoot) wrap(args []string) []string {
+	wrapped := []string{"sh", "-c", readOnlyRootScript, "sh", strconv.Itoa(len(r.allowWrites))}
+	wrapped = append(wrapped, r.allowWrites...)
+	return append(wrapped, args...)
+}
+
+// Seccomp applies the given hook to the command's arguments when it is run, which can be
+// used to apply a seccomp profile to the command.
+func (c *Command) Seccomp(hook SeccompHook) *Command {
+	c.seccomp = hook
+	return c
+}
+
+// sysProcAttr returns cmd.SysProcAttr, initializing it if needed.
+func sysProcAttr(cmd *exec.Cmd) *syscall.SysProcAttr {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	return cmd.SysProcAttr
+}
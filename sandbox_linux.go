@@ -0,0 +1,38 @@
+package run
+
+import (
+	"os"
+	"syscall"
+)
+
+func isolateNetwork(attr *syscall.SysProcAttr) error {
+	attr.Cloneflags |= syscall.CLONE_NEWNET
+	ensureUserNamespace(attr, false)
+	return nil
+}
+
+func isolateMounts(attr *syscall.SysProcAttr) error {
+	attr.Cloneflags |= syscall.CLONE_NEWNS
+	// Setting up mounts requires privileges within the namespace.
+	ensureUserNamespace(attr, true)
+	return nil
+}
+
+// ensureUserNamespace creates a user namespace if the current user is not root, which
+// allows unprivileged users to create other namespaces. The current user is mapped to
+// itself, or to root if asRoot is true.
+func ensureUserNamespace(attr *syscall.SysProcAttr, asRoot bool) {
+	if os.Geteuid() == 0 {
+		return
+	}
+	if attr.Cloneflags&syscall.CLONE_NEWUSER != 0 && !asRoot {
+		return
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if asRoot {
+		uid, gid = 0, 0
+	}
+	attr.Cloneflags |= syscall.CLONE_NEWUSER
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: os.Getgid(), Size: 1}}
+}
@@ -0,0 +1,56 @@
+package run_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/sourcegraph/run"
+)
+
+func TestNoNetwork(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// List network interfaces visible to the command
+	interfaces, err := run.Bash(ctx, "tail -n +3 /proc/self/net/dev | cut -d: -f1 | tr -d ' '").
+		NoNetwork().
+		Run().
+		Lines()
+	if err != nil {
+		c.Skipf("namespaces unavailable: %s", err)
+	}
+	c.Assert(interfaces, qt.CmpEquals(), []string{"lo"})
+}
+
+func TestSeccomp(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	res, err := run.Cmd(ctx, "hello world").
+		Seccomp(func(args []string) []string {
+			return append([]string{"echo", "launcher"}, args...)
+		}).
+		Run().
+		String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(res, qt.Equals, "launcher hello world")
+}
+
+func TestReadOnlyRoot(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// Use a directory that is not allowlisted, which may be on a separate mount from
+	// the root filesystem, e.g. a tmpfs.
+	dir, denied := c.TempDir(), c.TempDir()
+
+	err := run.Cmd(ctx, "touch", dir+"/allowed").ReadOnlyRoot(dir).Run().Wait()
+	if err != nil {
+		c.Skipf("namespaces unavailable: %s", err)
+	}
+
+	err = run.Cmd(ctx, "touch", denied+"/denied").ReadOnlyRoot(dir).Run().Wait()
+	c.Assert(err, qt.ErrorMatches, ".*Read-only file system.*")
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package run
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isolateNetwork(*syscall.SysProcAttr) error {
+	return errors.New("NoNetwork is only supported on Linux")
+}
+
+func isolateMounts(*syscall.SysProcAttr) error {
+	return errors.New("ReadOnlyRoot is only supported on Linux")
+}
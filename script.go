@@ -0,0 +1,64 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// defaultScriptInterpreter runs script files that have no shebang.
+const defaultScriptInterpreter = "sh"
+
+// ScriptFile builds a command that runs the script file at path with the given
+// arguments, using the interpreter in the script's shebang line, e.g. '#!/bin/bash' or
+// '#!/usr/bin/env python3'. Scripts without a shebang line are run with 'sh'. The script
+// does not need to be executable.
+//
+// Arguments are passed to the script as-is.
+func ScriptFile(ctx context.Context, path string, args ...string) *Command {
+	interpreter, err := readShebang(path)
+	if err != nil {
+		return &Command{buildError: fmt.Errorf("failed to read script: %w", err)}
+	}
+	if len(interpreter) == 0 {
+		interpreter = []string{defaultScriptInterpreter}
+	}
+	return scriptFile(ctx, interpreter, path, args)
+}
+
+// ScriptFileWith builds a command that runs the script file at path with the given
+// arguments using the given interpreter, ignoring the script's shebang line.
+//
+// Arguments are passed to the script as-is.
+func ScriptFileWith(ctx context.Context, interpreter, path string, args ...string) *Command {
+	return scriptFile(ctx, []string{interpreter}, path, args)
+}
+
+func scriptFile(ctx context.Context, interpreter []string, path string, args []string) *Command {
+	argv := make([]string, 0, len(interpreter)+1+len(args))
+	argv = append(argv, interpreter...)
+	argv = append(argv, path)
+	return Args(ctx, append(argv, args...))
+}
+
+// readShebang returns the interpreter and its arguments from the shebang line of the
+// file at path, or nil if it has no shebang line.
+func readShebang(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return nil, nil
+	}
+	return strings.Fields(strings.TrimPrefix(line, "#!")), nil
+}
@@ -0,0 +1,131 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.bobheadxi.dev/streamline/pipeline"
+)
+
+const contextKeySecretRegistry contextKey = "secretRegistry"
+
+// SecretPlaceholder is written in place of secrets redacted by a SecretRegistry.
+const SecretPlaceholder = "<redacted>"
+
+// SecretRegistry holds secrets that are redacted from information about commands
+// executed by sourcegraph/run within a context configured with RedactSecrets. It is safe
+// for concurrent use.
+type SecretRegistry struct {
+	mux          sync.RWMutex
+	secrets      []string
+	replacer     *strings.Replacer
+	redactOutput bool
+}
+
+// RedactSecrets enables redacting secrets added to the returned SecretRegistry, or
+// registered with Secret, from commands executed by sourcegraph/run within this context.
+// Secrets are redacted from the arguments and environment of commands provided to
+// LogCommands, TraceCommands, RecordCommands, and History, from Command.String, and from
+// the stderr included in errors. To also redact secrets from output, use RedactOutput.
+func RedactSecrets(ctx context.Context) (context.Context, *SecretRegistry) {
+	registry := &SecretRegistry{}
+	return context.WithValue(ctx, contextKeySecretRegistry, registry), registry
+}
+
+// getSecretRegistry returns the registry configured in ctx, or nil.
+func getSecretRegistry(ctx context.Context) *SecretRegistry {
+	v, _ := ctx.Value(contextKeySecretRegistry).(*SecretRegistry)
+	return v
+}
+
+// Secret registers value as a secret in the SecretRegistry configured in ctx with
+// RedactSecrets, and returns value as-is, so that it can be used inline when building a
+// command:
+//
+//	run.Cmd(ctx, "curl -H", run.Arg("Authorization: Bearer "+run.Secret(ctx, token)))
+//
+// If ctx is not configured with RedactSecrets, the secret is not redacted.
+func Secret(ctx context.Context, value string) string {
+	if registry := getSecretRegistry(ctx); registry != nil {
+		registry.Add(value)
+	}
+	return value
+}
+
+// Add registers the given secrets to be redacted. Empty values are ignored.
+func (r *SecretRegistry) Add(secrets ...string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, s := range secrets {
+		if s != "" {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+	// Replace longer secrets first, in case secrets overlap.
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+	oldnew := make([]string, 0, 2*len(r.secrets))
+	for _, s := range r.secrets {
+		oldnew = append(oldnew, s, SecretPlaceholder)
+	}
+	r.replacer = strings.NewReplacer(oldnew...)
+}
+
+// RedactOutput configures the registry to also redact secrets from each line of output
+// of commands, before output is processed by LineMaps and Pipelines.
+func (r *SecretRegistry) RedactOutput() *SecretRegistry {
+	r.mux.Lock()
+	r.redactOutput = true
+	r.mux.Unlock()
+	return r
+}
+
+// Redact replaces all registered secrets in s with SecretPlaceholder.
+func (r *SecretRegistry) Redact(s string) string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// redactCommand returns a copy of e with secrets redacted from its arguments and
+// environment.
+func (r *SecretRegistry) redactCommand(e ExecutedCommand) ExecutedCommand {
+	redacted := e
+	redacted.Args = make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		redacted.Args[i] = r.Redact(arg)
+	}
+	if e.Environ != nil {
+		redacted.Environ = make([]string, len(e.Environ))
+		for i, kv := range e.Environ {
+			redacted.Environ[i] = r.Redact(kv)
+		}
+	}
+	return redacted
+}
+
+// redactError redacts secrets from the stderr included in err.
+func (r *SecretRegistry) redactError(err error) error {
+	var runErr *runError
+	if errors.As(err, &runErr) {
+		runErr.execErr.Stderr = []byte(r.Redact(string(runErr.execErr.Stderr)))
+	}
+	return err
+}
+
+// outputPipeline returns a pipeline that redacts secrets from output, if enabled.
+func (r *SecretRegistry) outputPipeline() pipeline.Pipeline {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	if !r.redactOutput {
+		return nil
+	}
+	return pipeline.Map(func(line []byte) []byte {
+		return []byte(r.Redact(string(line)))
+	})
+}
@@ -0,0 +1,81 @@
+package run
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// PowerShell joins all the parts and builds a command from it to be run by PowerShell
+// with '-Command'. On Windows, Windows PowerShell (powershell.exe) is used, and on other
+// platforms, PowerShell (pwsh) is used.
+//
+// Arguments are not implicitly quoted - to quote arguments, you can use PowerShellArg.
+func PowerShell(ctx context.Context, parts ...string) *Command {
+	return Args(ctx, []string{powerShellBinary,
+		"-NoLogo", "-NoProfile", "-NonInteractive", "-Command", strings.Join(parts, " ")})
+}
+
+// PowerShellArg quotes a value such that it gets treated as a single argument by
+// PowerShell.
+func PowerShellArg(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// CmdExe joins all the parts and builds a command from it to be run by 'cmd.exe /c'.
+//
+// cmd.exe does not parse its command line like other programs, so on Windows the script
+// is provided to cmd.exe verbatim instead of being escaped like other arguments.
+// Arguments are not implicitly quoted - to quote arguments, you can use CmdExeArg.
+func CmdExe(ctx context.Context, parts ...string) *Command {
+	script := strings.Join(parts, " ")
+	c := Args(ctx, []string{"cmd.exe", "/d", "/s", "/c", script})
+	c.prepare = append(c.prepare, func(cmd *exec.Cmd) error {
+		// With '/s', cmd.exe strips the outer quotes and runs the rest as-is.
+		setCmdLine(cmd, `cmd.exe /d /s /c "`+script+`"`)
+		return nil
+	})
+	return c
+}
+
+// cmdExeSpecialChars are characters that are escaped with '^' by CmdExeArg.
+const cmdExeSpecialChars = `^&|<>()%!"`
+
+// CmdExeArg quotes a value such that it gets treated as a single argument by a command
+// run by cmd.exe. The value is first quoted for the command's argument parser, following
+// the rules of CommandLineToArgvW, and then characters that are special to cmd.exe are
+// escaped with '^'.
+func CmdExeArg(v string) string {
+	var b strings.Builder
+	for _, r := range quoteWindowsArg(v) {
+		if strings.ContainsRune(cmdExeSpecialChars, r) {
+			b.WriteRune('^')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// quoteWindowsArg quotes v such that it is parsed as a single argument by
+// CommandLineToArgvW. Backslashes are only escaped where they precede a quote.
+func quoteWindowsArg(v string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	backslashes := 0
+	for _, r := range v {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+		backslashes = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, backslashes*2))
+	b.WriteByte('"')
+	return b.String()
+}
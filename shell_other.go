@@ -0,0 +1,11 @@
+//go:build !windows
+
+package run
+
+import "os/exec"
+
+// powerShellBinary is the PowerShell binary used by PowerShell.
+const powerShellBinary = "pwsh"
+
+// setCmdLine is a no-op, since arguments are passed to commands as-is.
+func setCmdLine(cmd *exec.Cmd, cmdLine string) {}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package run
+
+import "os/exec"
+
+// powerShellBinary is the PowerShell binary used by PowerShell.
+const powerShellBinary = "powershell.exe"
+
+// setCmdLine sets the command line the command is started with verbatim, bypassing the
+// escaping of arguments applied by exec.Cmd.
+func setCmdLine(cmd *exec.Cmd, cmdLine string) {
+	sysProcAttr(cmd).CmdLine = cmdLine
+}
@@ -0,0 +1,52 @@
+package run
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ForwardSignals configures the command to receive the given signals, or SIGINT and
+// SIGTERM if none are given, when they are received by the current process while the
+// command is running, for example so that pressing Ctrl-C in a wrapper CLI interrupts
+// the wrapped tool cleanly.
+//
+// The command is started in a new process group, as with NewProcessGroup, and signals
+// are delivered to all processes in its process group. This ensures the command
+// receives each signal exactly once: otherwise, signals delivered to the process group
+// of the current process, such as from pressing Ctrl-C in a terminal, would be
+// delivered to the command both directly and when forwarded. As a result, the command
+// cannot read from the terminal.
+//
+// While the command is running, the given signals do not terminate the current process,
+// as with signal.Notify. On Windows, only os.Interrupt is supported, which is delivered
+// as a CTRL_BREAK event.
+func (c *Command) ForwardSignals(sigs ...os.Signal) *Command {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c.forwardSignals = sigs
+	return c
+}
+
+// forwardSignals relays the given signals received by the current process to tree until
+// exited is closed. The command should lead its own process group, so that it does not
+// also receive signals delivered to the process group of the current process.
+func forwardSignals(sigs []os.Signal, tree processTree, exited <-chan struct{}) {
+	if len(sigs) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sigs...)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case sig := <-signals:
+				_ = tree.signal(sig)
+			case <-exited:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,310 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result is the recorded result of a command execution.
+type Result struct {
+	// Output is the output of the command, as configured on the command with e.g.
+	// StdOut.
+	Output []byte `json:"output"`
+	// ExitCode is the exit code of the command.
+	ExitCode int `json:"exitCode"`
+	// Stderr is the stderr included in the command's error if it exited with a non-zero
+	// exit code.
+	Stderr []byte `json:"stderr,omitempty"`
+
+	// StartedAt is when the command was started.
+	StartedAt time.Time `json:"startedAt"`
+	// FinishedAt is when the command exited.
+	FinishedAt time.Time `json:"finishedAt"`
+	// ExpiresAt is when the result should no longer be used. If zero, the result does
+	// not expire.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Expired indicates if the result should no longer be used at the given time.
+func (r Result) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// ResultCache stores command results keyed by command fingerprint, as returned by
+// (*Command).Fingerprint. It is used by (*Command).RunCached.
+type ResultCache interface {
+	// Get retrieves the result for fingerprint, if there is one and it has not expired.
+	Get(fingerprint string) (Result, bool)
+	// Put stores result for fingerprint, replacing any existing result.
+	Put(fingerprint string, result Result) error
+}
+
+var _ ResultCache = &ResultStore{}
+
+// ResultStore is a persistent, on-disk store of command results keyed by command
+// fingerprint, which allows results to be reused across process restarts. It is safe for
+// concurrent use, including by multiple processes.
+//
+// Expired results are evicted when results are added to the store. If the store exceeds
+// its size limit, least recently used results are evicted as well.
+type ResultStore struct {
+	dir      string
+	maxBytes int64
+	clock    Clock
+
+	mux sync.Mutex
+}
+
+const resultStoreExt = ".json"
+
+// NewResultStore creates a ResultStore in dir, which is created if it does not exist. If
+// dir is empty, a directory in os.UserCacheDir() is used. If maxBytes is greater than
+// 0, the store evicts results to keep its total size under maxBytes.
+func NewResultStore(dir string, maxBytes int64) (*ResultStore, error) {
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find cache directory: %w", err)
+		}
+		dir = filepath.Join(cacheDir, "sourcegraph-run", "results")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create result store: %w", err)
+	}
+	return &ResultStore{dir: dir, maxBytes: maxBytes, clock: systemClock{}}, nil
+}
+
+// WithClock configures the store to use clock to determine if results have expired, for
+// example to make tests of expiry deterministic. Set to nil to use the system clock
+// (default).
+func (s *ResultStore) WithClock(clock Clock) *ResultStore {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	s.clock = clock
+	return s
+}
+
+// Get retrieves the result stored for fingerprint, if there is one and it has not
+// expired.
+func (s *ResultStore) Get(fingerprint string) (Result, bool) {
+	path, err := s.path(fingerprint)
+	if err != nil {
+		return Result{}, false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, false
+	}
+	now := s.clock.Now()
+	var result Result
+	if err := json.Unmarshal(b, &result); err != nil || result.Expired(now) {
+		return Result{}, false
+	}
+
+	// Mark the result as recently used for eviction.
+	_ = os.Chtimes(path, now, now)
+
+	return result, true
+}
+
+// Put stores result for fingerprint, replacing any existing result, and evicts results
+// as needed.
+func (s *ResultStore) Put(fingerprint string, result Result) error {
+	path, err := s.path(fingerprint)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// Write to a temporary file first so that readers never observe a partial result.
+	tmp, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return s.evict()
+}
+
+// evict removes expired results, and then least recently used results until the store is
+// within its size limit. It must be called with mux held.
+func (s *ResultStore) evict() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	type storedResult struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		results []storedResult
+		total   int64
+		errs    []string
+		now     = s.clock.Now()
+	)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), resultStoreExt) {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed concurrently
+		}
+
+		if b, err := os.ReadFile(path); err == nil {
+			// Only the expiry is needed to determine if the result has expired.
+			var result struct {
+				ExpiresAt time.Time `json:"expiresAt"`
+			}
+			if json.Unmarshal(b, &result) == nil && (Result{ExpiresAt: result.ExpiresAt}).Expired(now) {
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err.Error())
+				}
+				continue
+			}
+		}
+
+		results = append(results, storedResult{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	if s.maxBytes > 0 && total > s.maxBytes {
+		sort.Slice(results, func(i, j int) bool { return results[i].modTime.Before(results[j].modTime) })
+		for _, r := range results {
+			if total <= s.maxBytes {
+				break
+			}
+			if err := os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err.Error())
+				continue
+			}
+			total -= r.size
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to evict results: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// path returns the path of the result for fingerprint, and validates fingerprint such
+// that it cannot be used to refer to files outside the store.
+func (s *ResultStore) path(fingerprint string) (string, error) {
+	if !isDigest(fingerprint) {
+		return "", fmt.Errorf("invalid fingerprint %q", fingerprint)
+	}
+	return filepath.Join(s.dir, fingerprint+resultStoreExt), nil
+}
+
+// RunCached is similar to Run, but reuses the result of a previous execution of an
+// equivalent command from cache if one is available, as determined by Fingerprint. If
+// there is no result available, the command is run to completion and its result is
+// added to cache with the given time-to-live before output is returned. If ttl is 0 or
+// negative, the result does not expire. Results are only stored if the command exits on
+// its own, regardless of its exit code, and not if it is terminated, for example by a
+// signal or because its context is done.
+//
+// Errors from results reused from cache are handled as configured on the command and in
+// its context, for example with MapExitCodes, ClassifyErrors, and RenderErrors, as if
+// the command had been run.
+func (c *Command) RunCached(cache ResultCache, ttl time.Duration) Output {
+	fingerprint, err := c.Fingerprint()
+	if err != nil {
+		return NewErrorOutput(err)
+	}
+	clock := getClock(c.ctx)
+	if result, ok := cache.Get(fingerprint); ok && !result.Expired(clock.Now()) {
+		return c.replay(result)
+	}
+
+	result := Result{StartedAt: clock.Now()}
+	var output bytes.Buffer
+	err = c.Run().Stream(&output)
+	result.FinishedAt = clock.Now()
+	if ttl > 0 {
+		result.ExpiresAt = result.FinishedAt.Add(ttl)
+	}
+	result.Output = output.Bytes()
+
+	if exitedOnItsOwn(err) && c.ctx.Err() == nil {
+		result.ExitCode = ExitCode(err)
+		var runErr *runError
+		if errors.As(err, &runErr) {
+			result.Stderr = runErr.execErr.Stderr
+		}
+		if err := cache.Put(fingerprint, result); err != nil {
+			return NewErrorOutput(fmt.Errorf("failed to store result: %w", err))
+		}
+	}
+
+	return newBufferedOutput(c.ctx, result.Output, err)
+}
+
+// exitedOnItsOwn indicates if err, as returned by a command, denotes that the command
+// exited on its own, rather than failing to start or being terminated.
+func exitedOnItsOwn(err error) bool {
+	if err == nil {
+		return true
+	}
+	var exitCoder ExitCoder
+	if !errors.As(err, &exitCoder) {
+		return false
+	}
+	if _, signaled := ExitSignal(err); signaled || OOMKilled(err) {
+		return false
+	}
+	var runErr *runError
+	return !errors.As(err, &runErr) || runErr.cause == nil
+}
+
+// replay creates an Output that replays result as the result of this command. Errors
+// are handled the same way as errors from running the command.
+func (c *Command) replay(result Result) Output {
+	var err error
+	if result.ExitCode != 0 {
+		args, argsErr := c.wrappedArgs()
+		if argsErr != nil {
+			return NewErrorOutput(argsErr)
+		}
+		executedCmd := c.executedCommand(args)
+		if secrets := getSecretRegistry(c.ctx); secrets != nil {
+			executedCmd = secrets.redactCommand(executedCmd)
+		}
+
+		err = &exitStatusError{code: result.ExitCode, stderr: result.Stderr}
+		err = mapExitCode(err, c.exitCodes)
+		err = classifyError(err, getErrorRules(c.ctx))
+		err = renderError(c.ctx, executedCmd, err)
+	}
+	return newBufferedOutput(c.ctx, result.Output, err)
+}
@@ -0,0 +1,151 @@
+package run
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPResultCache is a ResultCache backed by a remote HTTP server, which allows command
+// results to be shared across machines.
+//
+// Results are retrieved with 'GET <url>/<fingerprint>', which should respond with the
+// JSON-encoded Result, or with status 404 if there is no result. Results are stored with
+// 'PUT <url>/<fingerprint>' and a JSON-encoded Result as the request body. Such a server
+// can be implemented using ResultCacheHandler.
+type HTTPResultCache struct {
+	url    string
+	client *http.Client
+	clock  Clock
+}
+
+var _ ResultCache = &HTTPResultCache{}
+
+// NewHTTPResultCache creates a ResultCache that stores results on the server at baseURL.
+// If client is nil, a client with a timeout of 30 seconds is used. Use a custom client
+// to configure e.g. authentication.
+func NewHTTPResultCache(baseURL string, client *http.Client) *HTTPResultCache {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &HTTPResultCache{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		client: client,
+		clock:  systemClock{},
+	}
+}
+
+// WithClock configures the cache to use clock to determine if results have expired, for
+// example to make tests of expiry deterministic. Set to nil to use the system clock
+// (default).
+func (c *HTTPResultCache) WithClock(clock Clock) *HTTPResultCache {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	c.clock = clock
+	return c
+}
+
+// Get retrieves the result for fingerprint from the server. Errors are treated as if
+// there is no result.
+func (c *HTTPResultCache) Get(fingerprint string) (Result, bool) {
+	resp, err := c.client.Get(c.resultURL(fingerprint))
+	if err != nil {
+		return Result{}, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, false
+	}
+
+	var result Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Result{}, false
+	}
+	if result.Expired(c.clock.Now()) {
+		return Result{}, false
+	}
+	return result, true
+}
+
+// Put stores result for fingerprint on the server.
+func (c *HTTPResultCache) Put(fingerprint string, result Result) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, c.resultURL(fingerprint), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d storing result: %s",
+			resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (c *HTTPResultCache) resultURL(fingerprint string) string {
+	return c.url + "/" + url.PathEscape(fingerprint)
+}
+
+// maxResultCacheRequestBytes is the maximum size of a result that ResultCacheHandler
+// accepts.
+const maxResultCacheRequestBytes = 64 << 20
+
+// ResultCacheHandler creates an http.Handler that serves results from cache to
+// HTTPResultCache clients. The handler expects to serve requests at the root path, so it
+// should be used with http.StripPrefix if it is served on a subpath.
+//
+// Requests for fingerprints that are not hex-encoded SHA-256 digests, as returned by
+// (*Command).Fingerprint, are rejected, as are results larger than 64 MiB.
+func ResultCacheHandler(cache ResultCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fingerprint := strings.TrimPrefix(r.URL.Path, "/")
+		if !isDigest(fingerprint) {
+			http.Error(w, "invalid fingerprint", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			result, ok := cache.Get(fingerprint)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(result)
+
+		case http.MethodPut:
+			var result Result
+			body := http.MaxBytesReader(w, r.Body, maxResultCacheRequestBytes)
+			if err := json.NewDecoder(body).Decode(&result); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := cache.Put(fingerprint, result); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+package run_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/sourcegraph/run"
+)
+
+func TestResultStore(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	c.Run("RunCached", func(c *qt.C) {
+		store, err := run.NewResultStore(c.TempDir(), 0)
+		c.Assert(err, qt.IsNil)
+
+		// Each execution appends to a file, so we can count executions.
+		counter := filepath.Join(c.TempDir(), "counter")
+		cmd := func() *run.Command {
+			return run.Bash(ctx, "echo run >> "+counter+"; echo hello")
+		}
+		for i := 0; i < 3; i++ {
+			out, err := cmd().RunCached(store, time.Hour).String()
+			c.Assert(err, qt.IsNil)
+			c.Assert(out, qt.Equals, "hello")
+		}
+		b, err := os.ReadFile(counter)
+		c.Assert(err, qt.IsNil)
+		c.Assert(strings.Count(string(b), "run"), qt.Equals, 1)
+	})
+
+	c.Run("RunCached with exit code", func(c *qt.C) {
+		store, err := run.NewResultStore(c.TempDir(), 0)
+		c.Assert(err, qt.IsNil)
+
+		for i := 0; i < 2; i++ {
+			out, err := run.Bash(ctx, "echo failed; echo oh no >&2; exit 3").StdOut().
+				RunCached(store, time.Hour).Lines()
+			c.Assert(err, qt.ErrorMatches, "exit status 3: oh no")
+			c.Assert(run.ExitCode(err), qt.Equals, 3)
+			c.Assert(out, qt.CmpEquals(), []string{"failed"})
+		}
+	})
+
+	c.Run("RunCached with error handling", func(c *qt.C) {
+		store, err := run.NewResultStore(c.TempDir(), 0)
+		c.Assert(err, qt.IsNil)
+
+		errNotFound := errors.New("not found")
+		ctx := run.ClassifyErrors(ctx, []run.ErrorRule{
+			{Pattern: regexp.MustCompile("oh no"), Category: "test"},
+		})
+		for i := 0; i < 2; i++ {
+			err := run.Bash(ctx, "echo oh no >&2; exit 3").
+				MapExitCodes(map[int]error{3: errNotFound}).
+				RunCached(store, time.Hour).Wait()
+			c.Assert(errors.Is(err, errNotFound), qt.IsTrue, qt.Commentf("got %v", err))
+			c.Assert(run.ExitCode(err), qt.Equals, 3)
+			rule, ok := run.ErrorClassification(err)
+			c.Assert(ok, qt.IsTrue)
+			c.Assert(rule.Category, qt.Equals, "test")
+		}
+	})
+
+	c.Run("RunCached does not store terminated commands", func(c *qt.C) {
+		store, err := run.NewResultStore(c.TempDir(), 0)
+		c.Assert(err, qt.IsNil)
+
+		err = run.Cmd(ctx, "sleep 10").Timeout(10*time.Millisecond).
+			RunCached(store, time.Hour).Wait()
+		c.Assert(errors.Is(err, run.ErrTimeout), qt.IsTrue, qt.Commentf("got %v", err))
+
+		fingerprint, err := run.Cmd(ctx, "sleep 10").Timeout(10 * time.Millisecond).Fingerprint()
+		c.Assert(err, qt.IsNil)
+		_, ok := store.Get(fingerprint)
+		c.Assert(ok, qt.IsFalse)
+	})
+
+	c.Run("RunCached without expiry", func(c *qt.C) {
+		store, err := run.NewResultStore(c.TempDir(), 0)
+		c.Assert(err, qt.IsNil)
+
+		_, err = run.Cmd(ctx, "echo hello").RunCached(store, 0).String()
+		c.Assert(err, qt.IsNil)
+
+		fingerprint, err := run.Cmd(ctx, "echo hello").Fingerprint()
+		c.Assert(err, qt.IsNil)
+		result, ok := store.Get(fingerprint)
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(result.ExpiresAt.IsZero(), qt.IsTrue)
+	})
+
+	c.Run("expiry", func(c *qt.C) {
+		clock := &fixedClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+		store, err := run.NewResultStore(c.TempDir(), 0)
+		c.Assert(err, qt.IsNil)
+		store.WithClock(clock)
+
+		c.Assert(store.Put(testFingerprint("expiring"), run.Result{
+			Output:    []byte("hello"),
+			ExpiresAt: clock.now.Add(time.Minute),
+		}), qt.IsNil)
+		_, ok := store.Get(testFingerprint("expiring"))
+		c.Assert(ok, qt.IsTrue)
+		clock.now = clock.now.Add(time.Minute)
+		_, ok = store.Get(testFingerprint("expiring"))
+		c.Assert(ok, qt.IsFalse)
+
+		c.Assert(store.Put(testFingerprint("valid"), run.Result{Output: []byte("hello")}), qt.IsNil)
+		result, ok := store.Get(testFingerprint("valid"))
+		c.Assert(ok, qt.IsTrue)
+		c.Assert(string(result.Output), qt.Equals, "hello")
+	})
+
+	c.Run("size eviction", func(c *qt.C) {
+		store, err := run.NewResultStore(c.TempDir(), 200)
+		c.Assert(err, qt.IsNil)
+
+		c.Assert(store.Put(testFingerprint("first"), run.Result{Output: []byte("hello")}), qt.IsNil)
+		time.Sleep(10 * time.Millisecond) // ensure modification times differ
+		c.Assert(store.Put(testFingerprint("second"), run.Result{Output: []byte("world")}), qt.IsNil)
+
+		_, ok := store.Get(testFingerprint("first"))
+		c.Assert(ok, qt.IsFalse)
+		_, ok = store.Get(testFingerprint("second"))
+		c.Assert(ok, qt.IsTrue)
+	})
+
+	c.Run("invalid fingerprints", func(c *qt.C) {
+		dir := c.TempDir()
+		store, err := run.NewResultStore(filepath.Join(dir, "store"), 0)
+		c.Assert(err, qt.IsNil)
+
+		for _, fingerprint := range []string{"", "../escaped", `..\..\escaped`, "abc"} {
+			err := store.Put(fingerprint, run.Result{Output: []byte("hello")})
+			c.Assert(err, qt.ErrorMatches, "invalid fingerprint.*")
+			_, ok := store.Get(fingerprint)
+			c.Assert(ok, qt.IsFalse)
+		}
+		_, err = os.Stat(filepath.Join(dir, "escaped.json"))
+		c.Assert(os.IsNotExist(err), qt.IsTrue)
+	})
+}
+
+// testFingerprint returns a valid fingerprint derived from s.
+func testFingerprint(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// fixedClock is a run.Clock that reports a fixed time, which can be changed by tests.
+type fixedClock struct{ now time.Time }
+
+func (c *fixedClock) Now() time.Time { return c.now }
+
+func (c *fixedClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func TestHTTPResultCache(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	store, err := run.NewResultStore(c.TempDir(), 0)
+	c.Assert(err, qt.IsNil)
+	server := httptest.NewServer(http.StripPrefix("/results", run.ResultCacheHandler(store)))
+	c.Cleanup(server.Close)
+
+	cache := run.NewHTTPResultCache(server.URL+"/results/", nil)
+
+	_, ok := cache.Get(testFingerprint("missing"))
+	c.Assert(ok, qt.IsFalse)
+
+	out, err := run.Cmd(ctx, "echo hello").RunCached(cache, time.Hour).String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "hello")
+
+	// The result should have been stored remotely.
+	fingerprint, err := run.Cmd(ctx, "echo hello").Fingerprint()
+	c.Assert(err, qt.IsNil)
+	result, ok := cache.Get(fingerprint)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(string(result.Output), qt.Equals, "hello\n")
+	_, ok = store.Get(fingerprint)
+	c.Assert(ok, qt.IsTrue)
+
+	c.Run("expiry", func(c *qt.C) {
+		clock := &fixedClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+		cache := run.NewHTTPResultCache(server.URL+"/results/", nil).WithClock(clock)
+
+		// The server's store uses the system clock, so store a result that has not
+		// expired yet by its clock.
+		c.Assert(cache.Put(testFingerprint("expiring"), run.Result{
+			Output:    []byte("hello"),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}), qt.IsNil)
+		_, ok := cache.Get(testFingerprint("expiring"))
+		c.Assert(ok, qt.IsTrue)
+		clock.now = time.Now().Add(time.Hour)
+		_, ok = cache.Get(testFingerprint("expiring"))
+		c.Assert(ok, qt.IsFalse)
+	})
+
+	c.Run("invalid fingerprints", func(c *qt.C) {
+		for _, fingerprint := range []string{"abc", "..%5C..%5Cescaped", url.PathEscape("../escaped")} {
+			req, err := http.NewRequest(http.MethodPut, server.URL+"/results/"+fingerprint, strings.NewReader("{}"))
+			c.Assert(err, qt.IsNil)
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.IsNil)
+			resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+		}
+	})
+
+	c.Run("oversized results", func(c *qt.C) {
+		// Whitespace is valid JSON until the body exceeds the limit.
+		body := io.LimitReader(spaceReader{}, 65<<20)
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/results/"+testFingerprint("large"), body)
+		c.Assert(err, qt.IsNil)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, qt.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+	})
+}
+
+// spaceReader provides an endless stream of spaces.
+type spaceReader struct{}
+
+func (spaceReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	return len(p), nil
+}
@@ -0,0 +1,59 @@
+package run
+
+// SudoOpts configures how a command is run with sudo.
+type SudoOpts struct {
+	// User is the user to run the command as with '-u'. If empty, sudo runs the command
+	// as root.
+	User string
+	// NonInteractive makes sudo fail with '-n' instead of prompting for a password, which
+	// is useful for commands that should never block on user input.
+	NonInteractive bool
+	// Password, if set, is provided to sudo on stdin with '-S', ahead of any input to the
+	// command. Cached credentials are ignored with '-k', so that the password is always
+	// read by sudo instead of being passed on to the command. The password is never
+	// captured by CaptureInput, and is registered as a secret if the command's context
+	// is configured with RedactSecrets.
+	Password string
+}
+
+// Sudo configures cmd to be run as root with sudo. It is equivalent to cmd.Sudo("").
+func Sudo(cmd *Command) *Command {
+	return cmd.Sudo("")
+}
+
+// Sudo configures the command to be run as the given user with sudo. If user is empty,
+// the command is run as root. To configure sudo further, use SudoWith.
+func (c *Command) Sudo(user string) *Command {
+	return c.SudoWith(SudoOpts{User: user})
+}
+
+// SudoWith configures the command to be run with sudo. The command is wrapped with sudo
+// when it is run, so the sudo invocation is included in the arguments provided to
+// LogCommands, TraceCommands, and similar, and in Command.String.
+//
+// Environment variables set on the command are set for sudo itself, and are only
+// provided to the command if permitted by the sudo security policy.
+func (c *Command) SudoWith(opts SudoOpts) *Command {
+	if opts.Password != "" && c.ctx != nil {
+		Secret(c.ctx, opts.Password)
+	}
+	c.sudo = &opts
+	return c
+}
+
+// wrap prepends the sudo invocation to args.
+func (s *SudoOpts) wrap(args []string) []string {
+	wrapped := []string{"sudo"}
+	if s.NonInteractive {
+		wrapped = append(wrapped, "-n")
+	}
+	if s.Password != "" {
+		// Read the password from stdin without writing a prompt to output.
+		wrapped = append(wrapped, "-k", "-S", "-p", "")
+	}
+	if s.User != "" {
+		wrapped = append(wrapped, "-u", s.User)
+	}
+	wrapped = append(wrapped, "--")
+	return append(wrapped, args...)
+}
@@ -0,0 +1,51 @@
+package run
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// SysProcAttr configures the command to be started with a copy of the given
+// platform-specific attributes, for needs that are not covered by other options. Options
+// such as NewProcessGroup, Credential, and NoNetwork are applied on top of attr.
+func (c *Command) SysProcAttr(attr *syscall.SysProcAttr) *Command {
+	c.sysProcAttr = newSysProcAttr(attr)
+	return c
+}
+
+// NewProcessGroup starts the command in a new process group, so that signals delivered
+// to the process group of the current process, such as from pressing Ctrl-C in a
+// terminal, are not delivered to the command. When the command is terminated, for
+// example because its context is done, all processes in its process group are
+// terminated. To do this for all commands, use KillProcessGroups.
+//
+// On Windows, commands in a new process group no longer receive Ctrl-C from the console,
+// but can be interrupted with a CTRL_BREAK event, for example with os.Interrupt and
+// GracefulShutdown or (*Process).Signal.
+func (c *Command) NewProcessGroup() *Command {
+	c.prepare = append(c.prepare, func(cmd *exec.Cmd) error {
+		setNewProcessGroup(sysProcAttr(cmd))
+		return nil
+	})
+	return c
+}
+
+// Credential starts the command as the user with the given uid, gid, and supplementary
+// groups, which usually requires privileges.
+//
+// Credential is not supported on Windows.
+func (c *Command) Credential(uid, gid uint32, groups ...uint32) *Command {
+	c.prepare = append(c.prepare, func(cmd *exec.Cmd) error {
+		return setCredential(sysProcAttr(cmd), uid, gid, groups)
+	})
+	return c
+}
+
+// newSysProcAttr returns a copy of attr, or nil.
+func newSysProcAttr(attr *syscall.SysProcAttr) *syscall.SysProcAttr {
+	if attr == nil {
+		return nil
+	}
+	copied := *attr
+	return &copied
+}
@@ -0,0 +1,176 @@
+package run_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/sourcegraph/run"
+)
+
+func TestSysProcAttr(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// Prints the process group ID followed by the process ID of the shell
+	const pgidCmd = `read -a f < /proc/$$/stat; echo "${f[4]} $$"`
+	inOwnGroup := func(c *qt.C, out string) bool {
+		fields := strings.Fields(out)
+		c.Assert(fields, qt.HasLen, 2)
+		return fields[0] == fields[1]
+	}
+
+	c.Run("default", func(c *qt.C) {
+		out, err := run.Bash(ctx, pgidCmd).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(inOwnGroup(c, out), qt.IsFalse)
+	})
+
+	c.Run("SysProcAttr", func(c *qt.C) {
+		out, err := run.Bash(ctx, pgidCmd).SysProcAttr(&syscall.SysProcAttr{Setpgid: true}).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(inOwnGroup(c, out), qt.IsTrue)
+	})
+
+	c.Run("NewProcessGroup", func(c *qt.C) {
+		out, err := run.Bash(ctx, pgidCmd).NewProcessGroup().Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(inOwnGroup(c, out), qt.IsTrue)
+	})
+
+	c.Run("Credential", func(c *qt.C) {
+		if os.Getuid() != 0 {
+			c.Skip("requires root")
+		}
+		out, err := run.Cmd(ctx, "id -u").Credential(65534, 65534).Run().String()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.Equals, "65534")
+	})
+}
+
+func TestKillProcessGroups(t *testing.T) {
+	c := qt.New(t)
+
+	// alive checks if the process is running, treating zombies as exited
+	alive := func(pid string) bool {
+		stat, err := os.ReadFile("/proc/" + pid + "/stat")
+		if err != nil {
+			return false
+		}
+		fields := strings.Fields(string(stat))
+		return len(fields) > 2 && fields[2] != "Z"
+	}
+
+	ctx, cancel := context.WithCancel(run.KillProcessGroups(context.Background()))
+	start := time.Now()
+	var child string
+	err := run.Bash(ctx, `sleep 30 & echo $!; wait`).Run().StreamLines(func(line string) {
+		child = line
+		cancel()
+	})
+	c.Assert(errors.Is(err, run.ErrCanceled), qt.IsTrue, qt.Commentf("got %v", err))
+	c.Assert(child, qt.Not(qt.Equals), "")
+	// Output would not be complete until the spawned process exits if it were left
+	// running with a copy of stdout.
+	c.Assert(time.Since(start) < 10*time.Second, qt.IsTrue)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for alive(child) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(alive(child), qt.IsFalse, qt.Commentf("process spawned by command is still running"))
+}
+
+func TestCgroup(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		err := run.Cmd(ctx, "true").Cgroup(run.CgroupLimits{PIDs: 10}).Run().Wait()
+		c.Assert(err, qt.ErrorMatches, "Cgroup requires cgroup v2.*")
+		return
+	}
+	if os.Getuid() != 0 {
+		c.Skip("requires root")
+	}
+
+	c.Run("limits are applied", func(c *qt.C) {
+		controllers, err := os.ReadFile("/sys/fs/cgroup/cgroup.controllers")
+		c.Assert(err, qt.IsNil)
+		available := " " + strings.Join(strings.Fields(string(controllers)), " ") + " "
+		if !strings.Contains(available, " memory ") || !strings.Contains(available, " pids ") {
+			c.Skip("requires the memory and pids cgroup v2 controllers")
+		}
+
+		out, err := run.Bash(ctx, `cgroup=$(grep '^0::' /proc/self/cgroup | cut -d: -f3)
+echo "$cgroup"
+cat "/sys/fs/cgroup$cgroup/memory.max" "/sys/fs/cgroup$cgroup/pids.max"`).
+			Cgroup(run.CgroupLimits{Memory: 64 << 20, PIDs: 10, Parent: "/"}).
+			Run().Lines()
+		c.Assert(err, qt.IsNil)
+		c.Assert(out, qt.HasLen, 3)
+		c.Assert(out[0], qt.Matches, "/run-.*")
+		c.Assert(out[1:], qt.DeepEquals, []string{"67108864", "10"})
+
+		// The transient cgroup is removed once the command exits.
+		_, err = os.Stat(filepath.Join("/sys/fs/cgroup", out[0]))
+		c.Assert(os.IsNotExist(err), qt.IsTrue)
+	})
+
+	c.Run("parent required", func(c *qt.C) {
+		err := run.Cmd(ctx, "true").Cgroup(run.CgroupLimits{PIDs: 10}).Run().Wait()
+		c.Assert(err, qt.ErrorMatches, ".*Cgroup requires CgroupLimits.Parent.*")
+	})
+}
+
+func TestForwardSignals(t *testing.T) {
+	c := qt.New(t)
+
+	var lines []string
+	// The signal is delivered to the whole process group, so avoid spawning processes
+	// that would report being terminated by it.
+	err := run.Bash(context.Background(), `trap 'echo forwarded; exit 0' USR1; echo ready; while true; do :; done`).
+		ForwardSignals(syscall.SIGUSR1).
+		Run().
+		StreamLines(func(line string) {
+			lines = append(lines, line)
+			if line == "ready" {
+				c.Assert(syscall.Kill(os.Getpid(), syscall.SIGUSR1), qt.IsNil)
+			}
+		})
+	c.Assert(err, qt.IsNil)
+	c.Assert(lines, qt.DeepEquals, []string{"ready", "forwarded"})
+
+	// Commands forwarded signals lead their own process group, so that they do not
+	// receive signals delivered to the current process group twice.
+	out, err := run.Bash(context.Background(), `[ "$(cut -d' ' -f5 /proc/$$/stat)" = "$$" ] && echo leader`).
+		ForwardSignals().
+		Run().String()
+	c.Assert(err, qt.IsNil)
+	c.Assert(out, qt.Equals, "leader")
+}
+
+func TestProcessSignal(t *testing.T) {
+	c := qt.New(t)
+
+	p, err := run.Bash(context.Background(), `trap 'echo signalled; exit 0' USR1; echo ready; while true; do sleep 0.01; done`).Start()
+	c.Assert(err, qt.IsNil)
+
+	var lines []string
+	err = p.Output().StreamLines(func(line string) {
+		lines = append(lines, line)
+		if line == "ready" {
+			c.Assert(p.Signal(syscall.SIGUSR1), qt.IsNil)
+		}
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(lines, qt.DeepEquals, []string{"ready", "signalled"})
+	c.Assert(p.Signal(syscall.SIGUSR1), qt.ErrorIs, os.ErrProcessDone)
+}
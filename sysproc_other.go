@@ -0,0 +1,19 @@
+//go:build !windows
+
+package run
+
+import "syscall"
+
+func setNewProcessGroup(attr *syscall.SysProcAttr) {
+	attr.Setpgid = true
+	attr.Pgid = 0
+}
+
+func setCredential(attr *syscall.SysProcAttr, uid, gid uint32, groups []uint32) error {
+	attr.Credential = &syscall.Credential{
+		Uid:    uid,
+		Gid:    gid,
+		Groups: groups,
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package run
+
+import (
+	"errors"
+	"syscall"
+)
+
+func setNewProcessGroup(attr *syscall.SysProcAttr) {
+	attr.CreationFlags |= createNewProcessGroup
+}
+
+func setCredential(*syscall.SysProcAttr, uint32, uint32, []uint32) error {
+	return errors.New("Credential is not supported on Windows")
+}
@@ -0,0 +1,126 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// tailPollInterval is how often TailFile checks for new content.
+const tailPollInterval = 100 * time.Millisecond
+
+// TailFile follows the file at path as it grows, similar to 'tail -f', and provides its
+// content through Output. This allows output that is redirected to files, for example
+// by daemons, to be consumed like the output of a command, e.g. with Map or StreamLines.
+//
+// The file is read from the beginning. If it does not exist yet, TailFile waits for it
+// to be created, and if it is truncated, TailFile follows it from the beginning again.
+// Following stops when ctx is done or the Output is closed, once remaining content has
+// been read, after which the Output returns ErrCanceled, ErrTimeout, or ErrClosed.
+func TailFile(ctx context.Context, path string) Output {
+	t := &fileTailer{
+		clock: getClock(ctx),
+		path:  path,
+		done:  make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.stop(terminationCause(ctx, false))
+		case <-t.done:
+		}
+	}()
+
+	return newReaderOutput(ctx, t,
+		func() error {
+			<-t.done
+			return t.cause()
+		},
+		t.close)
+}
+
+// fileTailer is an io.Reader that follows a growing file until done is closed.
+type fileTailer struct {
+	clock Clock
+	path  string
+
+	file   *os.File
+	offset int64
+
+	// done is closed when following should stop.
+	done     chan struct{}
+	stopOnce sync.Once
+	// err is the reason following was stopped, and is set before done is closed.
+	err error
+}
+
+// stop stops following because of err, unless following has already stopped.
+func (t *fileTailer) stop(err error) {
+	t.stopOnce.Do(func() {
+		t.err = err
+		close(t.done)
+	})
+}
+
+func (t *fileTailer) close() { t.stop(ErrClosed) }
+
+// cause returns the reason following was stopped, and should only be called once done
+// is closed.
+func (t *fileTailer) cause() error { return t.err }
+
+func (t *fileTailer) Read(p []byte) (int, error) {
+	for {
+		if t.file == nil {
+			f, err := os.Open(t.path)
+			switch {
+			case err == nil:
+				t.file = f
+				t.offset = 0
+			case !errors.Is(err, os.ErrNotExist):
+				t.stop(err)
+				return 0, err
+			}
+		}
+
+		if t.file != nil {
+			n, err := t.file.Read(p)
+			t.offset += int64(n)
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				t.closeFile()
+				t.stop(err)
+				return 0, err
+			}
+
+			// Start from the beginning if the file has been truncated.
+			if info, err := os.Stat(t.path); err == nil && info.Size() < t.offset {
+				t.closeFile()
+				continue
+			}
+		}
+
+		// No new content is available, so wait for more unless we are done.
+		select {
+		case <-t.done:
+			t.closeFile()
+			return 0, t.cause()
+		default:
+		}
+		select {
+		case <-t.done:
+		case <-t.clock.After(tailPollInterval):
+		}
+	}
+}
+
+func (t *fileTailer) closeFile() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
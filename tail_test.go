@@ -0,0 +1,59 @@
+package run_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/sourcegraph/run"
+)
+
+func TestTailFile(t *testing.T) {
+	c := qt.New(t)
+
+	c.Run("follow", func(c *qt.C) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// The file does not exist yet
+		path := filepath.Join(c.TempDir(), "out.log")
+		out := run.TailFile(ctx, path)
+
+		go func() {
+			f, err := os.Create(path)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(f, `{"line":%d}`+"\n", i)
+			}
+		}()
+
+		jqMap, err := run.MapJQ(".line")
+		c.Assert(err, qt.IsNil)
+
+		var lines []string
+		err = out.Map(jqMap).StreamLines(func(line string) {
+			lines = append(lines, line)
+			if len(lines) == 3 {
+				cancel()
+			}
+		})
+		c.Assert(errors.Is(err, run.ErrCanceled), qt.IsTrue, qt.Commentf("got %v", err))
+		c.Assert(lines, qt.CmpEquals(), []string{"0", "1", "2"})
+	})
+
+	c.Run("close", func(c *qt.C) {
+		path := filepath.Join(c.TempDir(), "out.log")
+		c.Assert(os.WriteFile(path, []byte("hello\n"), 0o644), qt.IsNil)
+
+		// Close should stop following the file
+		out := run.TailFile(context.Background(), path)
+		c.Assert(out.Close(), qt.IsNil)
+	})
+}
@@ -0,0 +1,101 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateQuoteFunc is the name of the template function that quotes the output of each
+// action in a Template.
+const templateQuoteFunc = "_runQuote"
+
+// Template is a command with named placeholders, defined once and instantiated with
+// different values. Templates use the syntax of text/template:
+//
+//	clone, err := run.NewTemplate("git clone {{.URL}} {{.Dir}}")
+//	// ...
+//	err = clone.Cmd(ctx, map[string]string{"URL": url, "Dir": dir}).Run().Wait()
+//
+// The output of each action is quoted with Arg, such that each placeholder gets treated
+// as a single argument regardless of its value. Slices of strings are quoted with
+// ArgList, such that each element gets treated as a separate argument.
+type Template struct {
+	text string
+	tmpl *template.Template
+}
+
+// NewTemplate parses text as a Template. Referring to keys that are missing from maps
+// provided as values is an error.
+func NewTemplate(text string) (*Template, error) {
+	tmpl, err := template.New("command").
+		Funcs(template.FuncMap{templateQuoteFunc: quoteTemplateValue}).
+		Option("missingkey=error").
+		Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			quoteTemplateActions(t.Tree.Root)
+		}
+	}
+	return &Template{text: text, tmpl: tmpl}, nil
+}
+
+// Cmd instantiates the template with data, which is typically a struct or a map, and
+// builds a command from the result as Cmd does.
+func (t *Template) Cmd(ctx context.Context, data interface{}) *Command {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return &Command{ctx: ctx, buildError: err}
+	}
+	return Cmd(ctx, b.String())
+}
+
+// String returns the template text.
+func (t *Template) String() string {
+	return t.text
+}
+
+// quoteTemplateValue quotes a value written by a template action.
+func quoteTemplateValue(v interface{}) string {
+	if vs, ok := v.([]string); ok {
+		return ArgList(vs...)
+	}
+	return Arg(fmt.Sprint(v))
+}
+
+// quoteTemplateActions pipes the output of every action within node to
+// templateQuoteFunc.
+func quoteTemplateActions(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 {
+			return // variable declarations do not produce output
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(templateQuoteFunc).SetPos(n.Pos)},
+		})
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			quoteTemplateActions(child)
+		}
+	case *parse.IfNode:
+		quoteTemplateActions(n.List)
+		quoteTemplateActions(n.ElseList)
+	case *parse.RangeNode:
+		quoteTemplateActions(n.List)
+		quoteTemplateActions(n.ElseList)
+	case *parse.WithNode:
+		quoteTemplateActions(n.List)
+		quoteTemplateActions(n.ElseList)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package run
+
+import (
+	"fmt"
+	"os"
+)
+
+// umaskScript sets the umask given as the first argument, and executes the remaining
+// arguments.
+const umaskScript = `umask "$0" && exec "$@"`
+
+// umaskWrap wraps args with a shell that sets the given umask before executing the
+// command, since the umask is process-wide and cannot be set for a child process alone.
+func umaskWrap(mask os.FileMode, args []string) ([]string, error) {
+	wrapped := []string{"sh", "-c", umaskScript, fmt.Sprintf("%04o", mask&0o777)}
+	return append(wrapped, args...), nil
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package run
+
+import (
+	"errors"
+	"os"
+)
+
+func umaskWrap(mask os.FileMode, args []string) ([]string, error) {
+	return nil, errors.New("Umask is not supported on Windows")
+}